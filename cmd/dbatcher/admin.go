@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/edwvee/dbatcher/internal/spool"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+//makeAdminHandler returns handler of admin HTTP API:
+//GET /spool - spool's status in JSON,
+//GET /tables - table managers' states in JSON,
+//POST /tables/flush - insert rows of table by key parameter or of all tables,
+//POST /tables/pause, /tables/resume, /tables/stop - control table by key parameter
+func makeAdminHandler(s *spool.Spool, tmh *tablemanager.Holder) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/spool", func(w http.ResponseWriter, r *http.Request) {
+		handleSpoolStatus(w, r, s)
+	})
+	mux.HandleFunc("/tables", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "HTTP method should be GET", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, tmh.ListTables())
+	})
+	mux.HandleFunc("/tables/flush", func(w http.ResponseWriter, r *http.Request) {
+		handleTableAction(w, r, func(key string) error {
+			if key == "" {
+				return joinErrors(tmh.FlushTables())
+			}
+			return tmh.FlushTable(key)
+		})
+	})
+	tableActions := map[string]func(key string) error{
+		"/tables/pause":  tmh.PauseTable,
+		"/tables/resume": tmh.ResumeTable,
+		"/tables/stop":   tmh.StopTable,
+	}
+	for path, action := range tableActions {
+		action := action
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("key") == "" {
+				http.Error(w, "key parameter is required", http.StatusBadRequest)
+				return
+			}
+			handleTableAction(w, r, action)
+		})
+	}
+
+	return mux
+}
+
+//handleTableAction calls action with key parameter. Responds 404
+//if there is no such table, 502 if insert failed or table didn't stop in time
+func handleTableAction(w http.ResponseWriter, r *http.Request, action func(key string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "HTTP method should be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	err := action(r.URL.Query().Get("key"))
+	switch {
+	case errors.Is(err, tablemanager.ErrTableManagerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		w.Write([]byte("ok"))
+	}
+}
+
+//joinErrors returns nil if errs is empty
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+func handleSpoolStatus(w http.ResponseWriter, r *http.Request, s *spool.Spool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "HTTP method should be GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if s == nil {
+		http.Error(w, "spool is not configured", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.Status())
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	data, err := jsoniter.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/spool"
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestAdminSpoolStatus(t *testing.T) {
+	get := func(handler http.Handler, method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, "/spool", nil))
+		return recorder
+	}
+
+	if code := get(makeAdminHandler(nil, nil), http.MethodGet).Code; code != http.StatusNotFound {
+		t.Errorf("code should be 404 without spool, got %d", code)
+	}
+
+	dir, err := ioutil.TempDir("", "dbatcher-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := spool.Open(spool.Config{Dir: dir, MaxBytes: 1024}, nil, inserter.NewInsertErrorLogger(nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := makeAdminHandler(s, nil)
+	if code := get(handler, http.MethodPost).Code; code != http.StatusMethodNotAllowed {
+		t.Errorf("code should be 405 for POST, got %d", code)
+	}
+	response := get(handler, http.MethodGet)
+	if response.Code != http.StatusOK {
+		t.Fatalf("code should be 200, got %d", response.Code)
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("content type should be JSON, got %s", contentType)
+	}
+	if body := response.Body.String(); !strings.Contains(body, `"max_bytes":1024`) || !strings.Contains(body, `"queues":[]`) {
+		t.Errorf("unexpected status: %s", body)
+	}
+}
+
+func TestAdminTables(t *testing.T) {
+	tmh := tablemanager.NewHolder(nil, map[string]inserter.Inserter{"dummy": &inserter.DummyInserter{}}, inserter.NewInsertErrorLogger(nil, false))
+	defer tmh.StopTableManagers()
+	ts := table.NewSignature("db.table", "field1")
+	if err := tmh.Append(&ts, tablemanager.NewConfig(60000, 100, false), false, []byte("[[1],[2]]")); err != nil {
+		t.Fatal(err)
+	}
+	handler := makeAdminHandler(nil, tmh)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		return recorder
+	}
+	list := func() []tablemanager.TableInfo {
+		response := do(http.MethodGet, "/tables")
+		if response.Code != http.StatusOK {
+			t.Fatalf("code should be 200, got %d", response.Code)
+		}
+		var infos []tablemanager.TableInfo
+		if err := jsoniter.Unmarshal(response.Body.Bytes(), &infos); err != nil {
+			t.Fatal(err)
+		}
+		return infos
+	}
+
+	infos := list()
+	if len(infos) != 1 || infos[0].BufferedRows != 2 || infos[0].TimeoutMs != 60000 || infos[0].LastInsert != nil {
+		t.Fatalf("unexpected tables: %+v", infos)
+	}
+	key := url.QueryEscape(infos[0].Key)
+	if code := do(http.MethodGet, "/tables/pause?key="+key).Code; code != http.StatusMethodNotAllowed {
+		t.Errorf("code should be 405 for GET, got %d", code)
+	}
+	if code := do(http.MethodPost, "/tables/pause").Code; code != http.StatusBadRequest {
+		t.Errorf("code should be 400 without key, got %d", code)
+	}
+	if code := do(http.MethodPost, "/tables/pause?key=unknown").Code; code != http.StatusNotFound {
+		t.Errorf("code should be 404 for unknown key, got %d", code)
+	}
+	if code := do(http.MethodPost, "/tables/pause?key="+key).Code; code != http.StatusOK {
+		t.Errorf("code should be 200 for pause, got %d", code)
+	}
+	if infos := list(); !infos[0].Paused {
+		t.Errorf("table should be paused: %+v", infos[0])
+	}
+	if code := do(http.MethodPost, "/tables/flush?key="+key).Code; code != http.StatusOK {
+		t.Errorf("code should be 200 for flush, got %d", code)
+	}
+	if infos := list(); infos[0].BufferedRows != 0 || infos[0].LastInsert == nil || infos[0].LastInsertError != "" {
+		t.Errorf("paused table should be flushed: %+v", infos[0])
+	}
+	if code := do(http.MethodPost, "/tables/resume?key="+key).Code; code != http.StatusOK {
+		t.Errorf("code should be 200 for resume, got %d", code)
+	}
+	if code := do(http.MethodPost, "/tables/flush").Code; code != http.StatusOK {
+		t.Errorf("code should be 200 for flush of all tables, got %d", code)
+	}
+	if code := do(http.MethodPost, "/tables/stop?key="+key).Code; code != http.StatusOK {
+		t.Errorf("code should be 200 for stop, got %d", code)
+	}
+	if infos := list(); len(infos) != 0 {
+		t.Errorf("stopped table shouldn't be listed: %+v", infos)
+	}
+}
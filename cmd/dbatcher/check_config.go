@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+const checkPingTimeout = 5 * time.Second
+
+func runCheckConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	configPath := flags.String("config", "config.toml", "config file to check")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	if code := checkConfigFile(*configPath, os.Stdout); code != 0 {
+		fatalf(code, "config %s has errors", *configPath)
+	}
+}
+
+//checkConfigFile parses config, inits and pings every inserter,
+//checks that bind addresses are free. Reports every check to w
+//and returns exit code of the first failed one or 0
+func checkConfigFile(configPath string, w io.Writer) int {
+	var c config
+	meta, err := toml.DecodeFile(configPath, &c)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL config %s: %s\n", configPath, err)
+		return exitConfigError
+	}
+	fmt.Fprintf(w, "ok   config %s\n", configPath)
+	for _, key := range meta.Undecoded() {
+		fmt.Fprintf(w, "WARN unknown key %s\n", key)
+	}
+
+	code := 0
+	report := func(failCode int, subject string, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %s\n", subject, err)
+			if code == 0 {
+				code = failCode
+			}
+			return
+		}
+		fmt.Fprintf(w, "ok   %s\n", subject)
+	}
+
+	inserterNames := []string{}
+	for name := range c.Inserters {
+		inserterNames = append(inserterNames, name)
+	}
+	sort.Strings(inserterNames)
+	for _, name := range inserterNames {
+		config := c.Inserters[name]
+		config.Name = name
+		failCode, err := checkInserter(config)
+		report(failCode, "inserter "+name, err)
+	}
+	if len(c.Tables) != 0 {
+		report(exitConfigError, "tables", checkTablePolicies(c))
+	}
+	if c.Buffer != (tablemanager.BufferConfig{}) {
+		report(exitConfigError, "buffer", c.Buffer.Validate())
+	}
+	if c.Receipts != (tablemanager.ReceiptsConfig{}) {
+		report(exitConfigError, "receipts", c.Receipts.Validate())
+	}
+	if c.Log != (logging.Config{}) {
+		report(exitConfigError, "log", c.Log.Validate())
+	}
+	if c.Tracing != (tracing.Config{}) {
+		report(exitConfigError, "tracing", c.Tracing.Validate())
+	}
+	if len(c.Routes) != 0 {
+		report(exitConfigError, "routes", checkRoutes(c))
+	}
+	receiverNames := []string{}
+	for name := range c.Receivers {
+		receiverNames = append(receiverNames, name)
+	}
+	sort.Strings(receiverNames)
+	for _, name := range receiverNames {
+		config := c.Receivers[name]
+		if _, err := makeReceiver(config); err != nil {
+			report(exitConfigError, "receiver "+name, err)
+			continue
+		}
+		report(exitBindError, fmt.Sprintf("receiver %s bind %s", name, config.Bind), checkBind(config.Bind))
+	}
+	if c.PprofHttpBind != "" {
+		report(exitBindError, "pprof_http_bind "+c.PprofHttpBind, checkBind(c.PprofHttpBind))
+	}
+	if c.MetricsHttpBind != "" {
+		report(exitBindError, "metrics_http_bind "+c.MetricsHttpBind, checkBind(c.MetricsHttpBind))
+	}
+	if c.AdminHttpBind != "" {
+		report(exitBindError, "admin_http_bind "+c.AdminHttpBind, checkBind(c.AdminHttpBind))
+	}
+
+	return code
+}
+
+//checkInserter inits inserter (which connects to it's database), pings
+//and closes it. Returns exit code for the error
+func checkInserter(config inserter.Config) (int, error) {
+	ins, err := makeInserter(config)
+	if err != nil {
+		return exitConfigError, err
+	}
+	if err := ins.Init(config); err != nil {
+		return exitInserterError, err
+	}
+	if closer, ok := ins.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if pinger, ok := ins.(inserter.Pinger); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), checkPingTimeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			return exitInserterError, errors.Wrap(err, "ping")
+		}
+	}
+
+	return 0, nil
+}
+
+//checkRoutes checks routes are valid and use inserters of config
+func checkRoutes(c config) error {
+	inserters := map[string]inserter.Inserter{}
+	for name := range c.Inserters {
+		inserters[name] = nil
+	}
+	_, err := makeRouter(c, inserters)
+
+	return err
+}
+
+//checkBind checks nothing listens on bind
+func checkBind(bind string) error {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		return err
+	}
+
+	return listener.Close()
+}
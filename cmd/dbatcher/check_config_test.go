@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "dbatcher-check-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	path := writeTestConfig(t, `
+[receivers.first-http]
+type = "http"
+bind = "127.0.0.1:0"
+[inserters.dummy]
+type = "dummy"
+`)
+	var out bytes.Buffer
+	if code := checkConfigFile(path, &out); code != 0 {
+		t.Errorf("code should be 0, got %d, output:\n%s", code, out.String())
+	}
+
+	if code := checkConfigFile(path+".absent", &out); code != exitConfigError {
+		t.Errorf("code should be %d for absent config, got %d", exitConfigError, code)
+	}
+
+	path = writeTestConfig(t, `
+[inserters.unknown]
+type = "oracle"
+`)
+	out.Reset()
+	if code := checkConfigFile(path, &out); code != exitConfigError {
+		t.Errorf("code should be %d for unknown inserter type, got %d", exitConfigError, code)
+	}
+	if !strings.Contains(out.String(), "FAIL inserter unknown") {
+		t.Errorf("should report failed inserter, output:\n%s", out.String())
+	}
+}
+
+func TestCheckConfigFileRoutes(t *testing.T) {
+	path := writeTestConfig(t, `
+[inserters.dummy]
+type = "dummy"
+[[routes]]
+database = "db"
+inserters = ["dummy"]
+[[routes]]
+table = "other.table"
+inserters = ["absent"]
+`)
+	var out bytes.Buffer
+	if code := checkConfigFile(path, &out); code != exitConfigError {
+		t.Errorf("code should be %d, got %d, output:\n%s", exitConfigError, code, out.String())
+	}
+	if !strings.Contains(out.String(), "FAIL routes") {
+		t.Errorf("should report failed routes, output:\n%s", out.String())
+	}
+}
+
+func TestCheckConfigFileBuffer(t *testing.T) {
+	path := writeTestConfig(t, `
+[inserters.dummy]
+type = "dummy"
+[buffer]
+max_buffered_bytes = 1024
+on_full = "drop"
+`)
+	var out bytes.Buffer
+	if code := checkConfigFile(path, &out); code != exitConfigError {
+		t.Errorf("code should be %d, got %d, output:\n%s", exitConfigError, code, out.String())
+	}
+	if !strings.Contains(out.String(), "FAIL buffer") {
+		t.Errorf("should report failed buffer, output:\n%s", out.String())
+	}
+}
+
+func TestCheckConfigFileTracing(t *testing.T) {
+	path := writeTestConfig(t, `
+[inserters.dummy]
+type = "dummy"
+[tracing]
+endpoint = "localhost:4318"
+`)
+	var out bytes.Buffer
+	if code := checkConfigFile(path, &out); code != exitConfigError {
+		t.Errorf("code should be %d, got %d, output:\n%s", exitConfigError, code, out.String())
+	}
+	if !strings.Contains(out.String(), "FAIL tracing") {
+		t.Errorf("should report failed tracing, output:\n%s", out.String())
+	}
+}
+
+func TestCheckConfigFileBusyBind(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	path := writeTestConfig(t, fmt.Sprintf(`
+[receivers.first-http]
+type = "http"
+bind = "%s"
+[inserters.dummy]
+type = "dummy"
+`, listener.Addr().String()))
+	var out bytes.Buffer
+	if code := checkConfigFile(path, &out); code != exitBindError {
+		t.Errorf("code should be %d, got %d, output:\n%s", exitBindError, code, out.String())
+	}
+	if !strings.Contains(out.String(), "ok   inserter dummy") {
+		t.Errorf("should report ok inserter, output:\n%s", out.String())
+	}
+}
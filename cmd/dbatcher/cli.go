@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/pkg/errors"
+)
+
+//Exit codes by failure class
+const (
+	exitRuntimeError  = 1 //fatal error while serving
+	exitUsage         = 2 //wrong command line
+	exitConfigError   = 3 //can't read, parse or validate config
+	exitInserterError = 4 //can't connect to an inserter's database
+	exitBindError     = 5 //can't listen on a bind address
+	exitStorageError  = 6 //can't open insert error log or write-ahead log
+)
+
+//version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+const usage = `Usage:
+  dbatcher serve [-config config.toml] [-log-level info] [-log-format text] [-pprof addr]
+  dbatcher check-config [-config config.toml]
+  dbatcher replay -log error.log [options]
+  dbatcher version
+
+dbatcher [config.toml] is the same as dbatcher serve -config config.toml
+`
+
+var commands = map[string]bool{
+	"serve":        true,
+	"check-config": true,
+	"replay":       true,
+	"version":      true,
+	"help":         true,
+}
+
+type serveOptions struct {
+	configPath string
+	//log overrides non empty values of [log] section of config
+	log       logging.Config
+	pprofBind string
+}
+
+//fatalf does the same as log.Fatalf, but exits with code
+func fatalf(code int, format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(code)
+}
+
+//splitCommand returns subcommand and it's arguments. Without subcommand
+//it's serve, first argument not being a flag is config path for compatibility
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "serve", nil
+	}
+	if commands[args[0]] {
+		return args[0], args[1:]
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+
+	return "serve", append([]string{"-config", args[0]}, args[1:]...)
+}
+
+func parseServeFlags(args []string) (options serveOptions, err error) {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.StringVar(&options.configPath, "config", "config.toml", "config file")
+	flags.StringVar(&options.log.Level, "log-level", "", "minimal log level: debug, info, warn or error, overrides level of [log] section of config (info by default)")
+	flags.StringVar(&options.log.Format, "log-format", "", "log format: text, logfmt or json, overrides format of [log] section of config (text by default)")
+	flags.StringVar(&options.pprofBind, "pprof", "", "address for pprof http, overrides pprof_http_bind of config")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 0 {
+		return options, errors.Errorf("serve: unexpected arguments %v", flags.Args())
+	}
+	if err = options.log.Validate(); err != nil {
+		return options, errors.Wrap(err, "serve: -log-level or -log-format")
+	}
+
+	return options, nil
+}
+
+func runServe(args []string) {
+	options, err := parseServeFlags(args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	c := getConfig(options.configPath)
+	if options.pprofBind != "" {
+		c.PprofHttpBind = options.pprofBind
+	}
+	if err := logging.Configure(mergeLogConfig(c.Log, options.log)); err != nil {
+		fatalf(exitConfigError, "log: %s", err)
+	}
+	serve(c, options.configPath, options.log)
+}
+
+//mergeLogConfig returns config with non empty values of override
+func mergeLogConfig(config, override logging.Config) logging.Config {
+	if override.Level != "" {
+		config.Level = override.Level
+	}
+	if override.Format != "" {
+		config.Format = override.Format
+	}
+
+	return config
+}
+
+func runVersion() {
+	fmt.Printf("dbatcher %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+//serveErrorExitCode classifies error that stopped serving
+func serveErrorExitCode(err error) int {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "listen" {
+		return exitBindError
+	}
+
+	return exitRuntimeError
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/pkg/errors"
+)
+
+func TestSplitCommand(t *testing.T) {
+	cases := []struct {
+		args            []string
+		expectedCommand string
+		expectedArgs    []string
+	}{
+		{nil, "serve", nil},
+		{[]string{"serve", "-config", "a.toml"}, "serve", []string{"-config", "a.toml"}},
+		{[]string{"check-config"}, "check-config", []string{}},
+		{[]string{"version"}, "version", []string{}},
+		{[]string{"replay", "-log", "error.log"}, "replay", []string{"-log", "error.log"}},
+		{[]string{"-config", "a.toml"}, "serve", []string{"-config", "a.toml"}},
+		//compatibility with config path as the only argument
+		{[]string{"a.toml"}, "serve", []string{"-config", "a.toml"}},
+	}
+	for _, c := range cases {
+		command, args := splitCommand(c.args)
+		if command != c.expectedCommand || !reflect.DeepEqual(args, c.expectedArgs) {
+			t.Errorf(
+				"%v: expected %s %v, got %s %v",
+				c.args, c.expectedCommand, c.expectedArgs, command, args,
+			)
+		}
+	}
+}
+
+func TestParseServeFlags(t *testing.T) {
+	options, err := parseServeFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := serveOptions{configPath: "config.toml"}
+	if options != expected {
+		t.Errorf("expected defaults %+v, got %+v", expected, options)
+	}
+
+	options, err = parseServeFlags([]string{
+		"-config", "a.toml", "-log-level", "warn", "-log-format", "json", "-pprof", "localhost:6060",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = serveOptions{
+		configPath: "a.toml",
+		log:        logging.Config{Level: "warn", Format: "json"},
+		pprofBind:  "localhost:6060",
+	}
+	if options != expected {
+		t.Errorf("expected %+v, got %+v", expected, options)
+	}
+
+	if _, err := parseServeFlags([]string{"-log-level", "loud"}); !errors.Is(err, logging.ErrUnknownLevel) {
+		t.Errorf("should return ErrUnknownLevel, got %v", err)
+	}
+	if _, err := parseServeFlags([]string{"-log-format", "xml"}); !errors.Is(err, logging.ErrUnknownFormat) {
+		t.Errorf("should return ErrUnknownFormat, got %v", err)
+	}
+	if _, err := parseServeFlags([]string{"-config", "a.toml", "extra"}); err == nil {
+		t.Error("should return error on extra arguments")
+	}
+}
+
+func TestMergeLogConfig(t *testing.T) {
+	config := logging.Config{Level: "debug", Format: "json"}
+	if merged := mergeLogConfig(config, logging.Config{}); merged != config {
+		t.Errorf("empty override shouldn't change config, got %+v", merged)
+	}
+	merged := mergeLogConfig(config, logging.Config{Level: "error"})
+	if expected := (logging.Config{Level: "error", Format: "json"}); merged != expected {
+		t.Errorf("expected %+v, got %+v", expected, merged)
+	}
+}
+
+func TestServeErrorExitCode(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, err = net.Listen("tcp", listener.Addr().String())
+	if code := serveErrorExitCode(err); code != exitBindError {
+		t.Errorf("bind error should have code %d, got %d", exitBindError, code)
+	}
+	if code := serveErrorExitCode(errors.New("some error")); code != exitRuntimeError {
+		t.Errorf("other errors should have code %d, got %d", exitRuntimeError, code)
+	}
+}
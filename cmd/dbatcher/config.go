@@ -2,12 +2,27 @@ package main
 
 import (
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
 	"github.com/edwvee/dbatcher/internal/receiver"
+	"github.com/edwvee/dbatcher/internal/spool"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/edwvee/dbatcher/internal/wal"
 )
 
 type config struct {
-	Receivers         map[string]receiver.Config       `toml:"receivers"`
-	Inserters         map[string]inserter.Config       `toml:"inserters"`
-	PprofHttpBind     string                           `toml:"pprof_http_bind"`
-	InsertErrorLogger inserter.InsertErrorLoggerConfig `toml:"insert_error_logger"`
+	Receivers         map[string]receiver.Config          `toml:"receivers"`
+	Inserters         map[string]inserter.Config          `toml:"inserters"`
+	PprofHttpBind     string                              `toml:"pprof_http_bind"`
+	MetricsHttpBind   string                              `toml:"metrics_http_bind"`
+	InsertErrorLogger inserter.InsertErrorLoggerConfig    `toml:"insert_error_logger"`
+	Persist           wal.Config                          `toml:"persist"`
+	Routes            []tablemanager.RouteConfig          `toml:"routes"`
+	Tables            map[string]tablemanager.TablePolicy `toml:"tables"`
+	Buffer            tablemanager.BufferConfig           `toml:"buffer"`
+	Receipts          tablemanager.ReceiptsConfig         `toml:"receipts"`
+	Spool             spool.Config                        `toml:"spool"`
+	AdminHttpBind     string                              `toml:"admin_http_bind"`
+	Log               logging.Config                      `toml:"log"`
+	Tracing           tracing.Config                      `toml:"tracing"`
 }
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -10,42 +10,134 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
 	"github.com/edwvee/dbatcher/internal/receiver"
+	"github.com/edwvee/dbatcher/internal/spool"
 	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/edwvee/dbatcher/internal/wal"
+	"github.com/pkg/errors"
+)
+
+var (
+	//ErrNoSuchInserterType means inserter's type in config is unknown
+	ErrNoSuchInserterType = errors.New("no such inserter type")
+	//ErrNoSuchReceiverType means receiver's type in config is unknown
+	ErrNoSuchReceiverType = errors.New("no such receiver type")
 )
 
 func main() {
-	//TODO: use flags
-	configPath := "config.toml"
-	if len(os.Args) > 1 {
-		configPath = os.Args[1]
+	command, args := splitCommand(os.Args[1:])
+	switch command {
+	case "serve":
+		runServe(args)
+	case "check-config":
+		runCheckConfig(args)
+	case "replay":
+		runReplay(args)
+	case "version":
+		runVersion()
+	case "help":
+		fmt.Print(usage)
 	}
-	c := getConfig(configPath)
+}
 
+//serve runs dbatcher until termination, configPath is re-read on SIGHUP,
+//logFlags override [log] section of config
+func serve(c config, configPath string, logFlags logging.Config) {
 	if c.PprofHttpBind != "" {
-		go http.ListenAndServe(c.PprofHttpBind, nil)
+		go listenAndServe("pprof", c.PprofHttpBind, nil)
+	}
+	if c.MetricsHttpBind != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go listenAndServe("metrics", c.MetricsHttpBind, mux)
 	}
 
+	tracer := enableTracing(c)
+
 	insertErrorLogger, err := inserter.NewInsertErrorLoggerFromConfig(c.InsertErrorLogger)
 	if err != nil {
-		log.Fatalf("can't open file for insert error logger: %s", err)
+		fatalf(exitStorageError, "can't open file for insert error logger: %s", err)
 	}
 	defer insertErrorLogger.Close()
 	inserters := makeInserters(c)
 	errChan := make(chan error)
 	tableManagerHolder := tablemanager.NewHolder(errChan, inserters, insertErrorLogger)
+	tableManagerHolder.SetLogger(logging.With("component", "tablemanager"))
+	router, err := makeRouter(c, inserters)
+	if err != nil {
+		fatalf(exitConfigError, "%s", err)
+	}
+	tableManagerHolder.SetRouter(router)
+	if err := checkTablePolicies(c); err != nil {
+		fatalf(exitConfigError, "%s", err)
+	}
+	tableManagerHolder.SetTablePolicies(c.Tables)
+	if err := tableManagerHolder.SetBufferConfig(c.Buffer); err != nil {
+		fatalf(exitConfigError, "%s", err)
+	}
+	if err := tableManagerHolder.SetReceiptsConfig(c.Receipts); err != nil {
+		fatalf(exitConfigError, "%s", err)
+	}
+	s := enableSpool(c, inserters, insertErrorLogger, tableManagerHolder)
+	if c.AdminHttpBind != "" {
+		go listenAndServe("admin", c.AdminHttpBind, makeAdminHandler(s, tableManagerHolder))
+	}
+	enablePersist(c, tableManagerHolder)
 	tableManagerHolder.StopUnusedManagers()
 	receivers := makeAndStartReceivers(c, errChan, tableManagerHolder)
 
-	waitForTermination(errChan)
-	terminate(receivers, tableManagerHolder)
+	r := &reloader{
+		configPath:         configPath,
+		logFlags:           logFlags,
+		config:             c,
+		inserters:          inserters,
+		receivers:          receivers,
+		errChan:            errChan,
+		tableManagerHolder: tableManagerHolder,
+	}
+	err = waitForTermination(errChan, r.reload)
+	terminate(r.receivers, tableManagerHolder)
+	if s != nil {
+		s.Stop()
+	}
+	if tracer != nil {
+		tracer.Stop()
+	}
+	if err != nil {
+		insertErrorLogger.Close()
+		fatalf(serveErrorExitCode(err), "fatal error: %s", err)
+	}
+}
+
+//listenAndServe serves an auxiliary HTTP server, it's error
+//(e.g. the bind is busy) is logged, dbatcher keeps working without it
+func listenAndServe(name, bind string, handler http.Handler) {
+	err := http.ListenAndServe(bind, handler)
+	logging.With("server", name).Error("http server stopped", "bind", bind, "error", err)
+}
+
+//enableTracing starts export of spans if tracing is configured, returns nil otherwise
+func enableTracing(c config) *tracing.Tracer {
+	if c.Tracing.Endpoint == "" {
+		return nil
+	}
+	tracer, err := tracing.NewTracer(c.Tracing)
+	if err != nil {
+		fatalf(exitConfigError, "%s", err)
+	}
+	tracing.SetTracer(tracer)
+
+	return tracer
 }
 
 func getConfig(configPath string) config {
 	var c config
 	_, err := toml.DecodeFile(configPath, &c)
 	if err != nil {
-		log.Fatal(err)
+		fatalf(exitConfigError, "can't read config %s: %s", configPath, err)
 	}
 
 	return c
@@ -54,21 +146,15 @@ func getConfig(configPath string) config {
 func makeInserters(c config) map[string]inserter.Inserter {
 	inserters := map[string]inserter.Inserter{}
 	for name, config := range c.Inserters {
-		log.Printf("creating inserter %s", name)
-
-		var ins inserter.Inserter
-		switch config.Type {
-		case "clickhouse":
-			ins = &inserter.ClickHouseInserter{}
-		case "mysql":
-			ins = &inserter.MysqlInserter{}
-		case "dummy":
-			ins = &inserter.DummyInserter{}
-		default:
-			log.Fatal("no such inserter")
+		logging.With("inserter", name).Info("creating inserter", "type", config.Type)
+
+		config.Name = name
+		ins, err := makeInserter(config)
+		if err != nil {
+			fatalf(exitConfigError, "inserter %s: %s", name, err)
 		}
 		if err := ins.Init(config); err != nil {
-			log.Fatal(err)
+			fatalf(exitInserterError, "can't init inserter %s: %s", name, err)
 		}
 		inserters[name] = ins
 	}
@@ -76,20 +162,88 @@ func makeInserters(c config) map[string]inserter.Inserter {
 	return inserters
 }
 
+//makeInserter returns not initialized inserter of config's type
+func makeInserter(config inserter.Config) (inserter.Inserter, error) {
+	var ins inserter.Inserter
+	switch config.Type {
+	case "clickhouse":
+		ins = &inserter.ClickHouseInserter{}
+	case "mysql":
+		ins = &inserter.MysqlInserter{}
+	case "postgres":
+		ins = &inserter.PostgresInserter{}
+	case "dummy":
+		ins = &inserter.DummyInserter{}
+	default:
+		return nil, errors.Wrapf(ErrNoSuchInserterType, "%q", config.Type)
+	}
+	if config.MaxRetries > 0 {
+		ins = inserter.NewRetryInserter(ins)
+	}
+
+	return ins, nil
+}
+
+//makeRouter makes router by routes of config and checks their inserters
+func makeRouter(c config, inserters map[string]inserter.Inserter) (*tablemanager.Router, error) {
+	router, err := tablemanager.NewRouter(c.Routes)
+	if err != nil {
+		return nil, errors.Wrap(err, "routes")
+	}
+
+	return router, errors.Wrap(router.CheckInserters(inserters), "routes")
+}
+
+func checkTablePolicies(c config) error {
+	for tableName, policy := range c.Tables {
+		if err := policy.Validate(); err != nil {
+			return errors.Wrapf(err, "tables: %s", tableName)
+		}
+	}
+
+	return nil
+}
+
+//enableSpool opens spool if it's configured, returns nil otherwise
+func enableSpool(c config, inserters map[string]inserter.Inserter, insertErrorLogger *inserter.InsertErrorLogger, tableManagerHolder *tablemanager.Holder) *spool.Spool {
+	if c.Spool.Dir == "" {
+		return nil
+	}
+	s, err := spool.Open(c.Spool, inserters, insertErrorLogger)
+	if err != nil {
+		fatalf(exitStorageError, "can't open spool: %s", err)
+	}
+	tableManagerHolder.EnableSpool(s)
+	s.Run()
+
+	return s
+}
+
+func enablePersist(c config, tableManagerHolder *tablemanager.Holder) {
+	if c.Persist.Dir == "" {
+		return
+	}
+	w, err := wal.Open(c.Persist)
+	if err != nil {
+		fatalf(exitStorageError, "can't open write-ahead log: %s", err)
+	}
+	if err := tableManagerHolder.EnablePersist(w); err != nil {
+		fatalf(exitStorageError, "can't replay write-ahead log: %s", err)
+	}
+}
+
 func makeAndStartReceivers(c config, errChan chan error, tableManagerHolder *tablemanager.Holder) map[string]receiver.Receiver {
 	receivers := map[string]receiver.Receiver{}
 	for name, config := range c.Receivers {
-		log.Printf("creating receiver %s", name)
-
-		var rec receiver.Receiver
-		switch config.Type {
-		case "http":
-			rec = &receiver.HTTPReceiver{}
-		default:
-			log.Fatal("no such receiver")
+		logging.With("receiver", name).Info("creating receiver", "type", config.Type, "bind", config.Bind)
+
+		rec, err := makeReceiver(config)
+		if err != nil {
+			fatalf(exitConfigError, "receiver %s: %s", name, err)
 		}
+		config.Name = name
 		if err := rec.Init(config, errChan, tableManagerHolder); err != nil {
-			log.Fatal(err)
+			fatalf(exitConfigError, "can't init receiver %s: %s", name, err)
 		}
 		rec.Receive()
 		receivers[name] = rec
@@ -98,28 +252,50 @@ func makeAndStartReceivers(c config, errChan chan error, tableManagerHolder *tab
 	return receivers
 }
 
-func waitForTermination(errChan chan error) {
+//makeReceiver returns not initialized receiver of config's type
+func makeReceiver(config receiver.Config) (receiver.Receiver, error) {
+	switch config.Type {
+	case "http":
+		return &receiver.HTTPReceiver{}, nil
+	default:
+		return nil, errors.Wrapf(ErrNoSuchReceiverType, "%q", config.Type)
+	}
+}
+
+//waitForTermination calls reload on SIGHUP, waits for a termination signal
+//or for a fatal error, which is returned
+func waitForTermination(errChan chan error, reload func() error) error {
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
-	select {
-	case x := <-interrupt:
-		log.Printf("received a signal: %s", x.String())
-	case err := <-errChan:
-		log.Printf("fatal error: %s", err.Error())
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case x := <-interrupt:
+			logging.Default().Info("received a signal", "signal", x.String())
+			if x != syscall.SIGHUP {
+				return nil
+			}
+			if err := reload(); err != nil {
+				logging.Default().Error("reload failed", "error", err)
+				continue
+			}
+			logging.Infof("config reloaded")
+		case err := <-errChan:
+			return err
+		}
 	}
 }
 
 func terminate(receivers map[string]receiver.Receiver, tableManagerHolder *tablemanager.Holder) {
 	for name, rec := range receivers {
-		log.Printf("stoping receiver %s", name)
-		err := rec.Stop()
-		if err != nil {
-			log.Println(err)
+		logger := logging.With("receiver", name)
+		logger.Info("stopping receiver")
+		if err := rec.Stop(); err != nil {
+			logger.Error("can't stop receiver", "error", err)
 		}
 	}
 
 	managerErrors := tableManagerHolder.StopTableManagers()
 	for _, err := range managerErrors {
-		log.Println(err)
+		logging.Default().Error("can't stop table manager", "error", err)
 	}
 }
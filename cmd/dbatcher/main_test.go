@@ -1,7 +1,6 @@
 package main
 
 import (
-	"os"
 	"testing"
 	"time"
 
@@ -9,11 +8,7 @@ import (
 )
 
 func TestDoNotFallWithExampleConfig(t *testing.T) {
-	if len(os.Args) < 2 {
-		os.Args = append(os.Args, "")
-	}
-	os.Args[1] = "../../assets/config_example.toml"
-	go main()
+	go runServe([]string{"-config", "../../assets/config_example.toml"})
 	time.Sleep(time.Second)
 
 	_, _, err := fasthttp.Get(nil, "http://127.0.0.1:8124/")
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/receiver"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/pkg/errors"
+)
+
+//reloader holds running receivers and inserters and replaces them
+//according to the changed config
+type reloader struct {
+	configPath         string
+	logFlags           logging.Config
+	config             config
+	inserters          map[string]inserter.Inserter
+	receivers          map[string]receiver.Receiver
+	errChan            chan error
+	tableManagerHolder *tablemanager.Holder
+}
+
+//reload re-reads config and applies changes of receivers, inserters,
+//routes, table policies, buffer, receipts and log.
+//Buffered rows are inserted by inserters they were accepted for.
+//Other sections need restart. If config can't be read or an inserter
+//can't be initialized nothing is changed
+func (r *reloader) reload() error {
+	var c config
+	if _, err := toml.DecodeFile(r.configPath, &c); err != nil {
+		return errors.Wrapf(err, "reload: can't read config %s", r.configPath)
+	}
+	r.warnAboutRestart(c)
+	if err := checkTablePolicies(c); err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	if err := c.Buffer.Validate(); err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	if err := c.Receipts.Validate(); err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	if err := c.Log.Validate(); err != nil {
+		return errors.Wrap(err, "reload: log")
+	}
+
+	inserters, err := r.makeChangedInserters(c)
+	if err != nil {
+		return err
+	}
+	if inserters != nil || !reflect.DeepEqual(c.Routes, r.config.Routes) {
+		if inserters == nil {
+			inserters = r.inserters
+		}
+		router, err := makeRouter(c, inserters)
+		if err != nil {
+			r.closeCreatedInserters(inserters)
+			return errors.Wrap(err, "reload")
+		}
+		stopping, errs := r.tableManagerHolder.ReplaceInserters(inserters, router)
+		for _, err := range errs {
+			logging.Default().Error("reload: can't replace inserters", "error", err)
+		}
+		for name, ins := range r.inserters {
+			if inserters[name] != ins {
+				closeInserterWhenUnused(name, ins, stopping)
+			}
+		}
+		r.inserters = inserters
+		r.config.Inserters = c.Inserters
+		r.config.Routes = c.Routes
+	}
+	if !reflect.DeepEqual(c.Tables, r.config.Tables) {
+		r.tableManagerHolder.SetTablePolicies(c.Tables)
+		r.config.Tables = c.Tables
+	}
+	if c.Buffer != r.config.Buffer {
+		r.tableManagerHolder.SetBufferConfig(c.Buffer)
+		r.config.Buffer = c.Buffer
+	}
+	if c.Receipts != r.config.Receipts {
+		r.tableManagerHolder.SetReceiptsConfig(c.Receipts)
+		r.config.Receipts = c.Receipts
+	}
+	if c.Log != r.config.Log {
+		logging.Configure(mergeLogConfig(c.Log, r.logFlags))
+		r.config.Log = c.Log
+	}
+	r.reloadReceivers(c)
+
+	return nil
+}
+
+func (r *reloader) warnAboutRestart(c config) {
+	if c.PprofHttpBind != r.config.PprofHttpBind {
+		logging.Warnf("reload: pprof_http_bind changed, restart to apply")
+	}
+	if c.MetricsHttpBind != r.config.MetricsHttpBind {
+		logging.Warnf("reload: metrics_http_bind changed, restart to apply")
+	}
+	if c.InsertErrorLogger != r.config.InsertErrorLogger {
+		logging.Warnf("reload: insert_error_logger changed, restart to apply")
+	}
+	if c.Persist != r.config.Persist {
+		logging.Warnf("reload: persist changed, restart to apply")
+	}
+	if c.Spool != r.config.Spool {
+		logging.Warnf("reload: spool changed, restart to apply")
+	}
+	if c.AdminHttpBind != r.config.AdminHttpBind {
+		logging.Warnf("reload: admin_http_bind changed, restart to apply")
+	}
+	if c.Tracing != r.config.Tracing {
+		logging.Warnf("reload: tracing changed, restart to apply")
+	}
+}
+
+//makeChangedInserters returns inserters for config c, reusing ones
+//with the same config. Returns nil if nothing changed
+func (r *reloader) makeChangedInserters(c config) (map[string]inserter.Inserter, error) {
+	if reflect.DeepEqual(c.Inserters, r.config.Inserters) {
+		return nil, nil
+	}
+	inserters := map[string]inserter.Inserter{}
+	for name, config := range c.Inserters {
+		if oldConfig, ok := r.config.Inserters[name]; ok && oldConfig == config {
+			inserters[name] = r.inserters[name]
+			continue
+		}
+		logging.With("inserter", name).Info("reload: creating inserter", "type", config.Type)
+		config.Name = name
+		ins, err := makeInserter(config)
+		if err == nil {
+			err = ins.Init(config)
+		}
+		if err != nil {
+			r.closeCreatedInserters(inserters)
+			return nil, errors.Wrapf(err, "reload: inserter %s", name)
+		}
+		inserters[name] = ins
+	}
+
+	return inserters, nil
+}
+
+//closeCreatedInserters closes inserters that aren't running
+func (r *reloader) closeCreatedInserters(inserters map[string]inserter.Inserter) {
+	for name, ins := range inserters {
+		if r.inserters[name] != ins {
+			closeInserter(name, ins)
+		}
+	}
+}
+
+//reloadReceivers stops removed and changed receivers, then starts
+//added and changed ones. Receiver that can't listen is skipped
+func (r *reloader) reloadReceivers(c config) {
+	for name, rec := range r.receivers {
+		if newConfig, ok := c.Receivers[name]; ok && newConfig == r.config.Receivers[name] {
+			continue
+		}
+		logger := logging.With("receiver", name)
+		logger.Info("reload: stopping receiver")
+		if err := rec.Stop(); err != nil {
+			logger.Error("reload: can't stop receiver", "error", err)
+		}
+		delete(r.receivers, name)
+		delete(r.config.Receivers, name)
+	}
+	if r.config.Receivers == nil {
+		r.config.Receivers = map[string]receiver.Config{}
+	}
+	for name, config := range c.Receivers {
+		if _, ok := r.receivers[name]; ok {
+			continue
+		}
+		logger := logging.With("receiver", name)
+		logger.Info("reload: creating receiver", "type", config.Type, "bind", config.Bind)
+		rec, err := makeReceiver(config)
+		if err == nil {
+			err = checkBind(config.Bind)
+		}
+		if err == nil {
+			config.Name = name
+			err = rec.Init(config, r.errChan, r.tableManagerHolder)
+		}
+		if err != nil {
+			logger.Error("reload: can't start receiver", "error", err)
+			continue
+		}
+		rec.Receive()
+		r.receivers[name] = rec
+		r.config.Receivers[name] = c.Receivers[name]
+	}
+}
+
+//closeInserterWhenUnused closes inserter at once if stopping managers don't use it.
+//Otherwise it's closed after they finish, so their batches aren't broken
+func closeInserterWhenUnused(name string, ins inserter.Inserter, stopping []*tablemanager.TableManager) {
+	users := []*tablemanager.TableManager{}
+	for _, manager := range stopping {
+		if manager.UsesInserter(ins) {
+			users = append(users, manager)
+		}
+	}
+	if len(users) == 0 {
+		closeInserter(name, ins)
+		return
+	}
+	logging.With("inserter", name).Warn("reload: inserter is closed after it's table managers stop", "managers", len(users))
+	go func() {
+		for _, manager := range users {
+			<-manager.Done()
+		}
+		closeInserter(name, ins)
+	}()
+}
+
+//closeInserter closes inserter if it's an io.Closer
+func closeInserter(name string, ins inserter.Inserter) {
+	closer, ok := ins.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		logging.With("inserter", name).Error("can't close inserter", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/valyala/fasthttp"
+)
+
+func getFreeBind(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func TestReload(t *testing.T) {
+	firstBind, secondBind := getFreeBind(t), getFreeBind(t)
+	path := writeTestConfig(t, fmt.Sprintf(`
+[receivers.first-http]
+type = "http"
+bind = "%s"
+[inserters.first]
+type = "dummy"
+`, firstBind))
+	c := getConfig(path)
+	errChan := make(chan error, 1)
+	inserters := makeInserters(c)
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tableManagerHolder := tablemanager.NewHolder(errChan, inserters, logger)
+	r := &reloader{
+		configPath:         path,
+		config:             c,
+		inserters:          inserters,
+		receivers:          makeAndStartReceivers(c, errChan, tableManagerHolder),
+		errChan:            errChan,
+		tableManagerHolder: tableManagerHolder,
+	}
+	defer func() {
+		terminate(r.receivers, tableManagerHolder)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	firstInserter := r.inserters["first"]
+
+	config := fmt.Sprintf(`
+[receivers.second-http]
+type = "http"
+bind = "%s"
+[inserters.first]
+type = "dummy"
+[inserters.second]
+type = "dummy"
+`, secondBind)
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-errChan:
+		t.Fatal(err)
+	default:
+	}
+	if r.inserters["first"] != firstInserter {
+		t.Error("unchanged inserter should be reused")
+	}
+	if _, ok := r.inserters["second"]; !ok {
+		t.Error("added inserter should be created")
+	}
+	if _, ok := r.receivers["first-http"]; ok {
+		t.Error("removed receiver should be stopped")
+	}
+	if err := checkBind(firstBind); err != nil {
+		t.Errorf("removed receiver's bind should be free: %s", err)
+	}
+	code, _, err := fasthttp.Get(nil, "http://"+secondBind+"/healthz")
+	if err != nil {
+		t.Fatalf("added receiver should listen: %s", err)
+	}
+	if code != 200 {
+		t.Errorf("code should be 200, got %d", code)
+	}
+
+	//nothing is changed if an inserter is wrong
+	config += `
+[inserters.third]
+type = "oracle"
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Error("should return error for unknown inserter type")
+	}
+	if len(r.inserters) != 2 {
+		t.Errorf("inserters shouldn't change, got %v", r.inserters)
+	}
+}
+
+type closeCountInserter struct {
+	inserter.DummyInserter
+	closed int32
+}
+
+func (ci *closeCountInserter) Close() error {
+	atomic.AddInt32(&ci.closed, 1)
+	return nil
+}
+
+func (ci *closeCountInserter) isClosed() bool {
+	return atomic.LoadInt32(&ci.closed) == 1
+}
+
+func TestReloadCloseInserterWhenUnused(t *testing.T) {
+	used, unused := &closeCountInserter{}, &closeCountInserter{}
+	ts := table.NewSignature("db.table", "field1")
+	manager := tablemanager.NewTableManager(
+		&ts, tablemanager.NewConfig(60000, 100, false),
+		map[string]inserter.Inserter{"used": used}, inserter.NewInsertErrorLogger(nil, false),
+	)
+	go manager.Run()
+	stopping := []*tablemanager.TableManager{manager}
+
+	closeInserterWhenUnused("unused", unused, stopping)
+	if !unused.isClosed() {
+		t.Error("inserter that stopping managers don't use should be closed at once")
+	}
+	closeInserterWhenUnused("used", used, stopping)
+	if used.isClosed() {
+		t.Error("inserter shouldn't be closed before it's managers stop")
+	}
+	manager.Stop()
+	for i := 0; i < 100 && !used.isClosed(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !used.isClosed() {
+		t.Error("inserter should be closed after it's managers stop")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/pkg/httpclient"
+	"github.com/pkg/errors"
+)
+
+//ErrNoSuchInserter means record names an inserter that isn't in config
+var ErrNoSuchInserter = errors.New("no such inserter in config")
+
+type replayOptions struct {
+	logPath          string
+	configPath       string
+	httpAddress      string
+	failedOutputPath string
+	prettyPrint      bool
+	dryRun           bool
+	table            string
+	from             time.Time
+	to               time.Time
+}
+
+type replayStats struct {
+	replayed int
+	failed   int
+	skipped  int
+}
+
+func runReplay(args []string) {
+	options, err := parseReplayFlags(args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	stats, err := replay(options)
+	if err != nil {
+		fatalf(exitRuntimeError, "%s", err)
+	}
+	logging.Infof(
+		"replay finished: replayed %d, failed %d, skipped %d records",
+		stats.replayed, stats.failed, stats.skipped,
+	)
+}
+
+func parseReplayFlags(args []string) (options replayOptions, err error) {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.StringVar(&options.logPath, "log", "", "insert error log file to replay (required)")
+	flags.StringVar(&options.configPath, "config", "config.toml", "config with inserters to replay into")
+	flags.StringVar(&options.httpAddress, "http", "", "replay via dbatcher HTTP receiver at this address (e.g. http://127.0.0.1:8124) instead of inserters")
+	flags.StringVar(&options.failedOutputPath, "failed-output", "", "file for records that failed again")
+	flags.BoolVar(&options.prettyPrint, "pretty", false, "pretty print records in failed output")
+	flags.BoolVar(&options.dryRun, "dry-run", false, "only report records that would be replayed")
+	flags.StringVar(&options.table, "table", "", "replay only records of this table")
+	from := flags.String("from", "", "replay only records logged at or after this time (RFC3339 or unix seconds)")
+	to := flags.String("to", "", "replay only records logged before this time (RFC3339 or unix seconds)")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if options.logPath == "" {
+		return options, errors.New("replay: -log is required")
+	}
+	if options.from, err = parseReplayTime(*from); err != nil {
+		return options, errors.Wrap(err, "replay: -from")
+	}
+	if options.to, err = parseReplayTime(*to); err != nil {
+		return options, errors.Wrap(err, "replay: -to")
+	}
+
+	return options, nil
+}
+
+func parseReplayTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func (options replayOptions) shouldReplay(record inserter.InsertErrorLogRecord) bool {
+	if options.table != "" && record.Table != options.table {
+		return false
+	}
+	if !options.from.IsZero() && record.TimeStamp < options.from.Unix() {
+		return false
+	}
+	if !options.to.IsZero() && record.TimeStamp >= options.to.Unix() {
+		return false
+	}
+
+	return true
+}
+
+func replay(options replayOptions) (stats replayStats, err error) {
+	f, err := os.Open(options.logPath)
+	if err != nil {
+		return stats, errors.Wrap(err, "replay")
+	}
+	defer f.Close()
+
+	failedLogger, err := inserter.NewInsertErrorLoggerFromConfig(inserter.InsertErrorLoggerConfig{
+		Path:        options.failedOutputPath,
+		PrettyPrint: options.prettyPrint,
+	})
+	if err != nil {
+		return stats, errors.Wrap(err, "replay: failed output")
+	}
+	defer failedLogger.Close()
+
+	var inserters map[string]inserter.Inserter
+	if options.httpAddress == "" && !options.dryRun {
+		inserters = makeInserters(getConfig(options.configPath))
+	}
+
+	reader := inserter.NewInsertErrorLogReader(f)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		if !options.shouldReplay(record) {
+			stats.skipped++
+			continue
+		}
+		if options.dryRun {
+			logging.Infof(
+				"would replay %d rows into %s (%s), inserters: %v",
+				len(record.Rows), record.Table, record.Fields, record.Inserters,
+			)
+			stats.replayed++
+			continue
+		}
+
+		var failedInserters []string
+		var replayErr error
+		if options.httpAddress != "" {
+			failedInserters, replayErr = replayRecordViaHTTP(options.httpAddress, record)
+		} else {
+			failedInserters, replayErr = replayRecord(inserters, record)
+		}
+		if replayErr == nil {
+			stats.replayed++
+			continue
+		}
+		stats.failed++
+		logging.Errorf("failed to replay record of %s: %s", record.Table, replayErr)
+		if err := logFailedRecord(failedLogger, replayErr, failedInserters, record); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+//replayRecord inserts record's rows only into inserters that failed before.
+//If record doesn't have inserters, all inserters are used
+func replayRecord(inserters map[string]inserter.Inserter, record inserter.InsertErrorLogRecord) ([]string, error) {
+	names := record.Inserters
+	if len(names) == 0 {
+		for name := range inserters {
+			names = append(names, name)
+		}
+	}
+	t, err := record.MakeTable()
+	if err != nil {
+		return names, err
+	}
+	defer t.Free()
+
+	errs := map[string]error{}
+	for _, name := range names {
+		ins, ok := inserters[name]
+		if !ok {
+			errs[name] = ErrNoSuchInserter
+			continue
+		}
+		if err := ins.Insert(context.Background(), t); err != nil {
+			errs[name] = err
+		}
+		t.Reset()
+	}
+	if len(errs) != 0 {
+		insertErr := tablemanager.InsertError{Errors: errs}
+		return insertErr.FailedInserters(), insertErr
+	}
+
+	return nil, nil
+}
+
+//replayRecordViaHTTP sends record's rows with sync=1 to inserters that failed before.
+//If record doesn't have inserters, all routed inserters of that dbatcher instance are used
+func replayRecordViaHTTP(address string, record inserter.InsertErrorLogRecord) ([]string, error) {
+	config := httpclient.ClientConfig{
+		ServerAddress: address,
+		ReadTimeout:   time.Minute,
+		WriteTimeout:  time.Minute,
+	}
+	params := httpclient.SendParams{
+		Table:     record.Table,
+		Fields:    record.Fields,
+		Sync:      true,
+		Inserters: record.Inserters,
+	}
+	err := httpclient.SendWithParams(config, params, record.Rows)
+
+	return record.Inserters, err
+}
+
+func logFailedRecord(failedLogger *inserter.InsertErrorLogger, replayErr error, failedInserters []string, record inserter.InsertErrorLogRecord) error {
+	t, err := record.MakeTable()
+	if err != nil {
+		//record can't be turned into a table, so it's written as is
+		return writeRawFailedRecord(failedLogger, replayErr, record)
+	}
+	defer t.Free()
+
+	return errors.Wrap(failedLogger.Log(replayErr, failedInserters, t), "replay: failed output")
+}
+
+func writeRawFailedRecord(failedLogger *inserter.InsertErrorLogger, replayErr error, record inserter.InsertErrorLogRecord) error {
+	record.Error = fmt.Sprintf("replay: %s; before: %s", replayErr, record.Error)
+	return errors.Wrap(failedLogger.LogRecord(record), "replay: failed output")
+}
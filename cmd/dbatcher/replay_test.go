@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/receiver"
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+)
+
+func writeReplayTestFiles(t *testing.T, dir string) (logPath, configPath string) {
+	configPath = filepath.Join(dir, "config.toml")
+	config := "[inserters]\n    [inserters.dummy]\n        type = \"dummy\"\n"
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logPath = filepath.Join(dir, "error.log")
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	logger := inserter.NewInsertErrorLogger(f, true)
+	rows := [][]interface{}{{json.Number("1"), "a"}}
+	records := []inserter.InsertErrorLogRecord{
+		{TimeStamp: 100, Inserters: []string{"dummy"}, Table: "t1", Fields: "f1,f2", Rows: rows},
+		{TimeStamp: 200, Inserters: []string{"missing"}, Table: "t1", Fields: "f1,f2", Rows: rows},
+		{TimeStamp: 300, Table: "t2", Fields: "f1,f2", Rows: rows},
+		{TimeStamp: 400, Inserters: []string{"dummy"}, Table: "t3", Fields: "", Rows: rows},
+	}
+	for _, record := range records {
+		if err := logger.LogRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return logPath, configPath
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath, configPath := writeReplayTestFiles(t, dir)
+	failedPath := filepath.Join(dir, "failed.log")
+
+	options, err := parseReplayFlags([]string{"-log", logPath, "-config", configPath, "-failed-output", failedPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := replay(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (replayStats{replayed: 2, failed: 2}); stats != want {
+		t.Errorf("want stats %+v, got %+v", want, stats)
+	}
+
+	f, err := os.Open(failedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := inserter.NewInsertErrorLogReader(f)
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record.Inserters, []string{"missing"}) || record.Table != "t1" {
+		t.Errorf("wrong failed record: %+v", record)
+	}
+	if record, err = reader.Read(); err != nil || record.Table != "t3" {
+		t.Errorf("invalid record should be in failed output, got %+v, %v", record, err)
+	}
+	if _, err = reader.Read(); err != io.EOF {
+		t.Errorf("should be only 2 failed records, got %v", err)
+	}
+}
+
+func TestReplayFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath, configPath := writeReplayTestFiles(t, dir)
+
+	cases := []struct {
+		args []string
+		want replayStats
+	}{
+		{[]string{"-table", "t2"}, replayStats{replayed: 1, skipped: 3}},
+		{[]string{"-from", "150", "-to", time.Unix(250, 0).Format(time.RFC3339)}, replayStats{failed: 1, skipped: 3}},
+		{[]string{"-dry-run", "-config", "not_existing.toml"}, replayStats{replayed: 4}},
+	}
+	for _, c := range cases {
+		args := append([]string{"-log", logPath, "-config", configPath}, c.args...)
+		options, err := parseReplayFlags(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := replay(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != c.want {
+			t.Errorf("%v: want stats %+v, got %+v", c.args, c.want, stats)
+		}
+	}
+
+	if _, err := parseReplayFlags([]string{"-config", configPath}); err == nil {
+		t.Error("-log should be required")
+	}
+	if _, err := parseReplayFlags([]string{"-log", logPath, "-from", "yesterday"}); err == nil {
+		t.Error("invalid -from should be an error")
+	}
+}
+
+type rowCountInserter struct {
+	rows    int
+	rowsMut sync.Mutex
+}
+
+func (ri *rowCountInserter) Init(c inserter.Config) error {
+	return nil
+}
+
+func (ri *rowCountInserter) Insert(ctx context.Context, t *table.Table) error {
+	ri.rowsMut.Lock()
+	ri.rows += t.GetRowsLen()
+	ri.rowsMut.Unlock()
+
+	return nil
+}
+
+func (ri *rowCountInserter) getRows() int {
+	ri.rowsMut.Lock()
+	defer ri.rowsMut.Unlock()
+
+	return ri.rows
+}
+
+func TestReplayViaHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "error.log")
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := inserter.NewInsertErrorLogger(f, false)
+	err = logger.LogRecord(inserter.InsertErrorLogRecord{
+		TimeStamp: 100, Inserters: []string{"failed"}, Table: "t1", Fields: "f1,f2",
+		Rows: [][]interface{}{{json.Number("1"), "a"}, {json.Number("2"), "b"}},
+	})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded, failed := &rowCountInserter{}, &rowCountInserter{}
+	errChan := make(chan error, 1)
+	tmh := tablemanager.NewHolder(errChan, map[string]inserter.Inserter{
+		"succeeded": succeeded,
+		"failed":    failed,
+	}, inserter.NewInsertErrorLogger(nil, false))
+	defer tmh.StopTableManagers()
+	bind := getFreeBind(t)
+	rec := &receiver.HTTPReceiver{}
+	if err := rec.Init(receiver.Config{Type: "http", Bind: bind}, errChan, tmh); err != nil {
+		t.Fatal(err)
+	}
+	rec.Receive()
+	defer rec.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	options, err := parseReplayFlags([]string{"-log", logPath, "-http", "http://" + bind})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := replay(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (replayStats{replayed: 1}); stats != want {
+		t.Errorf("want stats %+v, got %+v", want, stats)
+	}
+	if rows := failed.getRows(); rows != 2 {
+		t.Errorf("failed inserter should get 2 rows, got %d", rows)
+	}
+	if rows := succeeded.getRows(); rows != 0 {
+		t.Errorf("succeeded inserter shouldn't get rows, got %d", rows)
+	}
+}
@@ -3,13 +3,14 @@ package inserter
 import (
 	"context"
 	"database/sql"
-	"log"
 	"net/url"
 	"strings"
 	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go" //golint: ClickHouseInserter won't really work without it
+	"github.com/edwvee/dbatcher/internal/logging"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
 	"github.com/pkg/errors"
 )
 
@@ -26,6 +27,7 @@ type ClickHouseInserter struct {
 	db            *sql.DB
 	databaseName  string
 	insertTimeout time.Duration
+	logger        *logging.Logger
 }
 
 //Init setups ClickHouseInserter and connects to ClickHouse
@@ -35,6 +37,7 @@ func (ci *ClickHouseInserter) Init(config Config) error {
 		return err
 	}
 	ci.db = db
+	ci.logger = logging.With("inserter", config.Name)
 	ci.insertTimeout = time.Duration(config.InsertTimeoutMs) * time.Millisecond
 	u, err := url.Parse(config.Dsn)
 	if err != nil {
@@ -45,23 +48,39 @@ func (ci *ClickHouseInserter) Init(config Config) error {
 	return nil
 }
 
+//Ping checks connection to ClickHouse
+func (ci ClickHouseInserter) Ping(ctx context.Context) error {
+	return ci.db.PingContext(ctx)
+}
+
+//Close closes connections to ClickHouse
+func (ci ClickHouseInserter) Close() error {
+	return ci.db.Close()
+}
+
 //Insert gets table structure and inserts
-func (ci ClickHouseInserter) Insert(t *table.Table) error {
+func (ci ClickHouseInserter) Insert(ctx context.Context, t *table.Table) error {
 	sqlStr := ci.makeSQL(t)
 	start := time.Now()
-	if err := ci.insert(t, sqlStr); err != nil {
+	if err := ci.insert(ctx, t, sqlStr); err != nil {
 		return err
 	}
-	passed := time.Since(start)
-	log.Printf(
-		"ClickHouse: inserted %d rows for %s; Query: %s",
-		t.GetRowsLen(), passed.String(), sqlStr,
+	ci.logger.Debug(
+		"clickhouse: inserted",
+		"table", t.GetTableName(), "rows", t.GetRowsLen(),
+		"duration_ms", logging.DurationMs(time.Since(start)), "query", sqlStr,
 	)
 	return nil
 }
 
-func (ci ClickHouseInserter) insert(t *table.Table, sqlStr string) error {
-	structure, err := ci.getTableStructure(t)
+func (ci ClickHouseInserter) insert(ctx context.Context, t *table.Table, sqlStr string) error {
+	ctx, cancel := context.WithTimeout(ctx, ci.insertTimeout)
+	defer cancel()
+
+	structureCtx, span := tracing.Start(ctx, "clickhouse.table_structure")
+	structure, err := ci.getTableStructure(structureCtx, t)
+	tracing.SetError(span, err)
+	span.End()
 	if err != nil {
 		return err
 	}
@@ -70,16 +89,32 @@ func (ci ClickHouseInserter) insert(t *table.Table, sqlStr string) error {
 		fields[i] = strings.TrimSpace(field)
 	}
 	//TODO: wrap errors
-	ctx, cancel := context.WithTimeout(context.Background(), ci.insertTimeout)
-	defer cancel()
-
 	tx, err := ci.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	if err := ci.execRows(ctx, tx, t, structure, fields, sqlStr); err != nil {
+		tx.Rollback()
+		return err
+	}
+	//TODO: wrap too
+	_, span = tracing.Start(ctx, "clickhouse.commit")
+	err = tx.Commit()
+	tracing.SetError(span, err)
+	span.End()
+
+	return err
+}
+
+//execRows executes insert of every row in tx, the caller rolls tx back on error
+func (ci ClickHouseInserter) execRows(ctx context.Context, tx *sql.Tx, t *table.Table, structure clickhouseStructure, fields []string, sqlStr string) (err error) {
+	_, span := tracing.Start(ctx, "clickhouse.exec")
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
 	stmt, err := tx.Prepare(sqlStr)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
@@ -87,28 +122,30 @@ func (ci ClickHouseInserter) insert(t *table.Table, sqlStr string) error {
 	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
 		converted, err := structure.ConvertJSONRow(fields, row)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 		//TODO: !!!!! error must work
 		_, err = stmt.Exec(converted...)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
-	//TODO: wrap too
-	return tx.Commit()
+	return nil
 }
 
 func (ci ClickHouseInserter) makeSQL(t *table.Table) string {
-	//TODO: question marks
+	qsPerRow := strings.Count(t.GetFields(), ",") + 1
+	rowQsSlice := make([]string, qsPerRow)
+	for i := range rowQsSlice {
+		rowQsSlice[i] = "?"
+	}
+
 	return "INSERT INTO " + t.GetTableName() +
-		"(" + t.GetFields() + ") VALUES (?,?,?,?)"
+		"(" + t.GetFields() + ") VALUES (" + strings.Join(rowQsSlice, ",") + ")"
 }
 
-func (ci ClickHouseInserter) getTableStructure(t *table.Table) (structure clickhouseStructure, err error) {
+func (ci ClickHouseInserter) getTableStructure(ctx context.Context, t *table.Table) (structure clickhouseStructure, err error) {
 	var database, table string
 	tName := t.GetTableName()
 	if pos := strings.Index(tName, "."); pos != -1 {
@@ -127,7 +164,7 @@ func (ci ClickHouseInserter) getTableStructure(t *table.Table) (structure clickh
 	var column string
 	var chType clickhouseType
 	sqlStr := "SELECT name, type FROM system.columns WHERE database = ? AND `table` = ?"
-	rows, err := ci.db.Query(sqlStr, database, table)
+	rows, err := ci.db.QueryContext(ctx, sqlStr, database, table)
 	if err != nil {
 		return structure, errors.Wrapf(err, "get table structure for %s:", t.GetKey())
 	}
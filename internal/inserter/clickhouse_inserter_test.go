@@ -1,6 +1,7 @@
 package inserter
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"io/ioutil"
@@ -8,6 +9,7 @@ import (
 	"math"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -95,7 +97,7 @@ func TestClickhouseGetTableStructure(t *testing.T) {
 	})
 	ts := table.NewSignature(clickhouseTestTableName, clickhouseTestFields)
 	tbl := table.NewTable(ts)
-	structure, err := ins.getTableStructure(tbl)
+	structure, err := ins.getTableStructure(context.Background(), tbl)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,7 +108,7 @@ func TestClickhouseGetTableStructure(t *testing.T) {
 	//test when no database in table name
 	ts = table.NewSignature("dbatcher_test_table", clickhouseTestFields)
 	tbl = table.NewTable(ts)
-	structure, err = ins.getTableStructure(tbl)
+	structure, err = ins.getTableStructure(context.Background(), tbl)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -205,7 +207,7 @@ func TestClickshouseInsert(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ins.Insert(table)
+	err = ins.Insert(context.Background(), table)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -309,7 +311,7 @@ func TestNoSuchTableStructure(t *testing.T) {
 	})
 	ts := table.NewSignature("not_existing", "field1")
 	table := table.NewTable(ts)
-	err := ins.Insert(table)
+	err := ins.Insert(context.Background(), table)
 	if !errors.Is(err, ErrNoSuchTableStructure) {
 		t.Error("should get ErrNoSuchTableStructure")
 	}
@@ -331,7 +333,7 @@ func TestNoDatabaseInDsnOrTableName(t *testing.T) {
 	})
 	ts := table.NewSignature("not_existing", "field1")
 	table := table.NewTable(ts)
-	err := ins.Insert(table)
+	err := ins.Insert(context.Background(), table)
 	if !errors.Is(err, ErrNoDatabaseInDsnOrInTableName) {
 		t.Error("should get ErrNoDatabaseInDsnOrInTableName")
 	}
@@ -370,3 +372,83 @@ func TestInvalidConnectClickhouse(t *testing.T) {
 		t.Error("should be an error")
 	}
 }
+
+func TestClickhouseMakeSQL(t *testing.T) {
+	ins := ClickHouseInserter{}
+	ts := table.NewSignature("db.table", "field1")
+	sqlStr := ins.makeSQL(table.NewTable(ts))
+	if want := "INSERT INTO db.table(field1) VALUES (?)"; sqlStr != want {
+		t.Errorf("want %s, got %s", want, sqlStr)
+	}
+	ts = table.NewSignature("db.table", "field1, field2, `field3`")
+	sqlStr = ins.makeSQL(table.NewTable(ts))
+	if want := "INSERT INTO db.table(field1,field2,`field3`) VALUES (?,?,?)"; sqlStr != want {
+		t.Errorf("want %s, got %s", want, sqlStr)
+	}
+}
+
+func TestClickhouseInsertNarrowAndWide(t *testing.T) {
+	dsn := os.Getenv(clickhouseDsnKey)
+	if dsn == "" {
+		t.SkipNow()
+	}
+
+	ins := ClickHouseInserter{}
+	ins.Init(Config{
+		Type:            "clickhouse",
+		Dsn:             dsn,
+		MaxConnections:  2,
+		InsertTimeoutMs: 30000,
+	})
+
+	wideFields := make([]string, 30)
+	wideRow := make([]interface{}, 30)
+	for i := range wideFields {
+		wideFields[i] = "column" + strconv.Itoa(i+1)
+		if i%2 == 0 {
+			wideRow[i] = i
+		} else {
+			wideRow[i] = strconv.Itoa(i)
+		}
+	}
+	cases := []struct {
+		tableName string
+		fields    []string
+		row       []interface{}
+	}{
+		{"default.dbatcher_test_narrow_table", []string{"stringString"}, []interface{}{"string"}},
+		{"default.dbatcher_test_wide_table", wideFields, wideRow},
+	}
+	for _, c := range cases {
+		ts := table.NewSignature(c.tableName, strings.Join(c.fields, ","))
+		tbl := table.NewTable(ts)
+		structure, err := ins.getTableStructure(context.Background(), tbl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(structure) != len(c.fields) {
+			t.Fatalf("%s: structure should have %d columns, got %d", c.tableName, len(c.fields), len(structure))
+		}
+		if qs := strings.Count(ins.makeSQL(tbl), "?"); qs != len(structure) {
+			t.Errorf("%s: should be %d placeholders, got %d", c.tableName, len(structure), qs)
+		}
+
+		jsonData, err := jsoniter.Marshal([][]interface{}{c.row, c.row})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.AppendRows(jsonData); err != nil {
+			t.Fatal(err)
+		}
+		if err := ins.Insert(context.Background(), tbl); err != nil {
+			t.Fatalf("%s: %s", c.tableName, err)
+		}
+		var count int
+		if err := clickhouse.QueryRow("SELECT count() FROM " + c.tableName).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("%s: should be 2 rows, got %d", c.tableName, count)
+		}
+	}
+}
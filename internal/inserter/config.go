@@ -2,8 +2,14 @@ package inserter
 
 //Config is a config for inserter
 type Config struct {
-	Type            string `toml:"type"`
-	Dsn             string `toml:"dsn"`
-	MaxConnections  int    `toml:"max_connections"`
-	InsertTimeoutMs int    `toml:"insert_timeout_ms"`
+	//Name is inserter's name from config's inserters section
+	Name                  string `toml:"-"`
+	Type                  string `toml:"type"`
+	Dsn                   string `toml:"dsn"`
+	MaxConnections        int    `toml:"max_connections"`
+	InsertTimeoutMs       int    `toml:"insert_timeout_ms"`
+	OnConflictDoNothing   bool   `toml:"on_conflict_do_nothing"`
+	MaxRetries            int    `toml:"max_retries"`
+	RetryInitialBackoffMs int    `toml:"retry_initial_backoff_ms"`
+	RetryMaxBackoffMs     int    `toml:"retry_max_backoff_ms"`
 }
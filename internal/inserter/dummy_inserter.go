@@ -1,24 +1,28 @@
 package inserter
 
 import (
-	"log"
+	"context"
 
+	"github.com/edwvee/dbatcher/internal/logging"
 	"github.com/edwvee/dbatcher/internal/table"
 )
 
 //DummyInserter doesn't write data, only reports
-type DummyInserter struct{}
+type DummyInserter struct {
+	logger *logging.Logger
+}
 
-//Init does nothing
+//Init setups logger
 func (ci *DummyInserter) Init(config Config) error {
+	ci.logger = logging.With("inserter", config.Name)
 	return nil
 }
 
 //Insert reports about table's rows count
-func (ci DummyInserter) Insert(t *table.Table) error {
-	log.Printf(
-		"Dummy: did nothing with %d rows",
-		t.GetRowsLen(),
+func (ci DummyInserter) Insert(ctx context.Context, t *table.Table) error {
+	ci.logger.Info(
+		"dummy: did nothing",
+		"table", t.GetTableName(), "rows", t.GetRowsLen(),
 	)
 	return nil
 }
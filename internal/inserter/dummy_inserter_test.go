@@ -1,6 +1,7 @@
 package inserter
 
 import (
+	"context"
 	"testing"
 
 	"github.com/edwvee/dbatcher/internal/table"
@@ -26,7 +27,7 @@ func TestDummyInsert(t *testing.T) {
 	if err := table.AppendRows([]byte("[[1],[2],[3]]")); err != nil {
 		t.Fatal(err)
 	}
-	if err := ins.Insert(table); err != nil {
+	if err := ins.Insert(context.Background(), table); err != nil {
 		t.Error(err)
 	}
 }
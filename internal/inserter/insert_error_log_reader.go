@@ -0,0 +1,65 @@
+package inserter
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/edwvee/dbatcher/internal/table"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+//InsertErrorLogReader reads records written by InsertErrorLogger.
+//Both pretty printed and compact records are supported.
+//Uses encoding/json cause jsoniter's decoder fails on a stream of pretty printed values
+type InsertErrorLogReader struct {
+	decoder *json.Decoder
+}
+
+//legacyInsertErrorLogRecord has fields under "string" key as it was written before
+type legacyInsertErrorLogRecord struct {
+	InsertErrorLogRecord
+	LegacyFields string `json:"string"`
+}
+
+//NewInsertErrorLogReader returns reader of records from r
+func NewInsertErrorLogReader(r io.Reader) *InsertErrorLogReader {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return &InsertErrorLogReader{decoder: decoder}
+}
+
+//Read returns the next record. Returns io.EOF when there are no more records
+func (r *InsertErrorLogReader) Read() (InsertErrorLogRecord, error) {
+	var record legacyInsertErrorLogRecord
+	if err := r.decoder.Decode(&record); err != nil {
+		if err == io.EOF {
+			return record.InsertErrorLogRecord, err
+		}
+		return record.InsertErrorLogRecord, errors.Wrap(err, "insert error log reader")
+	}
+	if record.Fields == "" {
+		record.Fields = record.LegacyFields
+	}
+
+	return record.InsertErrorLogRecord, nil
+}
+
+//MakeTable makes table from record's table name, fields and rows
+func (rec InsertErrorLogRecord) MakeTable() (*table.Table, error) {
+	ts := table.NewSignature(rec.Table, rec.Fields)
+	if err := ts.Validate(); err != nil {
+		return nil, err
+	}
+	rowsJSON, err := jsoniter.Marshal(rec.Rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert error log record")
+	}
+	t := table.NewTable(ts)
+	if err := t.AppendRows(rowsJSON); err != nil {
+		t.Free()
+		return nil, err
+	}
+
+	return t, nil
+}
@@ -10,21 +10,26 @@ import (
 	jsoniter "github.com/json-iterator/go"
 )
 
+//InsertErrorLogger writes rows that weren't inserted as JSON records
 type InsertErrorLogger struct {
 	w           io.Writer
 	prettyPrint bool
 	mut         sync.Mutex
 }
 
-type insertErrorLoggerData struct {
+//InsertErrorLogRecord is a record of insert error log
+type InsertErrorLogRecord struct {
 	TimeStamp       int64           `json:"timestamp"`
 	TimeStampString string          `json:"timestamp_string"`
 	Error           string          `json:"error"`
+	Inserters       []string        `json:"inserters"`
 	Table           string          `json:"table"`
-	Fields          string          `json:"string"`
+	Fields          string          `json:"fields"`
 	Rows            [][]interface{} `json:"rows"`
 }
 
+//NewInsertErrorLoggerFromConfig opens file from config for appending.
+//If path is empty, returned logger doesn't write anything
 func NewInsertErrorLoggerFromConfig(config InsertErrorLoggerConfig) (*InsertErrorLogger, error) {
 	if config.Path == "" {
 		return NewInsertErrorLogger(nil, false), nil
@@ -36,16 +41,26 @@ func NewInsertErrorLoggerFromConfig(config InsertErrorLoggerConfig) (*InsertErro
 	return NewInsertErrorLogger(f, config.PrettyPrint), nil
 }
 
+//NewInsertErrorLogger returns logger that writes to w (could be nil)
 func NewInsertErrorLogger(w io.Writer, prettyPrint bool) *InsertErrorLogger {
 	return &InsertErrorLogger{w: w, prettyPrint: prettyPrint}
 }
 
-func (l *InsertErrorLogger) Log(insertError error, t *table.Table) error {
+//Log writes table's rows with insert error and names of inserters
+//that failed (so rows could be inserted only into them later)
+func (l *InsertErrorLogger) Log(insertError error, failedInserters []string, t *table.Table) error {
 	if l.w == nil {
 		return nil
 	}
 
-	data := l.MakeData(insertError, t)
+	return l.LogRecord(l.MakeData(insertError, failedInserters, t))
+}
+
+//LogRecord writes already made record
+func (l *InsertErrorLogger) LogRecord(data InsertErrorLogRecord) error {
+	if l.w == nil {
+		return nil
+	}
 
 	l.mut.Lock()
 	defer l.mut.Unlock()
@@ -61,12 +76,19 @@ func (l *InsertErrorLogger) Log(insertError error, t *table.Table) error {
 	return err
 }
 
-func (l *InsertErrorLogger) MakeData(insertError error, t *table.Table) insertErrorLoggerData {
+//IsEnabled tells if logger really writes somewhere
+func (l *InsertErrorLogger) IsEnabled() bool {
+	return l.w != nil
+}
+
+//MakeData makes a record from table's rows
+func (l *InsertErrorLogger) MakeData(insertError error, failedInserters []string, t *table.Table) InsertErrorLogRecord {
 	now := time.Now()
-	data := insertErrorLoggerData{
+	data := InsertErrorLogRecord{
 		TimeStamp:       now.Unix(),
 		TimeStampString: now.String(),
 		Error:           insertError.Error(),
+		Inserters:       failedInserters,
 		Table:           t.GetTableName(),
 		Fields:          t.GetFields(),
 		Rows:            make([][]interface{}, 0, t.GetRowsLen()),
@@ -78,6 +100,7 @@ func (l *InsertErrorLogger) MakeData(insertError error, t *table.Table) insertEr
 	return data
 }
 
+//Close closes underlying writer if it's closable
 func (l *InsertErrorLogger) Close() error {
 	if c, ok := l.w.(io.Closer); ok {
 		return c.Close()
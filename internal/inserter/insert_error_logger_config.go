@@ -1,5 +1,6 @@
 package inserter
 
+//InsertErrorLoggerConfig is a config for insert error logger
 type InsertErrorLoggerConfig struct {
 	Path        string `toml:"path"`
 	PrettyPrint bool   `toml:"pretty_print"`
@@ -2,7 +2,9 @@ package inserter
 
 import (
 	"bytes"
+	"io"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/edwvee/dbatcher/internal/table"
@@ -35,12 +37,13 @@ func TestInsertErrorLoggerLog(t *testing.T) {
 	data := []byte("[[\"test\",0,2.4],[\"test_test\",17,4.4]]")
 	table.AppendRows(data)
 	errorMessage := "test error"
-	err := logger.Log(errors.New(errorMessage), table)
+	failedInserters := []string{"first", "second"}
+	err := logger.Log(errors.New(errorMessage), failedInserters, table)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var result insertErrorLoggerData
+	var result InsertErrorLogRecord
 	resultData := buf.Bytes()
 	err = jsoniter.Unmarshal(resultData, &result)
 	if err != nil {
@@ -49,6 +52,9 @@ func TestInsertErrorLoggerLog(t *testing.T) {
 	if result.Error != errorMessage {
 		t.Errorf("wrong error message: got %s, want %s", result.Error, errorMessage)
 	}
+	if !reflect.DeepEqual(result.Inserters, failedInserters) {
+		t.Errorf("wrong inserters: got %v, want %v", result.Inserters, failedInserters)
+	}
 	if result.Table != tableName {
 		t.Errorf("wrong table name: got %s, want %s", result.Table, tableName)
 	}
@@ -63,3 +69,51 @@ func TestInsertErrorLoggerLog(t *testing.T) {
 		t.Errorf("wrong rows after json marshalling: got %s, want %s", marshalledRows, data)
 	}
 }
+
+func TestInsertErrorLogReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tbl := table.NewTable(table.NewSignature("database.table", "field1,field2"))
+	data := []byte(`[["test",1],["test_test",17.5]]`)
+	if err := tbl.AppendRows(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, prettyPrint := range []bool{true, false} {
+		logger := NewInsertErrorLogger(buf, prettyPrint)
+		if err := logger.Log(errors.New("test error"), []string{"first"}, tbl); err != nil {
+			t.Fatal(err)
+		}
+		tbl.Reset()
+	}
+	buf.WriteString(`{"timestamp":1,"error":"old","table":"database.table","string":"field1,field2","rows":[["a",2]]}`)
+
+	reader := NewInsertErrorLogReader(buf)
+	for i := 0; i < 3; i++ {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+		if record.Fields != "field1,field2" {
+			t.Errorf("record %d: wrong fields %s", i, record.Fields)
+		}
+		recordTable, err := record.MakeTable()
+		if err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+		if i == 2 {
+			if record.Inserters != nil || recordTable.GetRowsLen() != 1 {
+				t.Errorf("wrong legacy record: %v", record)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(record.Inserters, []string{"first"}) {
+			t.Errorf("record %d: wrong inserters %v", i, record.Inserters)
+		}
+		rowsJSON, _ := jsoniter.Marshal(recordTable.GetRawData())
+		if string(rowsJSON) != `["test",1,"test_test",17.5]` {
+			t.Errorf("record %d: wrong rows %s", i, rowsJSON)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("should be io.EOF at the end, got %v", err)
+	}
+}
@@ -1,9 +1,21 @@
 package inserter
 
-import "github.com/edwvee/dbatcher/internal/table"
+import (
+	"context"
 
-//Inserter inserts table's rows to a specific DMBS or other destination
+	"github.com/edwvee/dbatcher/internal/table"
+)
+
+//Inserter inserts table's rows to a specific DMBS or other destination.
+//Insert should stop when ctx is done, ctx has the insert's span,
+//inserters add child spans to it
 type Inserter interface {
 	Init(config Config) error
-	Insert(t *table.Table) error
+	Insert(ctx context.Context, t *table.Table) error
+}
+
+//Pinger is implemented by inserters which can check their destination
+//is reachable. Used by readiness checks
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
@@ -1,12 +1,14 @@
 package inserter
 
 import (
+	"context"
 	"database/sql"
-	"log"
 	"strings"
 	"time"
 
+	"github.com/edwvee/dbatcher/internal/logging"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
 	_ "github.com/go-sql-driver/mysql" //golint: MysqlInserter won't really work without it
 )
 
@@ -14,6 +16,7 @@ import (
 type MysqlInserter struct {
 	db            *sql.DB
 	insertTimeout time.Duration
+	logger        *logging.Logger
 }
 
 // Init setups MysqlInserter and connects to mysql
@@ -23,36 +26,49 @@ func (mi *MysqlInserter) Init(config Config) error {
 		return err
 	}
 	mi.db = db
+	mi.logger = logging.With("inserter", config.Name)
 	mi.insertTimeout = time.Duration(config.InsertTimeoutMs) * time.Millisecond
 
 	return nil
 }
 
+// Ping checks connection to mysql
+func (mi MysqlInserter) Ping(ctx context.Context) error {
+	return mi.db.PingContext(ctx)
+}
+
+// Close closes connections to mysql
+func (mi MysqlInserter) Close() error {
+	return mi.db.Close()
+}
+
 // Insert inserts rows to mysql
-func (mi MysqlInserter) Insert(t *table.Table) error {
+func (mi MysqlInserter) Insert(ctx context.Context, t *table.Table) error {
 	rowsLen := t.GetRowsLen()
 	sqlStr := mi.makeSQL(t)
-	log.Printf(
-		"MySQL: starting insert of %d rows into %s", rowsLen, t.GetTableName(),
-	)
+	mi.logger.Debug("mysql: starting insert", "table", t.GetTableName(), "rows", rowsLen)
 	start := time.Now()
-	count, err := mi.insert(t, sqlStr)
+	count, err := mi.insert(ctx, t, sqlStr)
 	if err != nil {
 		return err
 	}
-	passed := time.Since(start)
-	log.Printf(
-		"MySQL: inserted %d/%d rows for %s; Query: %s",
-		count, rowsLen, passed.String(), strings.Split(sqlStr, "VALUES")[0],
+	mi.logger.Debug(
+		"mysql: inserted",
+		"table", t.GetTableName(), "rows", rowsLen, "affected_rows", count,
+		"duration_ms", logging.DurationMs(time.Since(start)),
+		"query", strings.TrimSpace(strings.Split(sqlStr, "VALUES")[0]),
 	)
 	return nil
 }
 
-func (mi MysqlInserter) insert(t *table.Table, sqlStr string) (count int64, err error) {
-	res, err := mi.db.Exec(sqlStr, t.GetRawData()...)
+func (mi MysqlInserter) insert(ctx context.Context, t *table.Table, sqlStr string) (count int64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.exec")
+	res, err := mi.db.ExecContext(ctx, sqlStr, t.GetRawData()...)
 	if err == nil {
 		count, _ = res.RowsAffected()
 	}
+	tracing.SetError(span, err)
+	span.End()
 	return count, err
 }
 
@@ -1,6 +1,7 @@
 package inserter
 
 import (
+	"context"
 	"database/sql"
 	"io/ioutil"
 	"log"
@@ -171,7 +172,7 @@ func TestMysqlInsert(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ins.Insert(table)
+	err = ins.Insert(context.Background(), table)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,211 @@
+package inserter
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+//postgresCopyTable is a temporary table for COPY when conflicts should be ignored
+const postgresCopyTable = "dbatcher_copy"
+
+//PostgresInserter inserts rows into PostgreSQL via COPY FROM STDIN
+type PostgresInserter struct {
+	db                  *sql.DB
+	insertTimeout       time.Duration
+	onConflictDoNothing bool
+	logger              *logging.Logger
+}
+
+//Init setups PostgresInserter and connects to PostgreSQL
+func (pi *PostgresInserter) Init(config Config) error {
+	db, err := connectDB("postgres", config.Dsn, config.MaxConnections)
+	if err != nil {
+		return err
+	}
+	pi.db = db
+	pi.logger = logging.With("inserter", config.Name)
+	pi.insertTimeout = time.Duration(config.InsertTimeoutMs) * time.Millisecond
+	pi.onConflictDoNothing = config.OnConflictDoNothing
+
+	return nil
+}
+
+//Ping checks connection to PostgreSQL
+func (pi PostgresInserter) Ping(ctx context.Context) error {
+	return pi.db.PingContext(ctx)
+}
+
+//Close closes connections to PostgreSQL
+func (pi PostgresInserter) Close() error {
+	return pi.db.Close()
+}
+
+//Insert gets table structure and copies rows
+func (pi PostgresInserter) Insert(ctx context.Context, t *table.Table) error {
+	start := time.Now()
+	if err := pi.insert(ctx, t); err != nil {
+		return err
+	}
+	pi.logger.Debug(
+		"postgres: inserted",
+		"table", t.GetTableName(), "rows", t.GetRowsLen(),
+		"duration_ms", logging.DurationMs(time.Since(start)),
+	)
+	return nil
+}
+
+func (pi PostgresInserter) insert(ctx context.Context, t *table.Table) error {
+	ctx, cancel := context.WithTimeout(ctx, pi.insertTimeout)
+	defer cancel()
+
+	schema, tableName := splitPostgresTableName(t.GetTableName())
+	structureCtx, span := tracing.Start(ctx, "postgres.table_structure")
+	structure, err := pi.getTableStructure(structureCtx, t, schema, tableName)
+	tracing.SetError(span, err)
+	span.End()
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(strings.Replace(t.GetFields(), "`", "", -1), ",")
+
+	tx, err := pi.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "postgres: begin")
+	}
+	if err := pi.copyRows(ctx, tx, t, structure, schema, tableName, fields); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, span = tracing.Start(ctx, "postgres.commit")
+	err = errors.Wrap(tx.Commit(), "postgres: commit")
+	tracing.SetError(span, err)
+	span.End()
+
+	return err
+}
+
+//copyRows copies rows in tx, the caller rolls tx back on error
+func (pi PostgresInserter) copyRows(ctx context.Context, tx *sql.Tx, t *table.Table, structure postgresStructure, schema, tableName string, fields []string) (err error) {
+	ctx, span := tracing.Start(ctx, "postgres.copy")
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
+	copySQL := makePostgresCopySQL(schema, tableName, fields)
+	if pi.onConflictDoNothing {
+		_, err = tx.ExecContext(ctx, makePostgresCopyTableSQL(schema, tableName))
+		if err != nil {
+			return errors.Wrap(err, "postgres: create copy table")
+		}
+		copySQL = pq.CopyIn(postgresCopyTable, fields...)
+	}
+	stmt, err := tx.PrepareContext(ctx, copySQL)
+	if err != nil {
+		return errors.Wrap(err, "postgres: prepare copy")
+	}
+	defer stmt.Close()
+
+	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
+		converted, err := structure.ConvertJSONRow(fields, row)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, converted...); err != nil {
+			return errors.Wrap(err, "postgres: copy row")
+		}
+	}
+	//empty exec flushes buffered rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return errors.Wrap(err, "postgres: copy")
+	}
+	if pi.onConflictDoNothing {
+		_, err = tx.ExecContext(ctx, makePostgresInsertFromCopyTableSQL(schema, tableName, fields))
+		if err != nil {
+			return errors.Wrap(err, "postgres: insert from copy table")
+		}
+	}
+
+	return nil
+}
+
+//splitPostgresTableName splits "schema.table" and removes backticks.
+//Schema is empty if table name has no schema
+func splitPostgresTableName(name string) (schema, tableName string) {
+	name = strings.Replace(name, "`", "", -1)
+	if pos := strings.Index(name, "."); pos != -1 {
+		return name[0:pos], name[pos+1:]
+	}
+
+	return "", name
+}
+
+func makePostgresCopySQL(schema, tableName string, fields []string) string {
+	if schema == "" {
+		return pq.CopyIn(tableName, fields...)
+	}
+
+	return pq.CopyInSchema(schema, tableName, fields...)
+}
+
+func quotePostgresTableName(schema, tableName string) string {
+	if schema == "" {
+		return pq.QuoteIdentifier(tableName)
+	}
+
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(tableName)
+}
+
+func makePostgresCopyTableSQL(schema, tableName string) string {
+	return "CREATE TEMPORARY TABLE " + pq.QuoteIdentifier(postgresCopyTable) +
+		" (LIKE " + quotePostgresTableName(schema, tableName) + " INCLUDING DEFAULTS) ON COMMIT DROP"
+}
+
+func makePostgresInsertFromCopyTableSQL(schema, tableName string, fields []string) string {
+	quotedFields := make([]string, len(fields))
+	for i, field := range fields {
+		quotedFields[i] = pq.QuoteIdentifier(field)
+	}
+	fieldsStr := strings.Join(quotedFields, ",")
+
+	return "INSERT INTO " + quotePostgresTableName(schema, tableName) + "(" + fieldsStr + ")" +
+		" SELECT " + fieldsStr + " FROM " + pq.QuoteIdentifier(postgresCopyTable) +
+		" ON CONFLICT DO NOTHING"
+}
+
+func (pi PostgresInserter) getTableStructure(ctx context.Context, t *table.Table, schema, tableName string) (structure postgresStructure, err error) {
+	sqlStr := "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2"
+	args := []interface{}{schema, tableName}
+	if schema == "" {
+		sqlStr = "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1"
+		args = args[1:]
+	}
+	rows, err := pi.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return structure, errors.Wrapf(err, "get table structure for %s:", t.GetKey())
+	}
+	defer rows.Close()
+
+	var column string
+	var pgType postgresType
+	structure = postgresStructure{}
+	for rows.Next() {
+		err = rows.Scan(&column, &pgType)
+		if err != nil {
+			return
+		}
+		structure[column] = pgType
+	}
+	if len(structure) == 0 {
+		err = ErrNoSuchTableStructure
+	}
+
+	return structure, errors.Wrapf(err, "get table structure for %s:", t.GetKey())
+}
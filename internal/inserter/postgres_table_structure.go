@@ -0,0 +1,152 @@
+package inserter
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+//postgresType is data_type from information_schema.columns
+type postgresType = string
+
+const (
+	pgSmallInt        = postgresType("smallint")
+	pgInteger         = postgresType("integer")
+	pgBigInt          = postgresType("bigint")
+	pgReal            = postgresType("real")
+	pgDoublePrecision = postgresType("double precision")
+	pgNumeric         = postgresType("numeric")
+	pgBoolean         = postgresType("boolean")
+	pgText            = postgresType("text")
+	pgVarchar         = postgresType("character varying")
+	pgChar            = postgresType("character")
+	pgUUID            = postgresType("uuid")
+	pgDate            = postgresType("date")
+	pgTimestamp       = postgresType("timestamp without time zone")
+	pgTimestampTz     = postgresType("timestamp with time zone")
+	pgJSON            = postgresType("json")
+	pgJSONB           = postgresType("jsonb")
+)
+
+//postgresStructure is postgres's table structure (columns)
+type postgresStructure map[string]postgresType
+
+//ErrCantParseToPostgresType means type of value from JSON can't be converted into
+//postgres's column type
+var ErrCantParseToPostgresType = errors.New("can't parse postgres type")
+
+//ConvertJSONRow converts jsonRow according columns to types that fit COPY and table in postgres.
+//null is converted to NULL for any type
+func (s postgresStructure) ConvertJSONRow(columns []string, jsonRow []interface{}) (row []interface{}, err error) {
+	row = make([]interface{}, 0, len(jsonRow))
+	for i, el := range jsonRow {
+		columnType := s[columns[i]]
+		if el == nil {
+			row = append(row, nil)
+			continue
+		}
+		var resEl interface{}
+		switch columnType {
+		case pgSmallInt, pgInteger, pgBigInt:
+			bitSize := 64
+			if columnType == pgSmallInt {
+				bitSize = 16
+			} else if columnType == pgInteger {
+				bitSize = 32
+			}
+			var str string
+			switch el := el.(type) {
+			case json.Number:
+				str = string(el)
+			case string:
+				str = el
+			default:
+				return nil, ErrCantParseToPostgresType
+			}
+			val, err := strconv.ParseInt(str, 10, bitSize)
+			if err != nil {
+				return nil, errors.Wrap(err, "convert to postgres type")
+			}
+			resEl = val
+		case pgReal, pgDoublePrecision:
+			var str string
+			switch el := el.(type) {
+			case json.Number:
+				str = string(el)
+			case string:
+				str = el
+			default:
+				return nil, ErrCantParseToPostgresType
+			}
+			val, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "convert to postgres type")
+			}
+			resEl = val
+		case pgNumeric:
+			switch el := el.(type) {
+			case json.Number:
+				resEl = string(el)
+			case string:
+				if _, err := strconv.ParseFloat(el, 64); err != nil {
+					return nil, errors.Wrap(err, "convert to postgres type")
+				}
+				resEl = el
+			default:
+				return nil, ErrCantParseToPostgresType
+			}
+		case pgBoolean:
+			switch el := el.(type) {
+			case bool:
+				resEl = el
+			case json.Number:
+				val, err := strconv.ParseBool(string(el))
+				if err != nil {
+					return nil, errors.Wrap(err, "convert to postgres type")
+				}
+				resEl = val
+			case string:
+				val, err := strconv.ParseBool(el)
+				if err != nil {
+					return nil, errors.Wrap(err, "convert to postgres type")
+				}
+				resEl = val
+			default:
+				return nil, ErrCantParseToPostgresType
+			}
+		case pgText, pgVarchar, pgChar, pgUUID:
+			preResEl, ok := el.(string)
+			if !ok {
+				return nil, ErrCantParseToPostgresType
+			}
+			resEl = preResEl
+		case pgDate, pgTimestamp, pgTimestampTz:
+			switch el := el.(type) {
+			case json.Number:
+				val, err := el.Int64()
+				if err != nil {
+					return nil, errors.Wrap(err, "convert to postgres type")
+				}
+				resEl = time.Unix(val, 0)
+			case string:
+				resEl = el
+			default:
+				return nil, ErrCantParseToPostgresType
+			}
+		case pgJSON, pgJSONB:
+			val, err := jsoniter.Marshal(el)
+			if err != nil {
+				return nil, errors.Wrap(err, "convert to postgres type")
+			}
+			resEl = string(val)
+		default:
+			return nil, errors.New("Postgres: " + string(columnType) + " type not supported")
+		}
+		row = append(row, resEl)
+	}
+
+	return row, err
+}
@@ -0,0 +1,131 @@
+package inserter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var fullTypePostgresStructure = postgresStructure{
+	"smallIntNumber":  pgSmallInt,
+	"intNumber":       pgInteger,
+	"bigIntNumber":    pgBigInt,
+	"bigIntString":    pgBigInt,
+	"realNumber":      pgReal,
+	"doubleNumber":    pgDoublePrecision,
+	"doubleString":    pgDoublePrecision,
+	"numericNumber":   pgNumeric,
+	"numericString":   pgNumeric,
+	"boolBool":        pgBoolean,
+	"boolNumber":      pgBoolean,
+	"textString":      pgText,
+	"varcharString":   pgVarchar,
+	"charString":      pgChar,
+	"uuidString":      pgUUID,
+	"dateString":      pgDate,
+	"timestampNumber": pgTimestamp,
+	"timestampString": pgTimestamp,
+	"timestampTzNull": pgTimestampTz,
+	"jsonbObject":     pgJSONB,
+	"jsonString":      pgJSON,
+}
+
+func TestPostgresTableStructureConvertJsonRowPositive(t *testing.T) {
+	columns := []string{
+		"smallIntNumber", "intNumber", "bigIntNumber", "bigIntString",
+		"realNumber", "doubleNumber", "doubleString", "numericNumber", "numericString",
+		"boolBool", "boolNumber", "textString", "varcharString", "charString", "uuidString",
+		"dateString", "timestampNumber", "timestampString", "timestampTzNull",
+		"jsonbObject", "jsonString",
+	}
+	row := []interface{}{
+		json.Number("-512"),
+		json.Number("131072"),
+		json.Number("-4611686018427387904"),
+		"4611686018427387904",
+		json.Number("34435.5"),
+		json.Number("34454435.353535"),
+		"34454435.353535",
+		json.Number("12345678901234567890.123"),
+		"1.5",
+		true,
+		json.Number("0"),
+		"text",
+		"varchar",
+		"c",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"2021-09-29",
+		json.Number("1632949379"),
+		"2021-09-29 01:52:16",
+		nil,
+		map[string]interface{}{"key": json.Number("1")},
+		"string",
+	}
+	expectedRow := []interface{}{
+		int64(-512),
+		int64(131072),
+		int64(-4611686018427387904),
+		int64(4611686018427387904),
+		float64(34435.5),
+		float64(34454435.353535),
+		float64(34454435.353535),
+		"12345678901234567890.123",
+		"1.5",
+		true,
+		false,
+		"text",
+		"varchar",
+		"c",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"2021-09-29",
+		time.Unix(1632949379, 0),
+		"2021-09-29 01:52:16",
+		nil,
+		`{"key":1}`,
+		`"string"`,
+	}
+
+	resultRow, err := fullTypePostgresStructure.ConvertJSONRow(columns, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedRow, resultRow) {
+		t.Errorf("want %v, got %v", expectedRow, resultRow)
+	}
+}
+
+func TestPostgresTableStructureConvertJsonRowNegative(t *testing.T) {
+	invalidCases := map[string]interface{}{
+		"smallIntNumber":  json.Number("32768"),
+		"intNumber":       json.Number("1.5"),
+		"bigIntNumber":    []interface{}{},
+		"bigIntString":    "asd",
+		"realNumber":      true,
+		"doubleString":    "asd",
+		"numericNumber":   []interface{}{},
+		"numericString":   "asd",
+		"boolBool":        "asd",
+		"boolNumber":      json.Number("2"),
+		"textString":      json.Number("1"),
+		"uuidString":      true,
+		"dateString":      []interface{}{},
+		"timestampNumber": json.Number("1.5"),
+	}
+	for column, value := range invalidCases {
+		columns := []string{column}
+		row := []interface{}{value}
+		resultRow, err := fullTypePostgresStructure.ConvertJSONRow(columns, row)
+		if resultRow != nil {
+			t.Fatalf("result row should be nill; column %s, value %v", column, value)
+		}
+		if err == nil {
+			t.Fatalf("err shouldn't be nill; column %s, value %v", column, value)
+		}
+	}
+
+	_, err := postgresStructure{"point": "point"}.ConvertJSONRow([]string{"point"}, []interface{}{"(1,2)"})
+	if err == nil {
+		t.Error("unsupported type should return an error")
+	}
+}
@@ -0,0 +1,110 @@
+package inserter
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+)
+
+//RetryInserter calls wrapped inserter again with exponential backoff
+//if insert failed. All attempts with backoffs should fit insert_timeout_ms
+type RetryInserter struct {
+	inserter       Inserter
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	insertTimeout  time.Duration
+	logger         *logging.Logger
+}
+
+//NewRetryInserter wraps inserter. Init should be called on the result
+func NewRetryInserter(inserter Inserter) *RetryInserter {
+	return &RetryInserter{inserter: inserter}
+}
+
+//Init setups retries and inits wrapped inserter
+func (ri *RetryInserter) Init(config Config) error {
+	ri.maxRetries = config.MaxRetries
+	ri.logger = logging.With("inserter", config.Name)
+	ri.initialBackoff = time.Duration(config.RetryInitialBackoffMs) * time.Millisecond
+	if ri.initialBackoff <= 0 {
+		ri.initialBackoff = defaultRetryInitialBackoff
+	}
+	ri.maxBackoff = time.Duration(config.RetryMaxBackoffMs) * time.Millisecond
+	if ri.maxBackoff <= 0 {
+		ri.maxBackoff = defaultRetryMaxBackoff
+	}
+	ri.insertTimeout = time.Duration(config.InsertTimeoutMs) * time.Millisecond
+
+	return ri.inserter.Init(config)
+}
+
+//Insert calls wrapped inserter until success, max retries
+//or until the next attempt won't fit insert timeout. Attempts get ctx
+//with the deadline of insert timeout, backoff is stopped when ctx is done
+func (ri RetryInserter) Insert(ctx context.Context, t *table.Table) error {
+	if ri.insertTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(ri.insertTimeout))
+		defer cancel()
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	backoff := ri.initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := ri.inserter.Insert(ctx, t)
+		if err == nil {
+			return nil
+		}
+		if attempt >= ri.maxRetries {
+			return err
+		}
+		if hasDeadline && time.Now().Add(backoff).After(deadline) {
+			return errors.Wrapf(err, "retries stopped by insert timeout after %d attempts", attempt+1)
+		}
+		ri.logger.Warn(
+			"insert failed, retrying",
+			"table", t.GetTableName(), "rows", t.GetRowsLen(),
+			"attempt", attempt+1, "max_attempts", ri.maxRetries+1,
+			"backoff_ms", logging.DurationMs(backoff), "error", err,
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(err, "retries stopped after %d attempts: %s", attempt+1, ctx.Err())
+		}
+		t.Reset()
+		backoff *= 2
+		if backoff > ri.maxBackoff {
+			backoff = ri.maxBackoff
+		}
+	}
+}
+
+//Ping pings wrapped inserter if it's a Pinger
+func (ri RetryInserter) Ping(ctx context.Context) error {
+	if pinger, ok := ri.inserter.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+//Close closes wrapped inserter if it's an io.Closer
+func (ri RetryInserter) Close() error {
+	if closer, ok := ri.inserter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package inserter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/table"
+)
+
+//failingInserter fails first failures inserts after reading one row
+type failingInserter struct {
+	failures int
+	calls    int
+	rows     int
+}
+
+func (fi *failingInserter) Init(config Config) error {
+	return nil
+}
+
+func (fi *failingInserter) Insert(ctx context.Context, t *table.Table) error {
+	fi.calls++
+	if fi.calls <= fi.failures {
+		t.GetNextRow()
+		return errors.New("insert failed")
+	}
+	fi.rows = 0
+	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
+		fi.rows++
+	}
+
+	return nil
+}
+
+func makeRetryTestTable(t *testing.T) *table.Table {
+	tbl := table.NewTable(table.NewSignature("table", "field1"))
+	if err := tbl.AppendRows([]byte("[[1],[2],[3]]")); err != nil {
+		t.Fatal(err)
+	}
+
+	return tbl
+}
+
+func TestRetryInserterSucceeds(t *testing.T) {
+	fi := &failingInserter{failures: 2}
+	ri := NewRetryInserter(fi)
+	err := ri.Init(Config{MaxRetries: 3, RetryInitialBackoffMs: 1, RetryMaxBackoffMs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ri.Insert(context.Background(), makeRetryTestTable(t)); err != nil {
+		t.Fatal(err)
+	}
+	if fi.calls != 3 {
+		t.Errorf("should be 3 calls, got %d", fi.calls)
+	}
+	if fi.rows != 3 {
+		t.Errorf("all rows should be inserted after retry, got %d", fi.rows)
+	}
+}
+
+func TestRetryInserterGivesUp(t *testing.T) {
+	fi := &failingInserter{failures: 10}
+	ri := NewRetryInserter(fi)
+	ri.Init(Config{MaxRetries: 2, RetryInitialBackoffMs: 1})
+	if err := ri.Insert(context.Background(), makeRetryTestTable(t)); err == nil {
+		t.Fatal("should return last error")
+	}
+	if fi.calls != 3 {
+		t.Errorf("should be 3 calls, got %d", fi.calls)
+	}
+
+	fi = &failingInserter{failures: 10}
+	ri = NewRetryInserter(fi)
+	ri.Init(Config{MaxRetries: 10, RetryInitialBackoffMs: 40, InsertTimeoutMs: 100})
+	start := time.Now()
+	if err := ri.Insert(context.Background(), makeRetryTestTable(t)); err == nil {
+		t.Fatal("should return error when insert timeout is reached")
+	}
+	if passed := time.Since(start); passed > 100*time.Millisecond {
+		t.Errorf("retries should fit insert timeout, took %s", passed)
+	}
+	if fi.calls != 2 {
+		t.Errorf("should be 2 calls (backoffs 40ms and 80ms), got %d", fi.calls)
+	}
+}
+
+func TestRetryInserterContext(t *testing.T) {
+	fi := &failingInserter{failures: 10}
+	ri := NewRetryInserter(fi)
+	ri.Init(Config{MaxRetries: 10, RetryInitialBackoffMs: 1000})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if err := ri.Insert(ctx, makeRetryTestTable(t)); err == nil {
+		t.Fatal("should return error when ctx is done")
+	}
+	if passed := time.Since(start); passed > 500*time.Millisecond {
+		t.Errorf("backoff should be stopped by ctx, took %s", passed)
+	}
+	if fi.calls != 1 {
+		t.Errorf("should be 1 call, got %d", fi.calls)
+	}
+}
@@ -0,0 +1,271 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+//Formats of log lines
+const (
+	//FormatText is "2006/01/02 15:04:05 INFO message key=value" of standard logger, default
+	FormatText = "text"
+	//FormatLogfmt is "time=... level=info msg=message key=value"
+	FormatLogfmt = "logfmt"
+	//FormatJSON is {"time":...,"level":"info","msg":"message","key":"value"}
+	FormatJSON = "json"
+)
+
+//ErrUnknownFormat means format name isn't one of text, logfmt, json
+var ErrUnknownFormat = errors.New("unknown log format")
+
+var currentFormat atomic.Value
+
+//writeMut serializes lines of structured formats
+var writeMut sync.Mutex
+
+func init() {
+	currentFormat.Store(FormatText)
+}
+
+//Config is [log] section of config, empty values mean info level and text format
+type Config struct {
+	Level  string `toml:"level"`
+	Format string `toml:"format"`
+}
+
+//Validate checks if level and format are known
+func (c Config) Validate() error {
+	if c.Level != "" {
+		if _, err := ParseLevel(c.Level); err != nil {
+			return err
+		}
+	}
+	if c.Format != "" {
+		if _, err := ParseFormat(c.Format); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Configure sets level and format of config. Thread safe
+func Configure(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	level, format := LevelInfo, FormatText
+	if config.Level != "" {
+		level, _ = ParseLevel(config.Level)
+	}
+	if config.Format != "" {
+		format, _ = ParseFormat(config.Format)
+	}
+	SetLevel(level)
+	SetFormat(format)
+
+	return nil
+}
+
+//ParseFormat returns format by it's name
+func ParseFormat(name string) (string, error) {
+	for _, format := range []string{FormatText, FormatLogfmt, FormatJSON} {
+		if strings.EqualFold(name, format) {
+			return format, nil
+		}
+	}
+
+	return FormatText, errors.Wrapf(ErrUnknownFormat, "%q", name)
+}
+
+//SetFormat sets format of log lines, it should be one of Format
+//constants. Thread safe
+func SetFormat(format string) {
+	currentFormat.Store(format)
+}
+
+//GetFormat returns format of log lines
+func GetFormat() string {
+	return currentFormat.Load().(string)
+}
+
+//Logger writes messages with it's fields and fields of a message.
+//Fields are key-value pairs: "table", "db.events", "rows", 10.
+//nil Logger writes messages without fields
+type Logger struct {
+	fields []interface{}
+}
+
+var defaultLogger = &Logger{}
+
+//Default returns logger without fields
+func Default() *Logger {
+	return defaultLogger
+}
+
+//With returns logger with fields
+func With(keyValues ...interface{}) *Logger {
+	return defaultLogger.With(keyValues...)
+}
+
+//With returns logger with l's fields and keyValues
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.getFields())+len(keyValues))
+	fields = append(fields, l.getFields()...)
+
+	return &Logger{fields: append(fields, keyValues...)}
+}
+
+//Debug writes a debug message with fields
+func (l *Logger) Debug(msg string, keyValues ...interface{}) {
+	output(LevelDebug, l.getFields(), msg, keyValues)
+}
+
+//Info writes an info message with fields
+func (l *Logger) Info(msg string, keyValues ...interface{}) {
+	output(LevelInfo, l.getFields(), msg, keyValues)
+}
+
+//Warn writes a warning with fields
+func (l *Logger) Warn(msg string, keyValues ...interface{}) {
+	output(LevelWarn, l.getFields(), msg, keyValues)
+}
+
+//Error writes an error message with fields
+func (l *Logger) Error(msg string, keyValues ...interface{}) {
+	output(LevelError, l.getFields(), msg, keyValues)
+}
+
+func (l *Logger) getFields() []interface{} {
+	if l == nil {
+		return nil
+	}
+
+	return l.fields
+}
+
+//DurationMs returns d in milliseconds for duration_ms fields
+func DurationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func output(level Level, fields []interface{}, msg string, keyValues []interface{}) {
+	if !IsEnabled(level) {
+		return
+	}
+	var b bytes.Buffer
+	switch GetFormat() {
+	case FormatJSON:
+		b.WriteString(`{"time":`)
+		writeJSONValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSONValue(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSONValue(&b, msg)
+		writeJSONFields(&b, fields)
+		writeJSONFields(&b, keyValues)
+		b.WriteString("}\n")
+	case FormatLogfmt:
+		b.WriteString("time=")
+		b.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+		b.WriteString(" level=")
+		b.WriteString(level.String())
+		b.WriteString(" msg=")
+		writeLogfmtValue(&b, msg)
+		writeLogfmtFields(&b, fields)
+		writeLogfmtFields(&b, keyValues)
+		b.WriteByte('\n')
+	default:
+		b.WriteString(strings.ToUpper(level.String()))
+		b.WriteByte(' ')
+		b.WriteString(msg)
+		writeLogfmtFields(&b, fields)
+		writeLogfmtFields(&b, keyValues)
+		log.Output(3, b.String())
+		return
+	}
+	writeMut.Lock()
+	log.Writer().Write(b.Bytes())
+	writeMut.Unlock()
+}
+
+//fieldValue makes errors, durations and stringers printable
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+//fieldKey returns key of pair, odd value without key gets "!badkey"
+func fieldKey(keyValues []interface{}, i int) (string, interface{}) {
+	if i+1 >= len(keyValues) {
+		return "!badkey", keyValues[i]
+	}
+
+	return fmt.Sprint(keyValues[i]), keyValues[i+1]
+}
+
+func writeLogfmtFields(b *bytes.Buffer, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		key, value := fieldKey(keyValues, i)
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		switch v := fieldValue(value).(type) {
+		case nil:
+			b.WriteString("null")
+		case string:
+			writeLogfmtValue(b, v)
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			writeLogfmtValue(b, fmt.Sprint(v))
+		}
+	}
+}
+
+//writeLogfmtValue quotes value if it's empty or has spaces, quotes or =
+func writeLogfmtValue(b *bytes.Buffer, value string) {
+	if value != "" && utf8.ValidString(value) && !strings.ContainsAny(value, " =\"\t\r\n\\") {
+		b.WriteString(value)
+		return
+	}
+	b.WriteString(strconv.Quote(value))
+}
+
+func writeJSONFields(b *bytes.Buffer, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		key, value := fieldKey(keyValues, i)
+		b.WriteByte(',')
+		writeJSONValue(b, key)
+		b.WriteByte(':')
+		writeJSONValue(b, fieldValue(value))
+	}
+}
+
+func writeJSONValue(b *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+func captureOutput(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	level := GetLevel()
+	SetFormat(format)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		SetLevel(level)
+		SetFormat(FormatText)
+	})
+
+	return &buf
+}
+
+func TestLoggerJSON(t *testing.T) {
+	buf := captureOutput(t, FormatJSON)
+	SetLevel(LevelDebug)
+
+	logger := With("table", "db.events").With("inserter", "first")
+	logger.Info("inserted", "rows", 10, "duration_ms", DurationMs(1500*time.Microsecond))
+	logger.Error("insert failed", "error", errors.New("connection refused"))
+	Warnf("legacy %d", 1)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %s", len(lines), buf.String())
+	}
+	var entry map[string]interface{}
+	if err := jsoniter.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line should be JSON: %s, %s", err, lines[0])
+	}
+	expected := map[string]interface{}{
+		"level": "info", "msg": "inserted", "table": "db.events",
+		"inserter": "first", "rows": float64(10), "duration_ms": 1.5,
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("time should be RFC3339: %s", err)
+	}
+
+	entry = nil
+	if err := jsoniter.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("line should be JSON: %s, %s", err, lines[1])
+	}
+	if entry["error"] != "connection refused" || entry["level"] != "error" {
+		t.Errorf("wrong error entry: %v", entry)
+	}
+	if !strings.Contains(lines[2], `"level":"warn","msg":"legacy 1"`) {
+		t.Errorf("wrong legacy line: %s", lines[2])
+	}
+}
+
+func TestLoggerLogfmt(t *testing.T) {
+	buf := captureOutput(t, FormatLogfmt)
+	SetLevel(LevelInfo)
+
+	logger := With("table", "db.events")
+	logger.Debug("dropped")
+	logger.Warn("insert retry", "inserter", "first", "error", errors.New("bad conn"), "odd")
+
+	out := strings.TrimSpace(buf.String())
+	if strings.Contains(out, "dropped") {
+		t.Errorf("debug should be dropped: %s", out)
+	}
+	if !strings.HasPrefix(out, "time=") {
+		t.Errorf("line should start with time: %s", out)
+	}
+	expected := ` level=warn msg="insert retry" table=db.events inserter=first error="bad conn" !badkey=odd`
+	if !strings.HasSuffix(out, expected) {
+		t.Errorf("expected suffix %s, got %s", expected, out)
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := captureOutput(t, FormatText)
+	SetLevel(LevelInfo)
+
+	With("receiver", "first-http").Info("listening", "bind", ":8124")
+	var nilLogger *Logger
+	nilLogger.With("table", "t").Info("nil logger")
+
+	if !strings.Contains(buf.String(), "INFO listening receiver=first-http bind=:8124\n") {
+		t.Errorf("wrong text line: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "INFO nil logger table=t\n") {
+		t.Errorf("nil logger should write: %s", buf.String())
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	captureOutput(t, FormatText)
+
+	if err := Configure(Config{Level: "debug", Format: "JSON"}); err != nil {
+		t.Fatalf("shouldn't return error: %s", err)
+	}
+	if GetLevel() != LevelDebug || GetFormat() != FormatJSON {
+		t.Errorf("expected debug and json, got %s and %s", GetLevel(), GetFormat())
+	}
+	if err := Configure(Config{}); err != nil {
+		t.Fatalf("shouldn't return error: %s", err)
+	}
+	if GetLevel() != LevelInfo || GetFormat() != FormatText {
+		t.Errorf("expected info and text, got %s and %s", GetLevel(), GetFormat())
+	}
+	if err := Configure(Config{Format: "xml"}); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("should return ErrUnknownFormat, got %v", err)
+	}
+	if err := Configure(Config{Level: "verbose"}); !errors.Is(err, ErrUnknownLevel) {
+		t.Errorf("should return ErrUnknownLevel, got %v", err)
+	}
+}
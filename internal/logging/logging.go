@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+//Level is a logging level. Messages with lower level are dropped
+type Level int32
+
+//Levels from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+//ErrUnknownLevel means level name isn't one of debug, info, warn, error
+var ErrUnknownLevel = errors.New("unknown log level")
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+var currentLevel = int32(LevelInfo)
+
+//ParseLevel returns level by it's name
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, errors.Wrapf(ErrUnknownLevel, "%q", name)
+}
+
+//String returns level's name
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+//SetLevel sets minimal level of messages to be written.
+//Thread safe
+func SetLevel(level Level) {
+	atomic.StoreInt32(&currentLevel, int32(level))
+}
+
+//GetLevel returns minimal level of messages to be written
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&currentLevel))
+}
+
+//IsEnabled reports whether messages of level are written
+func IsEnabled(level Level) bool {
+	return level >= GetLevel()
+}
+
+//Debugf writes a debug message with standard logger
+func Debugf(format string, v ...interface{}) {
+	output(LevelDebug, nil, fmt.Sprintf(format, v...), nil)
+}
+
+//Infof writes an info message with standard logger
+func Infof(format string, v ...interface{}) {
+	output(LevelInfo, nil, fmt.Sprintf(format, v...), nil)
+}
+
+//Warnf writes a warning with standard logger
+func Warnf(format string, v ...interface{}) {
+	output(LevelWarn, nil, fmt.Sprintf(format, v...), nil)
+}
+
+//Errorf writes an error message with standard logger
+func Errorf(format string, v ...interface{}) {
+	output(LevelError, nil, fmt.Sprintf(format, v...), nil)
+}
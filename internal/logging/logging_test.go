@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{
+		"debug": LevelDebug,
+		"INFO":  LevelInfo,
+		"Warn":  LevelWarn,
+		"error": LevelError,
+	} {
+		level, err := ParseLevel(name)
+		if err != nil {
+			t.Errorf("%s: shouldn't return error: %s", name, err)
+		}
+		if level != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, level)
+		}
+	}
+	if _, err := ParseLevel("verbose"); !errors.Is(err, ErrUnknownLevel) {
+		t.Errorf("should return ErrUnknownLevel, got %v", err)
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(GetLevel())
+
+	SetLevel(LevelWarn)
+	Debugf("debug %d", 1)
+	Infof("info %d", 2)
+	Warnf("warn %d", 3)
+	Errorf("error %d", 4)
+
+	out := buf.String()
+	for _, dropped := range []string{"debug 1", "info 2"} {
+		if strings.Contains(out, dropped) {
+			t.Errorf("%q should be dropped, output: %s", dropped, out)
+		}
+	}
+	for _, written := range []string{"WARN warn 3", "ERROR error 4"} {
+		if !strings.Contains(out, written) {
+			t.Errorf("%q should be written, output: %s", written, out)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dbatcher"
+
+//Flush triggers (values of trigger label of Flushes)
+const (
+	FlushTriggerTimeout  = "timeout"
+	FlushTriggerMaxRows  = "max_rows"
+	FlushTriggerMaxBytes = "max_bytes"
+	FlushTriggerStop     = "stop"
+	FlushTriggerSync     = "sync"
+	FlushTriggerManual   = "manual"
+	//FlushTriggerBackpressure is an early insert to free buffer memory
+	FlushTriggerBackpressure = "backpressure"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	//ReceivedRequests counts requests by receiver
+	ReceivedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "requests_total",
+		Help:      "Requests received by receiver.",
+	}, []string{"receiver"})
+	//ReceivedBytes counts bytes of requests' bodies by receiver
+	ReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "received_bytes_total",
+		Help:      "Bytes of rows received by receiver.",
+	}, []string{"receiver"})
+	//BufferedRows is rows count waiting for insert by table key
+	BufferedRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "table",
+		Name:      "buffered_rows",
+		Help:      "Rows buffered for the next insert by table key.",
+	}, []string{"table"})
+	//Flushes counts inserts of buffered rows by trigger
+	Flushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "table",
+		Name:      "flushes_total",
+		Help:      "Flushes of buffered rows by trigger.",
+	}, []string{"trigger"})
+	//InsertDuration is duration of inserts by inserter
+	InsertDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "inserter",
+		Name:      "insert_duration_seconds",
+		Help:      "Duration of inserts by inserter.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"inserter"})
+	//InsertErrors counts failed inserts by inserter
+	InsertErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "inserter",
+		Name:      "insert_errors_total",
+		Help:      "Failed inserts by inserter.",
+	}, []string{"inserter"})
+	//BufferedBytes is size of rows waiting for insert or being inserted
+	BufferedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "buffered_bytes",
+		Help:      "Size of rows JSON buffered or being inserted.",
+	})
+	//BufferFullErrors counts requests rejected because of buffered bytes limit
+	BufferFullErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "buffer_full_errors_total",
+		Help:      "Requests rejected because max_buffered_bytes is reached, by on_full mode.",
+	}, []string{"on_full"})
+	//ActiveTableManagers is count of running table managers in holder
+	ActiveTableManagers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "table",
+		Name:      "managers_active",
+		Help:      "Running table managers.",
+	})
+	//SpoolBytes is size of batches in spool
+	SpoolBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "spool",
+		Name:      "bytes",
+		Help:      "Size of spooled batches on disk.",
+	})
+	//SpoolBatches is count of batches in spool
+	SpoolBatches = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "spool",
+		Name:      "batches",
+		Help:      "Spooled batches waiting for retry.",
+	})
+	//SpoolDropped counts batches that weren't spooled or were removed
+	//from spool without insert, by reason
+	SpoolDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "spool",
+		Name:      "dropped_total",
+		Help:      "Batches moved to insert error log instead of spool, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	registry.MustRegister(
+		ReceivedRequests,
+		ReceivedBytes,
+		BufferedRows,
+		Flushes,
+		InsertDuration,
+		InsertErrors,
+		ActiveTableManagers,
+		BufferedBytes,
+		BufferFullErrors,
+		SpoolBytes,
+		SpoolBatches,
+		SpoolDropped,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+//Handler returns HTTP handler that exposes metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
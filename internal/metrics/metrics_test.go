@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ReceivedRequests.WithLabelValues("test-receiver").Inc()
+	ReceivedBytes.WithLabelValues("test-receiver").Add(10)
+	BufferedRows.WithLabelValues("db.table|field1").Set(5)
+	Flushes.WithLabelValues(FlushTriggerTimeout).Inc()
+	InsertDuration.WithLabelValues("test-inserter").Observe(0.1)
+	InsertErrors.WithLabelValues("test-inserter").Inc()
+	ActiveTableManagers.Inc()
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`dbatcher_receiver_requests_total{receiver="test-receiver"} 1`,
+		`dbatcher_receiver_received_bytes_total{receiver="test-receiver"} 10`,
+		`dbatcher_table_buffered_rows{table="db.table|field1"} 5`,
+		`dbatcher_table_flushes_total{trigger="timeout"} 1`,
+		`dbatcher_inserter_insert_duration_seconds_count{inserter="test-inserter"} 1`,
+		`dbatcher_inserter_insert_errors_total{inserter="test-inserter"} 1`,
+		`dbatcher_table_managers_active 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics should contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package receiver
+
+import (
+	"context"
+
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tablemanager"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+//batchEntry is a table's rows of POST /batch body,
+//fields are the same as parameters of a single table request
+type batchEntry struct {
+	Table     string              `json:"table"`
+	Fields    string              `json:"fields"`
+	Rows      jsoniter.RawMessage `json:"rows"`
+	TimeoutMs uint                `json:"timeout_ms"`
+	MaxRows   uint                `json:"max_rows"`
+	MaxBytes  uint                `json:"max_bytes"`
+	Sync      bool                `json:"sync"`
+	Persist   bool                `json:"persist"`
+	Inserters []string            `json:"inserters"`
+}
+
+//batchEntryStatus is a result of a batch entry: status is the code
+//a single table request would get, error is its message,
+//receipt is ID of receipt of the table's batch the rows joined
+type batchEntryStatus struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Receipt string `json:"receipt,omitempty"`
+}
+
+//handleBatch appends every entry of JSON array body to its table.
+//Responds 200 with JSON array of entries' statuses in the same order
+//if body is valid. Retry-After is set if an entry's buffer is full
+func (r *HTTPReceiver) handleBatch(traceCtx context.Context, ctx *fasthttp.RequestCtx, body []byte) {
+	var entries []batchEntry
+	if err := jsoniter.Unmarshal(body, &entries); err != nil {
+		ctx.Error("batch: "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	statuses := make([]batchEntryStatus, len(entries))
+	bufferFull := false
+	for i, entry := range entries {
+		receiptID, err := r.appendBatchEntry(traceCtx, entry)
+		statuses[i] = batchEntryStatus{Status: fasthttp.StatusOK, Receipt: receiptID}
+		if err != nil {
+			statuses[i] = batchEntryStatus{Status: appendErrorStatusCode(err), Error: err.Error()}
+			r.logger.Debug("batch entry rejected", "table", entry.Table, "status", statuses[i].Status, "error", err)
+			bufferFull = bufferFull || statuses[i].Status != fasthttp.StatusBadRequest
+		}
+	}
+	data, err := jsoniter.Marshal(statuses)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if bufferFull {
+		ctx.Response.Header.Set("Retry-After", bufferFullRetryAfter)
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+func (r *HTTPReceiver) appendBatchEntry(traceCtx context.Context, entry batchEntry) (string, error) {
+	ts := table.NewSignature(entry.Table, entry.Fields)
+	if err := ts.Validate(); err != nil {
+		return "", err
+	}
+	tmc := tablemanager.Config{}
+	if !entry.Sync {
+		tmc = tablemanager.NewConfig(int64(entry.TimeoutMs), int64(entry.MaxRows), entry.Persist)
+		tmc.MaxBytes = int64(entry.MaxBytes)
+	}
+	tmc.Inserters = entry.Inserters
+
+	return r.tMHolder.AppendRowsWithReceipt(traceCtx, &ts, tmc, entry.Sync, table.NewJSONRows(entry.Rows))
+}
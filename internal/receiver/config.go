@@ -2,6 +2,14 @@ package receiver
 
 //Config is a config for a receiver
 type Config struct {
+	//Name is receiver's name from config's receivers section
+	Name string `toml:"-"`
 	Type string `toml:"type"`
 	Bind string `toml:"bind"`
+	//ReadyMaxBufferedBytes makes readiness check fail when rows waiting
+	//for insert take more bytes. 0 means no limit
+	ReadyMaxBufferedBytes int64 `toml:"ready_max_buffered_bytes"`
+	//MaxDecompressedBytes limits size of decompressed request body
+	//(see Content-Encoding). 0 means DefaultMaxDecompressedBytes
+	MaxDecompressedBytes int64 `toml:"max_decompressed_bytes"`
 }
@@ -0,0 +1,98 @@
+package receiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+//DefaultMaxDecompressedBytes limits decompressed request body
+//if receiver's MaxDecompressedBytes isn't set
+const DefaultMaxDecompressedBytes = 64 << 20
+
+var (
+	//ErrUnknownContentEncoding means request's Content-Encoding isn't supported
+	ErrUnknownContentEncoding = errors.New("Content-Encoding should be gzip, zstd or br")
+	//ErrDecompressedTooLarge means decompressed body is bigger than max_decompressed_bytes
+	ErrDecompressedTooLarge = errors.New("decompressed body is too large")
+)
+
+var gzipReaderPool sync.Pool
+
+//decompressor decompresses request bodies up to maxBytes
+type decompressor struct {
+	maxBytes int64
+	zstd     *zstd.Decoder
+}
+
+func newDecompressor(maxBytes int64) (*decompressor, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxDecompressedBytes
+	}
+	//DecodeAll of zstd decoder is safe for concurrent use
+	//and checks size while decoding
+	zstdDecoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &decompressor{maxBytes: maxBytes, zstd: zstdDecoder}, nil
+}
+
+//decompress returns body decompressed according to contentEncoding,
+//body itself if there is no encoding
+func (d *decompressor) decompress(contentEncoding, body []byte) ([]byte, error) {
+	switch string(bytes.ToLower(bytes.TrimSpace(contentEncoding))) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return d.decompressGzip(body)
+	case "zstd":
+		data, err := d.zstd.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, ErrDecompressedTooLarge
+		}
+		return data, err
+	case "br":
+		return d.readLimited(brotli.NewReader(bytes.NewReader(body)))
+	default:
+		return nil, ErrUnknownContentEncoding
+	}
+}
+
+func (d *decompressor) decompressGzip(body []byte) ([]byte, error) {
+	var reader *gzip.Reader
+	var err error
+	if pooled := gzipReaderPool.Get(); pooled != nil {
+		reader = pooled.(*gzip.Reader)
+		err = reader.Reset(bytes.NewReader(body))
+	} else {
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReaderPool.Put(reader)
+
+	return d.readLimited(reader)
+}
+
+//readLimited reads r till the end, returns ErrDecompressedTooLarge
+//if there are more than maxBytes
+func (d *decompressor) readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, d.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > d.maxBytes {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return data, nil
+}
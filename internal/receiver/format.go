@@ -0,0 +1,118 @@
+package receiver
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/valyala/fasthttp"
+)
+
+//Formats of request body (values of format parameter)
+const (
+	//FormatJSON is JSON array of arrays in order of fields, default
+	FormatJSON = "json"
+	//FormatNDJSON is a JSON object per line, keys are fields
+	FormatNDJSON = "ndjson"
+	//FormatJSONEachRow is ClickHouse's name of FormatNDJSON
+	FormatJSONEachRow = "jsoneachrow"
+	//FormatCSV is comma separated values
+	FormatCSV = "csv"
+	//FormatTSV is tab separated values
+	FormatTSV = "tsv"
+)
+
+//Content types of binary request bodies, format parameter is ignored for them
+const (
+	//ContentTypeMsgpack is MessagePack array of arrays in order of fields
+	ContentTypeMsgpack = "application/msgpack"
+	//ContentTypeProtobuf is Rows message of clients/rows.proto
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	//ErrUnknownFormat means format parameter has unknown value
+	ErrUnknownFormat = errors.New("format should be json, ndjson, csv or tsv")
+	//ErrInvalidDelimiter means delimiter parameter isn't a single character or is a quote or line break
+	ErrInvalidDelimiter = errors.New("delimiter should be a single character except quote and line breaks")
+	//ErrInvalidQuote means quote parameter isn't a single character or empty
+	ErrInvalidQuote = errors.New("quote should be a single character or empty")
+)
+
+//decodeRows decodes body of a binary content type or of NDJSON,
+//converts body of other format from request's parameters to JSON array of arrays.
+//Returns fields (they could be derived from body) and rows
+func decodeRows(contentType []byte, args *fasthttp.Args, fields string, body []byte) (string, table.Rows, error) {
+	if i := bytes.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	switch string(bytes.TrimSpace(contentType)) {
+	case ContentTypeMsgpack, "application/x-msgpack":
+		values, err := table.DecodeMsgpackRows(body)
+		return fields, table.NewValueRows(values, len(body)), err
+	case ContentTypeProtobuf, "application/protobuf":
+		bodyFields, values, err := table.DecodeProtobufRows(body)
+		if fields == "" {
+			fields = bodyFields
+		}
+		return fields, table.NewValueRows(values, len(body)), err
+	}
+	switch string(args.Peek("format")) {
+	case FormatNDJSON, FormatJSONEachRow:
+		fields, values, err := table.DecodeNDJSONRows(body, fields, string(args.Peek("missing")))
+		return fields, table.NewValueRows(values, len(body)), err
+	}
+	fields, rowsJSON, err := convertRows(args, fields, body)
+
+	return fields, table.NewJSONRows(rowsJSON), err
+}
+
+//convertRows converts body of format from request's parameters
+//to JSON array of arrays. Returns fields (they could be derived from body)
+//and rows JSON
+func convertRows(args *fasthttp.Args, fields string, body []byte) (string, []byte, error) {
+	switch string(args.Peek("format")) {
+	case "", FormatJSON:
+		return fields, body, nil
+	case FormatCSV:
+		return convertCSV(args, fields, body, table.DefaultCSVOptions())
+	case FormatTSV:
+		return convertCSV(args, fields, body, table.DefaultTSVOptions())
+	default:
+		return "", nil, ErrUnknownFormat
+	}
+}
+
+//convertCSV overrides options by delimiter, quote, null
+//and header parameters and converts CSV or TSV body
+func convertCSV(args *fasthttp.Args, fields string, body []byte, options table.CSVOptions) (string, []byte, error) {
+	if args.Has("quote") {
+		quote := args.Peek("quote")
+		options.Quote = 0
+		if len(quote) != 0 {
+			r, size := utf8.DecodeRune(quote)
+			if size != len(quote) || r == utf8.RuneError || r == '\n' || r == '\r' {
+				return "", nil, ErrInvalidQuote
+			}
+			options.Quote = r
+		}
+	}
+	if args.Has("delimiter") {
+		delimiter := args.Peek("delimiter")
+		r, size := utf8.DecodeRune(delimiter)
+		if size == 0 || size != len(delimiter) || r == utf8.RuneError {
+			return "", nil, ErrInvalidDelimiter
+		}
+		options.Delimiter = r
+	}
+	if options.Delimiter == options.Quote || options.Delimiter == '\n' || options.Delimiter == '\r' {
+		return "", nil, ErrInvalidDelimiter
+	}
+	if args.Has("null") {
+		options.Null = string(args.Peek("null"))
+	}
+	options.Header = args.GetBool("header")
+
+	return table.CSVToRows(body, fields, options)
+}
@@ -1,18 +1,31 @@
 package receiver
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/edwvee/dbatcher/internal/inserter"
 	"github.com/edwvee/dbatcher/internal/table"
 	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/internal/tracing/tracingtest"
 	"github.com/edwvee/dbatcher/pkg/httpclient"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/valyala/fasthttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const bindEnvKey = "DBATCHER_HTTP_RECEIVER_TEST_BIND"
@@ -81,7 +94,7 @@ func (si *selfSliceInserter) Init(c inserter.Config) error {
 	return nil
 }
 
-func (si *selfSliceInserter) Insert(t *table.Table) error {
+func (si *selfSliceInserter) Insert(ctx context.Context, t *table.Table) error {
 	si.dataMut.Lock()
 	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
 		si.data = append(si.data, row)
@@ -160,9 +173,17 @@ func TestHTTPReceiverByRequests(t *testing.T) {
 	if code != 400 {
 		t.Errorf("code should be 400, got: %d", code)
 	}
+	//max_rows is absent and there is no table policy
+	code, _, err = fasthttp.Post(nil, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 400 {
+		t.Errorf("code should be 400, got: %d", code)
+	}
 	url += "&max_rows=10"
 
-	//yet not support persist
+	//persist isn't configured
 	url0 = url + "&persist=1"
 	code, _, err = fasthttp.Post(nil, url0, nil)
 	if err != nil {
@@ -176,6 +197,18 @@ func TestHTTPReceiverByRequests(t *testing.T) {
 	request := fasthttp.AcquireRequest()
 	response := fasthttp.AcquireResponse()
 
+	//not existing inserter
+	request.Header.SetMethod(fasthttp.MethodPost)
+	request.SetRequestURI(url + "&inserters=first,second")
+	request.SetBodyRaw([]byte("[[2,3]]"))
+	if err = client.Do(request, response); err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 400 {
+		t.Errorf("code should be 400, got: %d", code)
+	}
+	url += "&inserters=first"
+
 	//unsuccsessfull insert
 	request.Header.SetMethod(fasthttp.MethodPost)
 	request.SetRequestURI(url)
@@ -205,6 +238,603 @@ func TestHTTPReceiverByRequests(t *testing.T) {
 		t.Errorf("send 10 records, got %d", len(data))
 	}
 
+	//timeout_ms and max_rows from table policy
+	tmh.SetTablePolicies(map[string]tablemanager.TablePolicy{
+		"policy_table": {TimeoutMs: 60000, MaxRows: 1},
+	})
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=policy_table&fields=field1,field2", defaultHTTPReceiverBind))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); len(data) != 1 {
+		t.Errorf("row should be inserted by policy's max_rows, got %d", len(data))
+	}
+
+	//ndjson with fields from keys
+	ndjsonURL := fmt.Sprintf("http://%s/?table=ndjson_table&timeout_ms=60000&max_rows=2&format=ndjson", defaultHTTPReceiverBind)
+	request.SetRequestURI(ndjsonURL)
+	request.SetBodyRaw([]byte(`{"field2":"b","field1":1}` + "\n" + `{"field1":2}` + "\n"))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); fmt.Sprint(data) != "[[1 b] [2 <nil>]]" {
+		t.Errorf("ndjson rows should be inserted in fields order, got %v", data)
+	}
+	request.SetRequestURI(ndjsonURL + "&missing=reject")
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 400 {
+		t.Errorf("code should be 400 for missing key, got %d", code)
+	}
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=ndjson_table&timeout_ms=60000&max_rows=2&format=xml", defaultHTTPReceiverBind))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 400 {
+		t.Errorf("code should be 400 for unknown format, got %d", code)
+	}
+
+	//csv with header and tsv with fields
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=csv_table&timeout_ms=60000&max_rows=2&format=csv&header=1&delimiter=;", defaultHTTPReceiverBind))
+	request.SetBodyRaw([]byte("field2;field1\n\"b;c\";1\n\\N;2\n"))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); fmt.Sprint(data) != "[[b;c 1] [<nil> 2]]" {
+		t.Errorf("csv rows should be inserted in header order, got %v", data)
+	}
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=tsv_table&fields=field1,field2&timeout_ms=60000&max_rows=2&format=tsv&null=NULL", defaultHTTPReceiverBind))
+	request.SetBodyRaw([]byte("1\ta\\tb\n2\tNULL\n"))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); fmt.Sprint(data) != "[[1 a\tb] [2 <nil>]]" {
+		t.Errorf("tsv rows should be inserted, got %v", data)
+	}
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=csv_table&fields=field1,field2&timeout_ms=60000&max_rows=2&format=csv&delimiter=ab", defaultHTTPReceiverBind))
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 400 {
+		t.Errorf("code should be 400 for invalid delimiter, got %d", code)
+	}
+
+	//msgpack and protobuf with fields from body
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=binary_table&fields=field1,field2&timeout_ms=60000&max_rows=1", defaultHTTPReceiverBind))
+	request.Header.SetContentType(ContentTypeMsgpack)
+	request.SetBodyRaw([]byte{0x91, 0x92, 0x01, 0xa1, 'b'})
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); fmt.Sprint(data) != "[[1 b]]" {
+		t.Errorf("msgpack rows should be inserted, got %v", data)
+	}
+	request.SetBodyRaw([]byte{0x91, 0x92, 0x01})
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 400 {
+		t.Errorf("code should be 400 for broken msgpack, got %d", code)
+	}
+	request.SetRequestURI(fmt.Sprintf("http://%s/?table=binary_table&timeout_ms=60000&max_rows=1", defaultHTTPReceiverBind))
+	request.Header.SetContentType(ContentTypeProtobuf)
+	body := append([]byte{0x0a, 13}, "field1,field2"...)
+	body = append(body, 0x12, 0x09, 0x0a, 0x02, 0x10, 0x02, 0x0a, 0x03, 0x0a, 0x01, 'b')
+	request.SetBodyRaw(body)
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 200 {
+		t.Errorf("code should be 200, got %d: %s", code, response.Body())
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); fmt.Sprint(data) != "[[1 b]]" {
+		t.Errorf("protobuf rows should be inserted, got %v", data)
+	}
+	request.Header.SetContentType("application/json")
+	request.SetBodyRaw([]byte("[[2,3]]"))
+
+	//full buffer
+	bufferURL := fmt.Sprintf("http://%s/?table=buffer_table&fields=field1,field2&timeout_ms=60000&max_rows=1000", defaultHTTPReceiverBind)
+	request.SetRequestURI(bufferURL)
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	err = tmh.SetBufferConfig(tablemanager.BufferConfig{MaxBufferedBytes: 1, OnFull: tablemanager.OnFullReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Do(request, response)
+	if err != nil {
+		t.Errorf("shouldn't be an error: %s", err.Error())
+	}
+	if code := response.StatusCode(); code != 429 {
+		t.Errorf("code should be 429, got %d", code)
+	}
+	if retryAfter := string(response.Header.Peek("Retry-After")); retryAfter != "1" {
+		t.Errorf("Retry-After should be 1, got %q", retryAfter)
+	}
+}
+
+func TestHTTPReceiverBatch(t *testing.T) {
+	rec := &HTTPReceiver{}
+	errChan := make(chan error)
+	ins := &selfSliceInserter{}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := tablemanager.NewHolder(errChan, map[string]inserter.Inserter{"first": ins}, logger)
+	if err := rec.Init(defaultHTTPReceiverConfig, errChan, tmh); err != nil {
+		t.Fatal(err)
+	}
+	rec.Receive()
+	defer rec.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	url := fmt.Sprintf("http://%s/batch", defaultHTTPReceiverBind)
+	body := `[
+		{"table":"first_table","fields":"field1,field2","rows":[[1,"a"],[2,"b"]],"sync":true},
+		{"table":"second_table","fields":"field1","rows":[[3]],"timeout_ms":60000,"max_rows":1},
+		{"table":"second_table","fields":"field1","rows":[[4]]},
+		{"table":"third_table","fields":"field1","rows":[[5,6]],"sync":true}
+	]`
+	code, _, err := fasthttp.Post(nil, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 400 {
+		t.Errorf("code should be 400 for empty body, got %d", code)
+	}
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	request.Header.SetMethod(fasthttp.MethodPost)
+	request.SetRequestURI(url)
+	request.SetBodyString(body)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := fasthttp.Do(request, resp); err != nil {
+		t.Fatal(err)
+	}
+	if code := resp.StatusCode(); code != 200 {
+		t.Fatalf("code should be 200, got %d: %s", code, resp.Body())
+	}
+	response := resp.Body()
+	var statuses []batchEntryStatus
+	if err := jsoniter.Unmarshal(response, &statuses); err != nil {
+		t.Fatal(err)
+	}
+	expectedCodes := []int{200, 200, 400, 400}
+	if len(statuses) != len(expectedCodes) {
+		t.Fatalf("should be %d statuses, got %s", len(expectedCodes), response)
+	}
+	for i, status := range statuses {
+		if status.Status != expectedCodes[i] {
+			t.Errorf("entry %d: code should be %d, got %d: %s", i, expectedCodes[i], status.Status, status.Error)
+		}
+		if (status.Status == 200) != (status.Error == "") {
+			t.Errorf("entry %d: only failed entry should have error, got %q", i, status.Error)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); len(data) != 3 {
+		t.Errorf("should insert 3 rows of batch, got %v", data)
+	}
+}
+
+func TestHTTPReceiverWait(t *testing.T) {
+	rec := &HTTPReceiver{}
+	errChan := make(chan error)
+	ins := &selfSliceInserter{}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := tablemanager.NewHolder(errChan, map[string]inserter.Inserter{"first": ins}, logger)
+	if err := rec.Init(defaultHTTPReceiverConfig, errChan, tmh); err != nil {
+		t.Fatal(err)
+	}
+	rec.Receive()
+	defer rec.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	url := fmt.Sprintf("http://%s/?table=table&fields=field1&timeout_ms=60000&max_rows=2&wait=1", defaultHTTPReceiverBind)
+	code, _, err := fasthttp.Post(nil, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 400 {
+		t.Errorf("code should be 400 for empty body, got %d", code)
+	}
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	request.Header.SetMethod(fasthttp.MethodPost)
+	request.SetRequestURI(url)
+	request.SetBodyString("[[1],[2]]")
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := fasthttp.Do(request, resp); err != nil {
+		t.Fatal(err)
+	}
+	if code := resp.StatusCode(); code != 200 {
+		t.Fatalf("code should be 200, got %d: %s", code, resp.Body())
+	}
+	if data := ins.TakeSlice(); len(data) != 2 {
+		t.Errorf("rows should be inserted before response, got %v", data)
+	}
+	if id := resp.Header.Peek(receiptHeader); len(id) == 0 {
+		t.Error("response should have receipt")
+	}
+
+	request.SetRequestURI(url + "&wait_timeout_ms=50")
+	request.SetBodyString("[[3]]")
+	if err := fasthttp.Do(request, resp); err != nil {
+		t.Fatal(err)
+	}
+	if code := resp.StatusCode(); code != 504 {
+		t.Errorf("code should be 504 after wait timeout, got %d: %s", code, resp.Body())
+	}
+}
+
+func TestHTTPReceiverWaitStop(t *testing.T) {
+	rec := &HTTPReceiver{}
+	errChan := make(chan error)
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := tablemanager.NewHolder(errChan, map[string]inserter.Inserter{"first": &selfSliceInserter{}}, logger)
+	defer tmh.StopTableManagers()
+	if err := rec.Init(defaultHTTPReceiverConfig, errChan, tmh); err != nil {
+		t.Fatal(err)
+	}
+	rec.Receive()
+	time.Sleep(time.Millisecond * 100)
+
+	url := fmt.Sprintf("http://%s/?table=table&fields=field1&timeout_ms=60000&max_rows=100&wait=1", defaultHTTPReceiverBind)
+	codeChan := make(chan int, 1)
+	go func() {
+		request := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(request)
+		request.Header.SetMethod(fasthttp.MethodPost)
+		request.SetRequestURI(url)
+		request.SetBodyString("[[1]]")
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		if err := fasthttp.Do(request, resp); err != nil {
+			t.Error(err)
+		}
+		codeChan <- resp.StatusCode()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if err := rec.Stop(); err != nil {
+		t.Errorf("waiting request shouldn't hold shutdown: %s", err)
+	}
+	if code := <-codeChan; code != 503 {
+		t.Errorf("code should be 503 after stop, got %d", code)
+	}
+}
+
+func TestHTTPReceiverTracing(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+
+	rec := &HTTPReceiver{}
+	errChan := make(chan error)
+	ins := &selfSliceInserter{}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := tablemanager.NewHolder(errChan, map[string]inserter.Inserter{"first": ins}, logger)
+	config := defaultHTTPReceiverConfig
+	config.Name = "traced"
+	if err := rec.Init(config, errChan, tmh); err != nil {
+		t.Fatal(err)
+	}
+	rec.Receive()
+	defer rec.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const unsampledTraceID = "0af7651916cd43dd8448eb211c80319c"
+	url := fmt.Sprintf("http://%s/?table=table&fields=field1&timeout_ms=60000&max_rows=1&wait=1", defaultHTTPReceiverBind)
+	for _, traceparent := range []string{
+		"00-" + traceID + "-00f067aa0ba902b7-01",
+		"00-" + unsampledTraceID + "-b7ad6b7169203331-00",
+		"invalid",
+	} {
+		request := fasthttp.AcquireRequest()
+		request.Header.SetMethod(fasthttp.MethodPost)
+		request.Header.Set(traceparentHeader, traceparent)
+		request.SetRequestURI(url)
+		request.SetBodyString("[[1]]")
+		resp := fasthttp.AcquireResponse()
+		err := fasthttp.Do(request, resp)
+		code := resp.StatusCode()
+		fasthttp.ReleaseRequest(request)
+		fasthttp.ReleaseResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != 200 {
+			t.Fatalf("%s: code should be 200, got %d", traceparent, code)
+		}
+	}
+	tmh.StopTableManagers()
+
+	byID := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	var servers, inserts []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		byID[span.SpanContext().SpanID()] = span
+		if span.SpanContext().TraceID().String() == unsampledTraceID {
+			t.Errorf("request with not sampled traceparent shouldn't be recorded: %+v", span)
+		}
+		switch span.Name() {
+		case "POST /":
+			servers = append(servers, span)
+		case "table.insert":
+			inserts = append(inserts, span)
+		}
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 server spans, got %+v", servers)
+	}
+	var server sdktrace.ReadOnlySpan
+	for _, span := range servers {
+		attributes := tracingtest.Attributes(span)
+		if span.SpanKind() != trace.SpanKindServer || attributes["receiver"] != "traced" ||
+			attributes["http.response.status_code"] != int64(200) {
+			t.Errorf("wrong server span: %+v", span)
+		}
+		if span.SpanContext().TraceID().String() == traceID {
+			server = span
+		} else if span.Parent().IsValid() {
+			t.Errorf("span of invalid traceparent should be a root: %+v", span)
+		}
+	}
+	if server == nil || server.Parent().SpanID().String() != "00f067aa0ba902b7" || tracingtest.Attributes(server)["table"] != "table" {
+		t.Fatalf("server span should continue traceparent: %+v", server)
+	}
+
+	var linkedInsert sdktrace.ReadOnlySpan
+	for _, span := range inserts {
+		for _, link := range span.Links() {
+			if link.SpanContext.TraceID().String() == traceID {
+				linkedInsert = span
+			}
+		}
+	}
+	if linkedInsert == nil {
+		t.Fatalf("an insert should be linked to request's trace: %+v", inserts)
+	}
+	tableAppend := byID[linkedInsert.Links()[0].SpanContext.SpanID()]
+	if tableAppend == nil || tableAppend.Name() != "table.append" {
+		t.Fatalf("insert should be linked to table.append: %+v", tableAppend)
+	}
+	appendSpan := byID[tableAppend.Parent().SpanID()]
+	if appendSpan == nil || appendSpan.Name() != "append" || appendSpan.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("insert should be linked to table.append of the request: %+v, %+v", tableAppend, appendSpan)
+	}
+	inserterSpans := 0
+	for _, span := range byID {
+		if span.Name() == "inserter.insert" && span.Parent().SpanID() == linkedInsert.SpanContext().SpanID() &&
+			tracingtest.Attributes(span)["inserter"] == "first" {
+			inserterSpans++
+		}
+	}
+	if inserterSpans != 1 {
+		t.Errorf("insert should have 1 inserter.insert child, got %d", inserterSpans)
+	}
+}
+
+func TestAppendErrorStatusCode(t *testing.T) {
+	cases := map[error]int{
+		tablemanager.ErrBufferFull:        429,
+		tablemanager.ErrBufferFullTimeout: 503,
+		tablemanager.ErrZeroMaxRows:       400,
+	}
+	for err, expected := range cases {
+		if code := appendErrorStatusCode(err); code != expected {
+			t.Errorf("%s: code should be %d, got %d", err, expected, code)
+		}
+	}
+
+	insertErr := tablemanager.InsertError{Errors: map[string]error{"first": errors.New("timeout")}}
+	if code := waitErrorStatusCode(insertErr); code != 502 {
+		t.Errorf("%s: code should be 502, got %d", insertErr, code)
+	}
+	cases = map[error]int{
+		fmt.Errorf("context deadline exceeded: %w", tablemanager.ErrWaitTimeout): 504,
+		tablemanager.ErrBufferFull: 429,
+	}
+	for err, expected := range cases {
+		if code := waitErrorStatusCode(err); code != expected {
+			t.Errorf("%s: code should be %d, got %d", err, expected, code)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	body := []byte(strings.Repeat("[[1,2]]", 100))
+	var gzipped, brotlied bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write(body)
+	gzipWriter.Close()
+	brotliWriter := brotli.NewWriter(&brotlied)
+	brotliWriter.Write(body)
+	brotliWriter.Close()
+	zstdEncoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := map[string][]byte{
+		"":         body,
+		"gzip":     gzipped.Bytes(),
+		"zstd":     zstdEncoder.EncodeAll(body, nil),
+		"br":       brotlied.Bytes(),
+		"identity": body,
+	}
+
+	d, err := newDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for encoding, data := range compressed {
+		decompressed, err := d.decompress([]byte(encoding), data)
+		if err != nil {
+			t.Errorf("%s: %s", encoding, err)
+			continue
+		}
+		if !bytes.Equal(decompressed, body) {
+			t.Errorf("%s: decompressed body doesn't match", encoding)
+		}
+	}
+
+	d, err = newDecompressor(int64(len(body) - 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for encoding, data := range compressed {
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		if _, err := d.decompress([]byte(encoding), data); err != ErrDecompressedTooLarge {
+			t.Errorf("%s: should be %s, got %v", encoding, ErrDecompressedTooLarge, err)
+		}
+	}
+	if _, err := d.decompress([]byte("lz4"), body); err != ErrUnknownContentEncoding {
+		t.Errorf("should be %s, got %v", ErrUnknownContentEncoding, err)
+	}
+	if _, err := d.decompress([]byte("gzip"), body); err == nil {
+		t.Error("not gzipped body should be an error")
+	}
+
+	cases := map[error]int{
+		ErrDecompressedTooLarge:   413,
+		ErrUnknownContentEncoding: 415,
+		io.ErrUnexpectedEOF:       400,
+	}
+	for err, expected := range cases {
+		if code := decompressErrorStatusCode(err); code != expected {
+			t.Errorf("%s: code should be %d, got %d", err, expected, code)
+		}
+	}
+}
+
+func TestParseInserters(t *testing.T) {
+	cases := map[string][]string{
+		"":              nil,
+		"first":         {"first"},
+		"first, second": {"first", "second"},
+		",first,,":      {"first"},
+	}
+	for inserters, expected := range cases {
+		if names := parseInserters(inserters); !reflect.DeepEqual(names, expected) {
+			t.Errorf("%q: expected %v, got %v", inserters, expected, names)
+		}
+	}
+}
+
+type pingInserter struct {
+	pingErr error
+}
+
+func (pi *pingInserter) Init(c inserter.Config) error {
+	return nil
+}
+
+func (pi *pingInserter) Insert(ctx context.Context, t *table.Table) error {
+	return nil
+}
+
+func (pi *pingInserter) Ping(ctx context.Context) error {
+	return pi.pingErr
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	rec := &HTTPReceiver{}
+	errChan := make(chan error)
+	pinger := &pingInserter{}
+	inserters := map[string]inserter.Inserter{
+		"pinger": pinger,
+	}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := tablemanager.NewHolder(errChan, inserters, logger)
+	defer tmh.StopTableManagers()
+	config := defaultHTTPReceiverConfig
+	config.ReadyMaxBufferedBytes = 10
+	if err := rec.Init(config, errChan, tmh); err != nil {
+		t.Fatalf("shouldn't return error: %s", err.Error())
+	}
+	rec.Receive()
+	defer rec.Stop()
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case <-errChan:
+		t.Fatal("there shouldn't be an error. server didn't start")
+	default:
+	}
+
+	get := func(path string) int {
+		code, _, err := fasthttp.Get(nil, "http://"+defaultHTTPReceiverBind+path)
+		if err != nil {
+			t.Fatalf("got error trying to get %s: %s", path, err.Error())
+		}
+		return code
+	}
+	if code := get("/healthz"); code != 200 {
+		t.Errorf("/healthz code should be 200, got: %d", code)
+	}
+	if code := get("/readyz"); code != 200 {
+		t.Errorf("/readyz code should be 200, got: %d", code)
+	}
+
+	//failed ping
+	pinger.pingErr = errors.New("connection refused")
+	if code := get("/readyz"); code != 503 {
+		t.Errorf("/readyz code should be 503 on failed ping, got: %d", code)
+	}
+	if code := get("/healthz"); code != 200 {
+		t.Errorf("/healthz code should be 200 on failed ping, got: %d", code)
+	}
+	pinger.pingErr = nil
+
+	//too many buffered bytes
+	ts := table.NewSignature("table", "field1,field2")
+	tmc := tablemanager.NewConfig(60000, 1000, false)
+	if err := tmh.Append(&ts, tmc, false, []byte("[[1,2],[3,4]]")); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/readyz"); code != 503 {
+		t.Errorf("/readyz code should be 503 on buffer overflow, got: %d", code)
+	}
+
+	//shutting down
+	atomic.StoreInt32(&rec.shuttingDown, 1)
+	rec.readyMaxBufferedBytes = 0
+	if err := rec.checkReady(); err == nil {
+		t.Error("shouldn't be ready while shutting down")
+	}
 }
 
 func TestShutdown(t *testing.T) {
@@ -1,26 +1,73 @@
 package receiver
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
 	"github.com/edwvee/dbatcher/internal/table"
 	"github.com/edwvee/dbatcher/internal/tablemanager"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const maxShutdownTime = 2 * time.Second
 
-//ErrDidntShutdownInTime means that HTTPReceiver didn't process all requests and
-//closed all connections in time
-var ErrDidntShutdownInTime = errors.New("HTTPReceiver: server didn't shutdown in time")
+//readyPingTimeout limits inserters' pings in readiness check
+const readyPingTimeout = time.Second
+
+//bufferFullRetryAfter is Retry-After header's seconds when buffer is full
+const bufferFullRetryAfter = "1"
+
+//receiptHeader has ID of receipt of the batch accepted rows joined
+const receiptHeader = "X-Dbatcher-Receipt"
+
+//receiptsPath is prefix of GET path with receipt ID
+const receiptsPath = "/receipts/"
+
+//traceparentHeader is W3C Trace Context header, request's span is a child of it's span
+const traceparentHeader = "traceparent"
+
+//defaultWaitTimeout limits wait=1 requests without wait_timeout_ms
+const defaultWaitTimeout = 30 * time.Second
+
+var (
+	//ErrDidntShutdownInTime means that HTTPReceiver didn't process all requests and
+	//closed all connections in time
+	ErrDidntShutdownInTime = errors.New("HTTPReceiver: server didn't shutdown in time")
+	//ErrStoppedWaiting means that HTTPReceiver is stopped while wait=1 request
+	//waited for insert, rows stay in the batch and are inserted on holder's stop
+	ErrStoppedWaiting = errors.New("HTTPReceiver: stopped before rows were inserted")
+)
 
 //HTTPReceiver receives data via HTTP
 type HTTPReceiver struct {
+	//shuttingDown is set to 1 by Stop
+	shuttingDown int32
+	//stopChan is closed by Stop, so wait=1 requests don't hold shutdown
+	stopChan chan struct{}
+
+	name     string
 	bind     string
 	server   *fasthttp.Server
 	errChan  chan error
 	tMHolder *tablemanager.Holder
+
+	readyMaxBufferedBytes int64
+	decompressor          *decompressor
+
+	requestsCounter prometheus.Counter
+	bytesCounter    prometheus.Counter
+	logger          *logging.Logger
 }
 
 //Init configures HTTPReceiver
@@ -28,6 +75,21 @@ func (r *HTTPReceiver) Init(config Config, errChan chan error, tMHolder *tablema
 	r.bind = config.Bind
 	r.errChan = errChan
 	r.tMHolder = tMHolder
+	r.readyMaxBufferedBytes = config.ReadyMaxBufferedBytes
+	r.stopChan = make(chan struct{})
+	decompressor, err := newDecompressor(config.MaxDecompressedBytes)
+	if err != nil {
+		return err
+	}
+	r.decompressor = decompressor
+	name := config.Name
+	if name == "" {
+		name = config.Bind
+	}
+	r.requestsCounter = metrics.ReceivedRequests.WithLabelValues(name)
+	r.bytesCounter = metrics.ReceivedBytes.WithLabelValues(name)
+	r.name = name
+	r.logger = logging.With("receiver", name)
 	r.server = &fasthttp.Server{
 		Handler:               r.handle,
 		CloseOnShutdown:       true,
@@ -53,16 +115,51 @@ func (r *HTTPReceiver) receive() {
 	}
 }
 
-func (r HTTPReceiver) handle(ctx *fasthttp.RequestCtx) {
+func (r *HTTPReceiver) handle(ctx *fasthttp.RequestCtx) {
+	if ctx.IsGet() {
+		switch string(ctx.Path()) {
+		case "/healthz":
+			ctx.SetBodyString("ok")
+			return
+		case "/readyz":
+			r.handleReady(ctx)
+			return
+		}
+		if path := string(ctx.Path()); strings.HasPrefix(path, receiptsPath) {
+			r.handleReceipt(ctx, strings.TrimPrefix(path, receiptsPath))
+			return
+		}
+	}
+
+	r.requestsCounter.Inc()
 	if !ctx.IsPost() {
 		ctx.Error("HTTP method should be POST", 405)
 		return
 	}
+	traceCtx, span := r.startSpan(ctx)
+	defer endSpan(ctx, span)
 
 	args := ctx.QueryArgs()
 
+	rowsData := ctx.PostBody()
+	r.bytesCounter.Add(float64(len(rowsData)))
+	rowsData, err := r.decompressor.decompress(ctx.Request.Header.Peek(fasthttp.HeaderContentEncoding), rowsData)
+	if err != nil {
+		ctx.Error(err.Error(), decompressErrorStatusCode(err))
+		return
+	}
+	if string(ctx.Path()) == "/batch" {
+		r.handleBatch(traceCtx, ctx, rowsData)
+		return
+	}
+
 	t := string(args.Peek("table"))
-	f := string(args.Peek("fields"))
+	f, rows, err := decodeRows(ctx.Request.Header.ContentType(), args, string(args.Peek("fields")), rowsData)
+	if err != nil {
+		ctx.Error(err.Error(), 400)
+		return
+	}
+	span.SetAttributes(attribute.String("table", t))
 	ts := table.NewSignature(t, f)
 	if err := ts.Validate(); err != nil {
 		ctx.Error(err.Error(), 400)
@@ -71,37 +168,227 @@ func (r HTTPReceiver) handle(ctx *fasthttp.RequestCtx) {
 
 	tmc := tablemanager.Config{}
 	sync := args.GetBool("sync")
+	wait := !sync && args.GetBool("wait")
+	waitTimeout := defaultWaitTimeout
 	if !sync {
-		timeoutMs, err := args.GetUint("timeout_ms")
+		//absent values are taken from table's policy, config is validated by holder
+		timeoutMs, err := getOptionalUint(args, "timeout_ms")
 		if err != nil {
 			ctx.Error("timeout_ms: "+err.Error(), 400)
 			return
 		}
-		maxRows, err := args.GetUint("max_rows")
+		maxRows, err := getOptionalUint(args, "max_rows")
 		if err != nil {
 			ctx.Error("max_rows: "+err.Error(), 400)
 			return
 		}
+		maxBytes, err := getOptionalUint(args, "max_bytes")
+		if err != nil {
+			ctx.Error("max_bytes: "+err.Error(), 400)
+			return
+		}
 		persist := args.GetBool("persist")
 		tmc = tablemanager.NewConfig(
 			int64(timeoutMs), int64(maxRows), persist,
 		)
-		if err := tmc.Validate(); err != nil {
-			ctx.Error(err.Error(), 400)
+		tmc.MaxBytes = int64(maxBytes)
+		waitTimeoutMs, err := getOptionalUint(args, "wait_timeout_ms")
+		if err != nil {
+			ctx.Error("wait_timeout_ms: "+err.Error(), 400)
 			return
 		}
+		if waitTimeoutMs > 0 {
+			waitTimeout = time.Duration(waitTimeoutMs) * time.Millisecond
+		}
 	}
+	tmc.Inserters = parseInserters(string(args.Peek("inserters")))
 
-	rowsData := ctx.PostBody()
+	var receiptID string
+	statusCode := appendErrorStatusCode
+	if wait {
+		waitCtx, cancel := r.makeWaitContext(traceCtx, waitTimeout)
+		defer cancel()
+		receiptID, err = r.tMHolder.AppendRowsAndWait(waitCtx, &ts, tmc, rows)
+		if errors.Is(err, tablemanager.ErrWaitTimeout) && atomic.LoadInt32(&r.shuttingDown) == 1 {
+			err = ErrStoppedWaiting
+		}
+		statusCode = waitErrorStatusCode
+	} else {
+		receiptID, err = r.tMHolder.AppendRowsWithReceipt(traceCtx, &ts, tmc, sync, rows)
+	}
+	if receiptID != "" {
+		ctx.Response.Header.Set(receiptHeader, receiptID)
+	}
+	if err != nil {
+		r.logger.Debug("rows rejected", "table", t, "bytes", rows.Size(), "status", statusCode(err), "error", err)
+		ctx.Error(err.Error(), statusCode(err))
+		if errors.Is(err, tablemanager.ErrBufferFull) || errors.Is(err, tablemanager.ErrBufferFullTimeout) {
+			ctx.Response.Header.Set("Retry-After", bufferFullRetryAfter)
+		}
+	}
+}
 
-	if err := r.tMHolder.Append(&ts, tmc, sync, rowsData); err != nil {
-		ctx.Error(err.Error(), 400)
+//startSpan starts server span of POST request, it's a child of span
+//of traceparent header if it's valid, a root otherwise
+func (r *HTTPReceiver) startSpan(ctx *fasthttp.RequestCtx) (context.Context, trace.Span) {
+	traceCtx := context.Background()
+	if header := ctx.Request.Header.Peek(traceparentHeader); len(header) != 0 {
+		traceCtx = tracing.ContextWithTraceparent(traceCtx, string(header))
+		if !trace.SpanContextFromContext(traceCtx).IsValid() {
+			r.logger.Debug("ignoring invalid traceparent", "traceparent", string(header))
+		}
+	}
+	name := "POST /"
+	if string(ctx.Path()) == "/batch" {
+		name = "POST /batch"
 	}
+	traceCtx, span := tracing.Start(traceCtx, name, trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttributes(attribute.String("receiver", r.name), attribute.Int("http.request.body.size", len(ctx.PostBody())))
+
+	return traceCtx, span
+}
+
+//endSpan records response code, 5xx codes are errors of server spans
+func endSpan(ctx *fasthttp.RequestCtx, span trace.Span) {
+	code := ctx.Response.StatusCode()
+	span.SetAttributes(attribute.Int("http.response.status_code", code))
+	if code >= fasthttp.StatusInternalServerError {
+		tracing.SetError(span, errors.New(string(ctx.Response.Body())))
+	}
+	span.End()
+}
+
+//appendErrorStatusCode returns 429 if rows were rejected due to full buffer,
+//503 if buffer wasn't freed in time, 400 otherwise
+func appendErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, tablemanager.ErrBufferFull):
+		return fasthttp.StatusTooManyRequests
+	case errors.Is(err, tablemanager.ErrBufferFullTimeout):
+		return fasthttp.StatusServiceUnavailable
+	default:
+		return fasthttp.StatusBadRequest
+	}
+}
+
+//makeWaitContext returns context of wait=1 request derived from parent,
+//it's done after timeout or when receiver is stopped
+func (r *HTTPReceiver) makeWaitContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	go func() {
+		select {
+		case <-r.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+//waitErrorStatusCode returns 502 if inserters failed to insert awaited rows,
+//504 if they weren't inserted before wait timeout, 503 if receiver
+//was stopped meanwhile, the same as appendErrorStatusCode otherwise
+func waitErrorStatusCode(err error) int {
+	var insertErr tablemanager.InsertError
+	switch {
+	case errors.As(err, &insertErr):
+		return fasthttp.StatusBadGateway
+	case errors.Is(err, ErrStoppedWaiting):
+		return fasthttp.StatusServiceUnavailable
+	case errors.Is(err, tablemanager.ErrWaitTimeout):
+		return fasthttp.StatusGatewayTimeout
+	default:
+		return appendErrorStatusCode(err)
+	}
+}
+
+//decompressErrorStatusCode returns 413 if decompressed body is too large,
+//415 for unknown encoding, 400 otherwise
+func decompressErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrDecompressedTooLarge):
+		return fasthttp.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnknownContentEncoding):
+		return fasthttp.StatusUnsupportedMediaType
+	default:
+		return fasthttp.StatusBadRequest
+	}
+}
+
+//getOptionalUint returns 0 if there is no such argument
+func getOptionalUint(args *fasthttp.Args, key string) (int, error) {
+	if !args.Has(key) {
+		return 0, nil
+	}
+
+	return args.GetUint(key)
 }
 
-//Stop wait's for request to be processed, stops listening,
+//parseInserters splits comma separated inserters' names
+func parseInserters(inserters string) []string {
+	var names []string
+	for _, name := range strings.Split(inserters, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+//handleReceipt responds with JSON of receipt by id, 404 if there is no such
+//receipt or it's expired
+func (r *HTTPReceiver) handleReceipt(ctx *fasthttp.RequestCtx, id string) {
+	receipt, ok := r.tMHolder.GetReceipt(id)
+	if !ok {
+		ctx.Error("receipt not found", fasthttp.StatusNotFound)
+		return
+	}
+	data, err := jsoniter.Marshal(receipt)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+//handleReady responds 503 if receiver is shutting down, an inserter's
+//ping failed or too many bytes are buffered
+func (r *HTTPReceiver) handleReady(ctx *fasthttp.RequestCtx) {
+	if err := r.checkReady(); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+		return
+	}
+	ctx.SetBodyString("ok")
+}
+
+func (r *HTTPReceiver) checkReady() error {
+	if atomic.LoadInt32(&r.shuttingDown) == 1 {
+		return errors.New("shutting down")
+	}
+	if r.readyMaxBufferedBytes > 0 {
+		buffered := r.tMHolder.GetBufferedBytes()
+		if buffered > r.readyMaxBufferedBytes {
+			return fmt.Errorf(
+				"buffered %d bytes, limit is %d", buffered, r.readyMaxBufferedBytes,
+			)
+		}
+	}
+	pingCtx, cancel := context.WithTimeout(context.Background(), readyPingTimeout)
+	defer cancel()
+
+	return r.tMHolder.Ping(pingCtx)
+}
+
+//Stop marks receiver as not ready, stops waiting of wait=1 requests,
+//wait's for request to be processed, stops listening,
 //should close idle connetions (but this doesn't work yet)
 func (r *HTTPReceiver) Stop() (err error) {
+	if atomic.CompareAndSwapInt32(&r.shuttingDown, 0, 1) {
+		close(r.stopChan)
+	}
 	timer := time.NewTimer(maxShutdownTime)
 	shutdownErr := make(chan error)
 	go func() {
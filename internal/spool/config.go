@@ -0,0 +1,10 @@
+package spool
+
+//Config is a config for spool of batches that inserters failed to insert.
+//0 MaxBytes and MaxAgeSeconds mean no limit
+type Config struct {
+	Dir             string `toml:"dir"`
+	MaxBytes        int64  `toml:"max_bytes"`
+	MaxAgeSeconds   int64  `toml:"max_age_seconds"`
+	RetryIntervalMs int64  `toml:"retry_interval_ms"`
+}
@@ -0,0 +1,572 @@
+package spool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
+	"github.com/edwvee/dbatcher/internal/table"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+const (
+	batchExt = ".json"
+	tmpExt   = ".tmp"
+	//badExt is for batches that can't be read, they are kept for investigation
+	badExt = ".bad"
+	//keyFile is in queue's directory, it holds queue's key. Directory is
+	//named by hash of the key, cause key of a wide table exceeds name length limit
+	keyFile = "queue.key"
+)
+
+const defaultRetryInterval = 5 * time.Second
+
+//Drop reasons (values of reason label of metrics.SpoolDropped)
+const (
+	DropReasonFull    = "full"
+	DropReasonMaxAge  = "max_age"
+	DropReasonInvalid = "invalid"
+)
+
+var (
+	//ErrSpoolFull means batch doesn't fit max_bytes of spool
+	ErrSpoolFull = errors.New("spool: max_bytes is reached")
+	//ErrMaxAge means batch was in spool longer than max_age_seconds
+	ErrMaxAge = errors.New("spool: max_age_seconds is exceeded")
+	//ErrNoSuchInserter means batch's inserter isn't configured anymore
+	ErrNoSuchInserter = errors.New("spool: no such inserter")
+)
+
+//Spool is a directory with a queue of failed batches for every inserter
+//and table pair. Batches of a queue are retried in order: the next one
+//is inserted only after the previous one.
+type Spool struct {
+	dir               string
+	maxBytes          int64
+	maxAge            time.Duration
+	retryInterval     time.Duration
+	insertErrorLogger *inserter.InsertErrorLogger
+	logger            *logging.Logger
+	//onDone is called when a batch pushed with ID is inserted or dropped
+	onDone func(batchID, inserterName string, err error)
+
+	mut       sync.Mutex
+	inserters map[string]inserter.Inserter
+	queues    map[string]*queue
+	bytes     int64
+	batches   int
+	lastSeq   uint64
+
+	//retryMut makes retries one at a time, so a batch is inserted once
+	retryMut sync.Mutex
+	//stopped is set to 1 by Stop, so retries are finished early
+	stopped     int32
+	stopChannel chan struct{}
+}
+
+type queue struct {
+	key         string
+	inserter    string
+	table       string
+	fields      string
+	dir         string
+	batches     []batch
+	bytes       int64
+	lastError   string
+	lastAttempt time.Time
+}
+
+type batch struct {
+	path    string
+	size    int64
+	created time.Time
+	//id is given to Push, it isn't kept on disk, so batches
+	//left from the previous run don't have it
+	id string
+}
+
+//Status is spool's state for admin endpoint
+type Status struct {
+	Dir      string        `json:"dir"`
+	Bytes    int64         `json:"bytes"`
+	MaxBytes int64         `json:"max_bytes"`
+	Batches  int           `json:"batches"`
+	Queues   []QueueStatus `json:"queues"`
+}
+
+//QueueStatus is state of an inserter and table pair's queue
+type QueueStatus struct {
+	Inserter             string `json:"inserter"`
+	Table                string `json:"table"`
+	Fields               string `json:"fields"`
+	Batches              int    `json:"batches"`
+	Bytes                int64  `json:"bytes"`
+	OldestTimestamp      int64  `json:"oldest_timestamp"`
+	LastError            string `json:"last_error,omitempty"`
+	LastAttemptTimestamp int64  `json:"last_attempt_timestamp,omitempty"`
+}
+
+//Open creates directory if needed and returns Spool on it with queues
+//left from the previous run. Batches that can't be spooled or are
+//too old are written to insertErrorLogger
+func Open(config Config, inserters map[string]inserter.Inserter, insertErrorLogger *inserter.InsertErrorLogger) (*Spool, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "spool: open")
+	}
+	retryInterval := time.Duration(config.RetryIntervalMs) * time.Millisecond
+	if retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+	s := &Spool{
+		dir:               config.Dir,
+		maxBytes:          config.MaxBytes,
+		maxAge:            time.Duration(config.MaxAgeSeconds) * time.Second,
+		retryInterval:     retryInterval,
+		insertErrorLogger: insertErrorLogger,
+		logger:            logging.With("component", "spool"),
+		inserters:         inserters,
+		queues:            map[string]*queue{},
+		stopChannel:       make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.updateMetrics()
+
+	return s, nil
+}
+
+//load reads queues from spool's directory
+func (s *Spool) load() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return errors.Wrap(err, "spool: open")
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, info.Name())
+		key, err := ioutil.ReadFile(filepath.Join(dir, keyFile))
+		if err != nil {
+			s.logger.Warn("skipping unknown directory", "dir", info.Name(), "error", err)
+			continue
+		}
+		q := newQueue(string(key), dir)
+		if err := s.loadQueue(q); err != nil {
+			return err
+		}
+		if len(q.batches) != 0 {
+			s.queues[q.key] = q
+		}
+	}
+
+	return nil
+}
+
+func (s *Spool) loadQueue(q *queue) error {
+	//ReadDir returns names sorted, they are zero padded sequence numbers
+	infos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return errors.Wrap(err, "spool: open")
+	}
+	for _, info := range infos {
+		path := filepath.Join(q.dir, info.Name())
+		switch filepath.Ext(info.Name()) {
+		case tmpExt:
+			//batch wasn't written completely
+			os.Remove(path)
+		case batchExt:
+			seq, _ := strconv.ParseUint(strings.TrimSuffix(info.Name(), batchExt), 10, 64)
+			if seq > s.lastSeq {
+				s.lastSeq = seq
+			}
+			q.push(batch{path: path, size: info.Size(), created: info.ModTime()})
+			s.bytes += info.Size()
+			s.batches++
+		}
+	}
+
+	return nil
+}
+
+//newQueue makes queue from key made by queueKey
+func newQueue(key, dir string) *queue {
+	parts := strings.SplitN(key, "|", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	return &queue{key: key, inserter: parts[0], table: parts[1], fields: parts[2], dir: dir}
+}
+
+func queueKey(inserterName string, ts table.Signature) string {
+	return inserterName + "|" + ts.GetKey()
+}
+
+//queueDirName returns name of queue's directory, it's the same for the same key
+func queueDirName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+//makeQueueDir creates queue's directory with key file
+func makeQueueDir(q *queue) error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return errors.Wrap(err, "spool: make queue dir")
+	}
+	if err := writeFileSync(filepath.Join(q.dir, keyFile), []byte(q.key)); err != nil {
+		return errors.Wrap(err, "spool: make queue dir")
+	}
+
+	return nil
+}
+
+func (q *queue) push(b batch) {
+	q.batches = append(q.batches, b)
+	q.bytes += b.size
+}
+
+func (q *queue) pop() batch {
+	b := q.batches[0]
+	q.batches = q.batches[1:]
+	q.bytes -= b.size
+
+	return b
+}
+
+//SetInserters makes spool retry batches with inserters. Thread safe
+func (s *Spool) SetInserters(inserters map[string]inserter.Inserter) {
+	s.mut.Lock()
+	s.inserters = inserters
+	s.mut.Unlock()
+}
+
+//HasPending reports if inserter has spooled batches of the table.
+//New batches should be pushed after them to keep the order. Thread safe
+func (s *Spool) HasPending(inserterName string, ts table.Signature) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	q, ok := s.queues[queueKey(inserterName, ts)]
+
+	return ok && len(q.batches) != 0
+}
+
+//SetOnDone makes spool call f with batch ID given to Push when the batch
+//is inserted (err is nil) or dropped. Should be called before Run
+func (s *Spool) SetOnDone(f func(batchID, inserterName string, err error)) {
+	s.onDone = f
+}
+
+//Push writes table's rows to the end of inserter's queue of the table,
+//batchID (could be empty) is passed to func of SetOnDone.
+//Returns ErrSpoolFull if they don't fit max_bytes. Thread safe
+func (s *Spool) Push(inserterName string, insertErr error, t *table.Table, batchID string) error {
+	record := s.insertErrorLogger.MakeData(insertErr, []string{inserterName}, t)
+	data, err := jsoniter.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "spool: push")
+	}
+	size := int64(len(data))
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.maxBytes > 0 && s.bytes+size > s.maxBytes {
+		metrics.SpoolDropped.WithLabelValues(DropReasonFull).Inc()
+		return ErrSpoolFull
+	}
+	key := queueKey(inserterName, t.Signature)
+	q, ok := s.queues[key]
+	if !ok {
+		q = newQueue(key, filepath.Join(s.dir, queueDirName(key)))
+		if err := makeQueueDir(q); err != nil {
+			return err
+		}
+		s.queues[key] = q
+	}
+	s.lastSeq++
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d%s", s.lastSeq, batchExt))
+	if err := writeFileSync(path, data); err != nil {
+		if len(q.batches) == 0 {
+			s.removeQueue(key, q)
+		}
+		return err
+	}
+	q.push(batch{path: path, size: size, created: time.Now(), id: batchID})
+	s.bytes += size
+	s.batches++
+	s.updateMetrics()
+
+	return nil
+}
+
+//writeFileSync writes data to a temporary file, syncs it and renames it to path,
+//so there are no partially written batches
+func writeFileSync(path string, data []byte) error {
+	tmpPath := path + tmpExt
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "spool: write batch")
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "spool: write batch")
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "spool: sync dir")
+	}
+	defer d.Close()
+
+	return errors.Wrap(d.Sync(), "spool: sync dir")
+}
+
+//Run starts goroutine which retries spooled batches every retry interval
+func (s *Spool) Run() {
+	go s.run()
+}
+
+func (s *Spool) run() {
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Retry()
+		case <-s.stopChannel:
+			s.stopChannel <- struct{}{}
+			return
+		}
+	}
+}
+
+//Retry tries to insert batches of every queue in order
+//until one of them fails
+func (s *Spool) Retry() {
+	s.retryMut.Lock()
+	defer s.retryMut.Unlock()
+	for _, key := range s.getQueueKeys() {
+		if atomic.LoadInt32(&s.stopped) == 1 {
+			return
+		}
+		s.retryQueue(key)
+	}
+}
+
+func (s *Spool) getQueueKeys() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	keys := make([]string, 0, len(s.queues))
+	for key := range s.queues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *Spool) retryQueue(key string) {
+	for atomic.LoadInt32(&s.stopped) == 0 {
+		s.mut.Lock()
+		q, ok := s.queues[key]
+		if !ok || len(q.batches) == 0 {
+			s.mut.Unlock()
+			return
+		}
+		b := q.batches[0]
+		ins, ok := s.inserters[q.inserter]
+		s.mut.Unlock()
+
+		record, err := readBatch(b.path)
+		if err != nil {
+			s.logger.Error(
+				"can't read batch, keeping it as bad",
+				"table", q.table, "inserter", q.inserter, "path", b.path+badExt, "error", err,
+			)
+			os.Rename(b.path, b.path+badExt)
+			metrics.SpoolDropped.WithLabelValues(DropReasonInvalid).Inc()
+			s.removeHead(key, q)
+			s.done(b, q.inserter, err)
+			continue
+		}
+		if s.maxAge > 0 && time.Since(b.created) > s.maxAge {
+			s.logDropped(record, ErrMaxAge)
+			metrics.SpoolDropped.WithLabelValues(DropReasonMaxAge).Inc()
+			os.Remove(b.path)
+			s.removeHead(key, q)
+			s.done(b, q.inserter, ErrMaxAge)
+			continue
+		}
+		err = ErrNoSuchInserter
+		if ok {
+			err = insertRecord(ins, record)
+		}
+
+		s.mut.Lock()
+		q.lastAttempt = time.Now()
+		if err != nil {
+			q.lastError = err.Error()
+			s.mut.Unlock()
+			s.logger.Debug("retry failed", "table", q.table, "inserter", q.inserter, "error", err)
+			return
+		}
+		q.lastError = ""
+		s.mut.Unlock()
+		os.Remove(b.path)
+		s.removeHead(key, q)
+		s.logger.Info("inserted", "table", q.table, "inserter", q.inserter, "rows", len(record.Rows))
+		s.done(b, q.inserter, nil)
+	}
+}
+
+//done calls func of SetOnDone for batch with ID
+func (s *Spool) done(b batch, inserterName string, err error) {
+	if s.onDone != nil && b.id != "" {
+		s.onDone(b.id, inserterName, err)
+	}
+}
+
+func readBatch(path string) (inserter.InsertErrorLogRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return inserter.InsertErrorLogRecord{}, errors.Wrap(err, "spool: read batch")
+	}
+	defer f.Close()
+	record, err := inserter.NewInsertErrorLogReader(f).Read()
+	if err != nil {
+		return record, errors.Wrapf(err, "spool: read batch %s", path)
+	}
+
+	return record, nil
+}
+
+func insertRecord(ins inserter.Inserter, record inserter.InsertErrorLogRecord) error {
+	t, err := record.MakeTable()
+	if err != nil {
+		return err
+	}
+	defer t.Free()
+
+	return ins.Insert(context.Background(), t)
+}
+
+//logDropped writes record to insert error log, so it could be replayed
+func (s *Spool) logDropped(record inserter.InsertErrorLogRecord, reason error) {
+	s.logger.Warn(
+		"moving batch to insert error log",
+		"table", record.Table, "inserters", record.Inserters, "rows", len(record.Rows), "reason", reason,
+	)
+	record.Error = fmt.Sprintf("%s; before: %s", reason, record.Error)
+	if err := s.insertErrorLogger.LogRecord(record); err != nil {
+		s.logger.Error("failed to write error log", "table", record.Table, "error", err)
+	}
+}
+
+//removeHead removes the first batch of queue from accounting,
+//and the queue itself if it's empty
+func (s *Spool) removeHead(key string, q *queue) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	b := q.pop()
+	s.bytes -= b.size
+	s.batches--
+	if len(q.batches) == 0 {
+		s.removeQueue(key, q)
+	}
+	s.updateMetrics()
+}
+
+//removeQueue must be called under mut
+func (s *Spool) removeQueue(key string, q *queue) {
+	delete(s.queues, key)
+	os.Remove(filepath.Join(q.dir, keyFile))
+	if err := os.Remove(q.dir); err != nil {
+		s.logger.Warn("can't remove queue directory", "dir", q.dir, "error", err)
+	}
+}
+
+//updateMetrics must be called under mut
+func (s *Spool) updateMetrics() {
+	metrics.SpoolBytes.Set(float64(s.bytes))
+	metrics.SpoolBatches.Set(float64(s.batches))
+}
+
+//Status returns spool's size and it's queues sorted by inserter and table.
+//Thread safe
+func (s *Spool) Status() Status {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	status := Status{
+		Dir:      s.dir,
+		Bytes:    s.bytes,
+		MaxBytes: s.maxBytes,
+		Batches:  s.batches,
+		Queues:   make([]QueueStatus, 0, len(s.queues)),
+	}
+	for _, q := range s.queues {
+		queueStatus := QueueStatus{
+			Inserter:  q.inserter,
+			Table:     q.table,
+			Fields:    q.fields,
+			Batches:   len(q.batches),
+			Bytes:     q.bytes,
+			LastError: q.lastError,
+		}
+		if len(q.batches) != 0 {
+			queueStatus.OldestTimestamp = q.batches[0].created.Unix()
+		}
+		if !q.lastAttempt.IsZero() {
+			queueStatus.LastAttemptTimestamp = q.lastAttempt.Unix()
+		}
+		status.Queues = append(status.Queues, queueStatus)
+	}
+	sort.Slice(status.Queues, func(i, j int) bool {
+		a, b := status.Queues[i], status.Queues[j]
+		if a.Inserter != b.Inserter {
+			return a.Inserter < b.Inserter
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Fields < b.Fields
+	})
+
+	return status
+}
+
+//Stop stops retrying started by Run, waits for the current retry to finish.
+//Batches still could be pushed after that
+func (s *Spool) Stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.stopChannel <- struct{}{}
+	<-s.stopChannel
+}
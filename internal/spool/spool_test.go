@@ -0,0 +1,283 @@
+package spool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/table"
+)
+
+var testTableSignature = table.NewSignature("db.`table`", "field1,field2")
+
+var errTestDown = errors.New("database is down")
+
+type switchInserter struct {
+	mut  sync.Mutex
+	err  error
+	rows [][]interface{}
+}
+
+func (si *switchInserter) Init(c inserter.Config) error {
+	return nil
+}
+
+func (si *switchInserter) Insert(ctx context.Context, t *table.Table) error {
+	si.mut.Lock()
+	defer si.mut.Unlock()
+	if si.err != nil {
+		return si.err
+	}
+	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
+		//table's memory is reused after Free
+		si.rows = append(si.rows, append([]interface{}{}, row...))
+	}
+
+	return nil
+}
+
+func (si *switchInserter) setErr(err error) {
+	si.mut.Lock()
+	si.err = err
+	si.mut.Unlock()
+}
+
+func (si *switchInserter) getRows() [][]interface{} {
+	si.mut.Lock()
+	defer si.mut.Unlock()
+
+	return si.rows
+}
+
+func makeTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dbatcher-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func makeTestTable(t *testing.T, rowsJSON string) *table.Table {
+	tbl := table.NewTable(testTableSignature)
+	if err := tbl.AppendRows([]byte(rowsJSON)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tbl.Free)
+
+	return tbl
+}
+
+func TestSpoolRetriesInOrder(t *testing.T) {
+	dir := makeTestDir(t)
+	ins := &switchInserter{err: errTestDown}
+	inserters := map[string]inserter.Inserter{"first": ins}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	s, err := Open(Config{Dir: dir}, inserters, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.HasPending("first", testTableSignature) {
+		t.Error("new spool shouldn't have pending batches")
+	}
+	for _, rowsJSON := range []string{`[[1,"a"]]`, `[[2,"b"],[3,"c"]]`} {
+		if err := s.Push("first", errTestDown, makeTestTable(t, rowsJSON), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.HasPending("first", testTableSignature) {
+		t.Error("should have pending batches")
+	}
+	if s.HasPending("second", testTableSignature) {
+		t.Error("other inserter shouldn't have pending batches")
+	}
+	s.Retry()
+	status := s.Status()
+	if status.Batches != 2 || len(status.Queues) != 1 {
+		t.Fatalf("should be 2 batches in 1 queue, got %+v", status)
+	}
+	queue := status.Queues[0]
+	if queue.Inserter != "first" || queue.Table != "db.`table`" || queue.Fields != "field1,field2" {
+		t.Errorf("wrong queue: %+v", queue)
+	}
+	if queue.LastError != errTestDown.Error() || queue.LastAttemptTimestamp == 0 {
+		t.Errorf("queue should have last error and attempt: %+v", queue)
+	}
+
+	//batches survive restart
+	s, err = Open(Config{Dir: dir}, inserters, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := s.Status(); status.Batches != 2 || status.Bytes != queue.Bytes {
+		t.Fatalf("reopened spool should have the same batches, got %+v", status)
+	}
+	ins.setErr(nil)
+	s.Retry()
+	rows := ins.getRows()
+	if len(rows) != 3 {
+		t.Fatalf("3 rows should be inserted, got %v", rows)
+	}
+	for i, row := range rows {
+		if fmt.Sprint(row[0]) != fmt.Sprint(i+1) {
+			t.Errorf("rows should be inserted in order, got %v", rows)
+		}
+	}
+	if status := s.Status(); status.Batches != 0 || status.Bytes != 0 || len(status.Queues) != 0 {
+		t.Errorf("spool should be empty, got %+v", status)
+	}
+	if s.HasPending("first", testTableSignature) {
+		t.Error("shouldn't have pending batches after retry")
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	s, err := Open(Config{Dir: makeTestDir(t), MaxBytes: 300}, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push("first", errTestDown, makeTestTable(t, `[[1,"a"]]`), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push("first", errTestDown, makeTestTable(t, `[[2,"b"]]`), ""); err != ErrSpoolFull {
+		t.Errorf("should be %s, got %v", ErrSpoolFull, err)
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	var logOutput bytes.Buffer
+	logger := inserter.NewInsertErrorLogger(&logOutput, false)
+	ins := &switchInserter{}
+	s, err := Open(Config{Dir: makeTestDir(t), MaxAgeSeconds: 1}, map[string]inserter.Inserter{"first": ins}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push("first", errTestDown, makeTestTable(t, `[[1,"a"]]`), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.mut.Lock()
+	for _, q := range s.queues {
+		q.batches[0].created = time.Now().Add(-time.Minute)
+	}
+	s.mut.Unlock()
+	s.Retry()
+	if rows := ins.getRows(); len(rows) != 0 {
+		t.Errorf("expired batch shouldn't be inserted, got %v", rows)
+	}
+	if status := s.Status(); status.Batches != 0 {
+		t.Errorf("expired batch should be removed, got %+v", status)
+	}
+	if !strings.Contains(logOutput.String(), ErrMaxAge.Error()) {
+		t.Errorf("expired batch should be in insert error log, got %q", logOutput.String())
+	}
+}
+
+func TestSpoolOnDone(t *testing.T) {
+	ins := &switchInserter{err: errTestDown}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	s, err := Open(Config{Dir: makeTestDir(t), MaxAgeSeconds: 1}, map[string]inserter.Inserter{"first": ins}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := map[string]error{}
+	s.SetOnDone(func(batchID, inserterName string, err error) {
+		if inserterName != "first" {
+			t.Errorf("wrong inserter: %s", inserterName)
+		}
+		done[batchID] = err
+	})
+	for _, batchID := range []string{"expired", "", "inserted"} {
+		if err := s.Push("first", errTestDown, makeTestTable(t, `[[1,"a"]]`), batchID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mut.Lock()
+	for _, q := range s.queues {
+		q.batches[0].created = time.Now().Add(-time.Minute)
+	}
+	s.mut.Unlock()
+	s.Retry()
+	if len(done) != 1 || done["expired"] != ErrMaxAge {
+		t.Fatalf("only expired batch should be done, got %v", done)
+	}
+	ins.setErr(nil)
+	s.Retry()
+	if err, ok := done["inserted"]; len(done) != 2 || !ok || err != nil {
+		t.Errorf("inserted batch should be done without error, got %v", done)
+	}
+}
+
+func TestSpoolRunAndStop(t *testing.T) {
+	ins := &switchInserter{}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	s, err := Open(Config{Dir: makeTestDir(t), RetryIntervalMs: 10}, map[string]inserter.Inserter{"first": ins}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Run()
+	if err := s.Push("first", errTestDown, makeTestTable(t, `[[1,"a"]]`), ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+	if rows := ins.getRows(); len(rows) != 1 {
+		t.Errorf("batch should be retried by Run, got %v", rows)
+	}
+}
+
+func TestSpoolWideTable(t *testing.T) {
+	dir := makeTestDir(t)
+	ins := &switchInserter{err: errTestDown}
+	inserters := map[string]inserter.Inserter{"first": ins}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	s, err := Open(Config{Dir: dir}, inserters, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//key of the table is longer than file name length limit
+	fields := make([]string, 30)
+	row := make([]string, 30)
+	for i := range fields {
+		fields[i] = fmt.Sprintf("quite_long_field_name_%d", i)
+		row[i] = strconv.Itoa(i)
+	}
+	ts := table.NewSignature("db.wide_table", strings.Join(fields, ","))
+	tbl := table.NewTable(ts)
+	defer tbl.Free()
+	if err := tbl.AppendRows([]byte("[[" + strings.Join(row, ",") + "]]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push("first", errTestDown, tbl, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(Config{Dir: dir}, inserters, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasPending("first", ts) {
+		t.Fatal("reopened spool should have batch of the wide table")
+	}
+	if queue := s.Status().Queues[0]; queue.Table != "db.wide_table" || queue.Fields != tbl.GetFields() {
+		t.Errorf("wrong queue: %+v", queue)
+	}
+	ins.setErr(nil)
+	s.Retry()
+	if rows := ins.getRows(); len(rows) != 1 || len(rows[0]) != 30 {
+		t.Errorf("row of 30 fields should be inserted, got %v", rows)
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+		t.Errorf("queue directory should be removed, got %d entries", len(infos))
+	}
+}
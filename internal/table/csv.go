@@ -0,0 +1,278 @@
+package table
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+var (
+	//ErrCSVUnterminatedQuote means quoted value doesn't have closing quote
+	ErrCSVUnterminatedQuote = errors.New("quoted value isn't closed")
+	//ErrCSVAfterQuote means there is something except delimiter or line break after closing quote
+	ErrCSVAfterQuote = errors.New("extraneous characters after closing quote")
+	//ErrCSVWrongValuesCount means row's values count doesn't match fields count
+	ErrCSVWrongValuesCount = errors.New("wrong values count")
+	//ErrCSVNoFields means there is neither fields nor header
+	ErrCSVNoFields = errors.New("fields or header are required")
+	//ErrCSVNoHeaderField means a field isn't in the header
+	ErrCSVNoHeaderField = errors.New("field isn't in header")
+	//ErrInvalidCSVHeader means header's name can't be a field: empty or has comma, backtick or space
+	ErrInvalidCSVHeader = errors.New("header name can't be a field: empty or has comma, backtick or space")
+)
+
+//CSVOptions are options of CSV and TSV parsing
+type CSVOptions struct {
+	//Delimiter separates values
+	Delimiter rune
+	//Quote encloses values with delimiters, quotes or line breaks,
+	//quotes are doubled inside them. 0 means values aren't quoted
+	Quote rune
+	//Null is unquoted value that means null. Empty means empty unquoted values are null
+	Null string
+	//Escapes makes \t, \n, \r and \\ of unquoted values decoded (as in TSV)
+	Escapes bool
+	//Header means the first row has fields' names
+	Header bool
+}
+
+//DefaultCSVOptions returns options of comma separated values
+//with double quotes and \N null
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{Delimiter: ',', Quote: '"', Null: `\N`}
+}
+
+//DefaultTSVOptions returns options of tab separated values
+//without quotes, with escapes and \N null
+func DefaultTSVOptions() CSVOptions {
+	return CSVOptions{Delimiter: '\t', Null: `\N`, Escapes: true}
+}
+
+type csvValue struct {
+	value string
+	null  bool
+}
+
+type csvReader struct {
+	data []byte
+	pos  int
+	line int
+	//recordLine is the line where the last read record starts
+	recordLine int
+	options    CSVOptions
+}
+
+//CSVToRows converts CSV or TSV to JSON array of arrays of strings (and nulls)
+//accepted by AppendRows. If options.Header is set, the first row
+//has fields' names: it is used as fields if they are empty,
+//otherwise values are placed in order of fields.
+//Returns fields and rows JSON
+func CSVToRows(data []byte, fields string, options CSVOptions) (string, []byte, error) {
+	r := &csvReader{data: data, line: 1, options: options}
+	var indexes []int
+	valuesCount := 0
+	if options.Header {
+		header, err := r.readRecord(nil)
+		if err == io.EOF {
+			return "", nil, ErrCSVNoFields
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if fields, indexes, err = mapCSVHeader(header, fields); err != nil {
+			return "", nil, err
+		}
+		valuesCount = len(header)
+	}
+	if fields == "" {
+		return "", nil, ErrCSVNoFields
+	}
+	if indexes == nil {
+		valuesCount = strings.Count(fields, ",") + 1
+		indexes = make([]int, valuesCount)
+		for i := range indexes {
+			indexes[i] = i
+		}
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(data)/2)
+	stream := jsoniter.NewStream(jsoniter.ConfigDefault, &out, 4096)
+	stream.WriteArrayStart()
+	var values []csvValue
+	for rowNum := 0; ; rowNum++ {
+		var err error
+		values, err = r.readRecord(values)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if len(values) != valuesCount {
+			return "", nil, errors.Wrapf(
+				ErrCSVWrongValuesCount, "table: csv: line %d: need %d, got %d",
+				r.recordLine, valuesCount, len(values),
+			)
+		}
+		if rowNum > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteArrayStart()
+		for i, index := range indexes {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			if values[index].null {
+				stream.WriteNil()
+			} else {
+				stream.WriteString(values[index].value)
+			}
+		}
+		stream.WriteArrayEnd()
+	}
+	stream.WriteArrayEnd()
+	if err := stream.Flush(); err != nil {
+		return "", nil, errors.Wrap(err, "table: csv")
+	}
+
+	return fields, out.Bytes(), nil
+}
+
+//mapCSVHeader returns fields and indexes of their values in rows.
+//If fields are empty, they are header's names
+func mapCSVHeader(header []csvValue, fields string) (string, []int, error) {
+	names := make(map[string]int, len(header))
+	for i, name := range header {
+		names[name.value] = i
+	}
+	if fields == "" {
+		var parts []string
+		for _, name := range header {
+			if !isValidFieldName(name.value) {
+				return "", nil, errors.Wrapf(ErrInvalidCSVHeader, "%q", name.value)
+			}
+			parts = append(parts, name.value)
+		}
+		fields = strings.Join(parts, ",")
+	}
+	fields = strings.Replace(fields, " ", "", -1)
+	parts := strings.Split(strings.Replace(fields, "`", "", -1), ",")
+	indexes := make([]int, len(parts))
+	for i, part := range parts {
+		index, ok := names[part]
+		if !ok {
+			return "", nil, errors.Wrapf(ErrCSVNoHeaderField, "%q", part)
+		}
+		indexes[i] = index
+	}
+
+	return fields, indexes, nil
+}
+
+//readRecord appends the next record's values to values[:0], blank lines
+//are skipped. Returns io.EOF if there are no more records
+func (r *csvReader) readRecord(values []csvValue) ([]csvValue, error) {
+	values = values[:0]
+	for r.pos < len(r.data) && (r.data[r.pos] == '\n' || r.data[r.pos] == '\r') {
+		if r.data[r.pos] == '\n' {
+			r.line++
+		}
+		r.pos++
+	}
+	if r.pos >= len(r.data) {
+		return nil, io.EOF
+	}
+	r.recordLine = r.line
+	for {
+		value, err := r.readValue()
+		if err != nil {
+			return nil, errors.Wrapf(err, "table: csv: line %d", r.line)
+		}
+		values = append(values, value)
+		if r.pos >= len(r.data) {
+			return values, nil
+		}
+		c, size := utf8.DecodeRune(r.data[r.pos:])
+		r.pos += size
+		switch c {
+		case r.options.Delimiter:
+			continue
+		case '\r':
+			if r.pos < len(r.data) && r.data[r.pos] == '\n' {
+				r.pos++
+			}
+		}
+		r.line++
+		return values, nil
+	}
+}
+
+func (r *csvReader) readValue() (csvValue, error) {
+	if r.options.Quote != 0 && r.pos < len(r.data) {
+		c, size := utf8.DecodeRune(r.data[r.pos:])
+		if c == r.options.Quote {
+			r.pos += size
+			return r.readQuotedValue()
+		}
+	}
+	start := r.pos
+	for r.pos < len(r.data) {
+		c, size := utf8.DecodeRune(r.data[r.pos:])
+		if c == r.options.Delimiter || c == '\n' || c == '\r' {
+			break
+		}
+		r.pos += size
+	}
+	raw := string(r.data[start:r.pos])
+	if raw == r.options.Null {
+		return csvValue{null: true}, nil
+	}
+	if r.options.Escapes {
+		raw = unescapeTSV(raw)
+	}
+
+	return csvValue{value: raw}, nil
+}
+
+func (r *csvReader) readQuotedValue() (csvValue, error) {
+	var b strings.Builder
+	for {
+		end := bytes.IndexRune(r.data[r.pos:], r.options.Quote)
+		if end < 0 {
+			return csvValue{}, ErrCSVUnterminatedQuote
+		}
+		chunk := r.data[r.pos : r.pos+end]
+		r.line += bytes.Count(chunk, []byte{'\n'})
+		b.Write(chunk)
+		r.pos += end + utf8.RuneLen(r.options.Quote)
+		if r.pos < len(r.data) {
+			c, size := utf8.DecodeRune(r.data[r.pos:])
+			if c == r.options.Quote {
+				//doubled quote
+				b.WriteRune(c)
+				r.pos += size
+				continue
+			}
+			if c != r.options.Delimiter && c != '\n' && c != '\r' {
+				return csvValue{}, ErrCSVAfterQuote
+			}
+		}
+
+		return csvValue{value: b.String()}, nil
+	}
+}
+
+var tsvUnescaper = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`)
+
+func unescapeTSV(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	return tsvUnescaper.Replace(value)
+}
@@ -0,0 +1,90 @@
+package table
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCSVToRows(t *testing.T) {
+	header := DefaultCSVOptions()
+	header.Header = true
+	semicolon := DefaultCSVOptions()
+	semicolon.Delimiter = ';'
+	semicolon.Quote = '\''
+	semicolon.Null = ""
+	cases := []struct {
+		data           string
+		fields         string
+		options        CSVOptions
+		expectedFields string
+		expectedRows   string
+	}{
+		{
+			"1,foo,\\N\n2,\"b,a\"\"r\"\"\",\"multi\nline\"\r\n\n",
+			"id,name,comment", DefaultCSVOptions(),
+			"id,name,comment", `[["1","foo",null],["2","b,a\"r\"","multi\nline"]]`,
+		},
+		{
+			"id,name\n1,foo\n2,bar",
+			"", header,
+			"id,name", `[["1","foo"],["2","bar"]]`,
+		},
+		{
+			"id,name\n1,foo\n2,bar",
+			"name, `id`", header,
+			"name,`id`", `[["foo","1"],["bar","2"]]`,
+		},
+		{
+			"1;'a;b';\n2;'';x",
+			"id,name,comment", semicolon,
+			"id,name,comment", `[["1","a;b",null],["2","","x"]]`,
+		},
+		{
+			"1\ta\\tb\t\\N\n2\t\"q\"\tback\\\\slash\n",
+			"id,name,comment", DefaultTSVOptions(),
+			"id,name,comment", `[["1","a\tb",null],["2","\"q\"","back\\slash"]]`,
+		},
+		{
+			"", "id", DefaultCSVOptions(),
+			"id", `[]`,
+		},
+	}
+	for _, c := range cases {
+		fields, rows, err := CSVToRows([]byte(c.data), c.fields, c.options)
+		if err != nil {
+			t.Errorf("%q: %s", c.data, err)
+			continue
+		}
+		if fields != c.expectedFields {
+			t.Errorf("%q: expected fields %q, got %q", c.data, c.expectedFields, fields)
+		}
+		if string(rows) != c.expectedRows {
+			t.Errorf("%q: expected rows %s, got %s", c.data, c.expectedRows, rows)
+		}
+	}
+}
+
+func TestCSVToRowsNegative(t *testing.T) {
+	header := DefaultCSVOptions()
+	header.Header = true
+	cases := []struct {
+		data     string
+		fields   string
+		options  CSVOptions
+		expected error
+	}{
+		{"1,2\n3", "a,b", DefaultCSVOptions(), ErrCSVWrongValuesCount},
+		{"1,\"2", "a,b", DefaultCSVOptions(), ErrCSVUnterminatedQuote},
+		{"1,\"2\"3", "a,b", DefaultCSVOptions(), ErrCSVAfterQuote},
+		{"1,2", "", DefaultCSVOptions(), ErrCSVNoFields},
+		{"", "", header, ErrCSVNoFields},
+		{"a,b\n1,2", "a,c", header, ErrCSVNoHeaderField},
+		{"a,b c\n1,2", "", header, ErrInvalidCSVHeader},
+	}
+	for _, c := range cases {
+		_, _, err := CSVToRows([]byte(c.data), c.fields, c.options)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%q: should be %s, got %v", c.data, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package table
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+//maxMsgpackDepth limits nesting of arrays and maps
+const maxMsgpackDepth = 1000
+
+var (
+	//ErrMsgpackUnexpectedEnd means data ends in the middle of a value
+	ErrMsgpackUnexpectedEnd = errors.New("unexpected end of data")
+	//ErrMsgpackUnsupportedType means a value is an extension or has unknown type
+	ErrMsgpackUnsupportedType = errors.New("unsupported type")
+	//ErrMsgpackNotRows means data isn't an array of arrays
+	ErrMsgpackNotRows = errors.New("should be array of arrays")
+	//ErrMsgpackMapKey means a map key isn't a string
+	ErrMsgpackMapKey = errors.New("map key should be a string")
+	//ErrMsgpackTooDeep means arrays and maps are nested too deep
+	ErrMsgpackTooDeep = errors.New("nested too deep")
+	//ErrMsgpackTrailingData means there is something after rows
+	ErrMsgpackTrailingData = errors.New("data after rows")
+)
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+//DecodeMsgpackRows decodes MessagePack array of arrays to rows
+//accepted by AppendValues: integers and floats become json.Number,
+//str and bin become string, maps become map[string]interface{}
+func DecodeMsgpackRows(data []byte) ([][]interface{}, error) {
+	d := &msgpackDecoder{data: data}
+	rowsLen, ok, err := d.readArrayLen()
+	if err != nil {
+		return nil, errors.Wrap(err, "table: msgpack")
+	}
+	if !ok {
+		return nil, errors.Wrap(ErrMsgpackNotRows, "table: msgpack")
+	}
+	rows := make([][]interface{}, rowsLen)
+	for i := range rows {
+		rowLen, ok, err := d.readArrayLen()
+		if err != nil {
+			return nil, errors.Wrapf(err, "table: msgpack: row %d", i)
+		}
+		if !ok {
+			return nil, errors.Wrapf(ErrMsgpackNotRows, "table: msgpack: row %d", i)
+		}
+		row := make([]interface{}, rowLen)
+		for j := range row {
+			if row[j], err = d.readValue(1); err != nil {
+				return nil, errors.Wrapf(err, "table: msgpack: row %d", i)
+			}
+		}
+		rows[i] = row
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.Wrap(ErrMsgpackTrailingData, "table: msgpack")
+	}
+
+	return rows, nil
+}
+
+//readArrayLen reads array header, ok is false if the next value isn't an array
+func (d *msgpackDecoder) readArrayLen() (int, bool, error) {
+	if d.pos >= len(d.data) {
+		return 0, false, ErrMsgpackUnexpectedEnd
+	}
+	b := d.data[d.pos]
+	var n uint64
+	switch {
+	case b >= 0x90 && b <= 0x9f:
+		d.pos++
+		n = uint64(b & 0x0f)
+	case b == 0xdc || b == 0xdd:
+		d.pos++
+		var err error
+		if n, err = d.readUint(2 << (b - 0xdc)); err != nil {
+			return 0, false, err
+		}
+	default:
+		return 0, false, nil
+	}
+	//every element takes at least a byte, so bigger length is a broken data
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, false, ErrMsgpackUnexpectedEnd
+	}
+
+	return int(n), true, nil
+}
+
+func (d *msgpackDecoder) readValue(depth int) (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, ErrMsgpackUnexpectedEnd
+	}
+	b := d.data[d.pos]
+	switch {
+	case b <= 0x7f:
+		d.pos++
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		d.pos++
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b >= 0xa0 && b <= 0xbf:
+		d.pos++
+		return d.readString(uint64(b & 0x1f))
+	case b >= 0x90 && b <= 0x9f, b == 0xdc, b == 0xdd:
+		return d.readArray(depth)
+	case b >= 0x80 && b <= 0x8f:
+		d.pos++
+		return d.readMap(uint64(b&0x0f), depth)
+	}
+
+	d.pos++
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xca:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return formatMsgpackFloat(float64(math.Float32frombits(uint32(n))), 32)
+	case 0xcb:
+		n, err := d.readUint(8)
+		if err != nil {
+			return nil, err
+		}
+		return formatMsgpackFloat(math.Float64frombits(n), 64)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		//sign extension of size bytes integer
+		shift := uint(64 - size*8)
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), nil
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.readMap(n, depth)
+	}
+
+	return nil, errors.Wrapf(ErrMsgpackUnsupportedType, "0x%x", b)
+}
+
+func (d *msgpackDecoder) readArray(depth int) (interface{}, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, ErrMsgpackTooDeep
+	}
+	n, _, err := d.readArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		if array[i], err = d.readValue(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return array, nil
+}
+
+func (d *msgpackDecoder) readMap(n uint64, depth int) (interface{}, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, ErrMsgpackTooDeep
+	}
+	//every key and value take at least a byte
+	if n > uint64(len(d.data)-d.pos)/2 {
+		return nil, ErrMsgpackUnexpectedEnd
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := d.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, ErrMsgpackMapKey
+		}
+		if m[keyString], err = d.readValue(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+//formatMsgpackFloat returns float as json.Number
+func formatMsgpackFloat(f float64, bitSize int) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, ErrNotFiniteNumber
+	}
+
+	return json.Number(strconv.FormatFloat(f, 'f', -1, bitSize)), nil
+}
+
+func (d *msgpackDecoder) readString(n uint64) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrMsgpackUnexpectedEnd
+	}
+	s := string(d.data[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+
+	return s, nil
+}
+
+//readUint reads big endian unsigned integer of size bytes
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	if size > len(d.data)-d.pos {
+		return 0, ErrMsgpackUnexpectedEnd
+	}
+	b := d.data[d.pos : d.pos+size]
+	d.pos += size
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
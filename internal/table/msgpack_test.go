@@ -0,0 +1,132 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMsgpackRows(t *testing.T) {
+	data := []byte{
+		0x92, //array of 2 rows
+		0x96, //row of 6 values
+		0x01, 0xff, 0xa2, 'a', 'b', 0xc0, 0xc3, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0x96,
+		0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xd2, 0xff, 0xff, 0xfe, 0xd4,
+		0xc4, 0x01, 'c',
+		0x91, 0x02,
+		0x81, 0xa1, 'k', 0xc2,
+		0xca, 0x3f, 0x00, 0x00, 0x00,
+	}
+	rows, err := DecodeMsgpackRows(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{json.Number("1"), json.Number("-1"), "ab", nil, true, json.Number("1.5")},
+		{
+			json.Number("18446744073709551615"), json.Number("-300"), "c",
+			[]interface{}{json.Number("2")}, map[string]interface{}{"k": false}, json.Number("0.5"),
+		},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+
+	tbl := NewTable(NewSignature("table", "f1,f2,f3,f4,f5,f6"))
+	defer tbl.Free()
+	if err := tbl.Append(NewValueRows(rows, len(data))); err != nil {
+		t.Fatalf("decoded rows should be appended: %s", err)
+	}
+	if tbl.GetRowsLen() != 2 {
+		t.Errorf("should be 2 rows, got %d", tbl.GetRowsLen())
+	}
+}
+
+func TestDecodeMsgpackRowsNegative(t *testing.T) {
+	cases := []struct {
+		data     []byte
+		expected error
+	}{
+		{[]byte{}, ErrMsgpackUnexpectedEnd},
+		{[]byte{0x01}, ErrMsgpackNotRows},
+		{[]byte{0x91, 0x01}, ErrMsgpackNotRows},
+		{[]byte{0x91, 0x91}, ErrMsgpackUnexpectedEnd},
+		{[]byte{0x91, 0x91, 0xa2, 'a'}, ErrMsgpackUnexpectedEnd},
+		{[]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, ErrMsgpackUnexpectedEnd},
+		{[]byte{0x91, 0x91, 0xd4, 0x01, 0x01}, ErrMsgpackUnsupportedType},
+		{[]byte{0x91, 0x91, 0x81, 0x01, 0x01}, ErrMsgpackMapKey},
+		{[]byte{0x91, 0x91, 0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1}, ErrNotFiniteNumber},
+		{[]byte{0x91, 0x90, 0x90}, ErrMsgpackTrailingData},
+	}
+	for _, c := range cases {
+		if _, err := DecodeMsgpackRows(c.data); !errors.Is(err, c.expected) {
+			t.Errorf("% x: expected %s, got %v", c.data, c.expected, err)
+		}
+	}
+
+	deep := []byte{0x91, 0x91}
+	for i := 0; i < maxMsgpackDepth; i++ {
+		deep = append(deep, 0x91)
+	}
+	deep = append(deep, 0xc0)
+	if _, err := DecodeMsgpackRows(deep); !errors.Is(err, ErrMsgpackTooDeep) {
+		t.Errorf("expected %s, got %v", ErrMsgpackTooDeep, err)
+	}
+}
+
+func getBenchmarkRows() [][]interface{} {
+	rows := make([][]interface{}, 100)
+	for i := range rows {
+		rows[i] = []interface{}{
+			"2021-11-13 10:53:10.123",
+			"htp://site.example/path0/path1/path2?param0=value0&param1=value1&param3=value3",
+			json.Number("666666"),
+			json.Number("0.5"),
+		}
+	}
+
+	return rows
+}
+
+func BenchmarkAppendRowsJSON(b *testing.B) {
+	rowsJSON, err := json.Marshal(getBenchmarkRows())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tbl := NewTable(NewSignature("table", "f1,f2,f3,f4"))
+		if err := tbl.AppendRows(rowsJSON); err != nil {
+			b.Fatal(err)
+		}
+		tbl.Free()
+	}
+}
+
+func BenchmarkAppendRowsMsgpack(b *testing.B) {
+	//str8 of short strings, uint32 and float64 as the go client encodes them
+	row := []byte{0x94, 0xd9, 23}
+	row = append(row, "2021-11-13 10:53:10.123"...)
+	row = append(row, 0xd9, 78)
+	row = append(row, "htp://site.example/path0/path1/path2?param0=value0&param1=value1&param3=value3"...)
+	row = append(row, 0xce, 0x00, 0x0a, 0x2c, 0x2a, 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0)
+	data := []byte{0xdc, 0, 100}
+	for i := 0; i < 100; i++ {
+		data = append(data, row...)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tbl := NewTable(NewSignature("table", "f1,f2,f3,f4"))
+		rows, err := DecodeMsgpackRows(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := tbl.AppendValues(rows); err != nil {
+			b.Fatal(err)
+		}
+		tbl.Free()
+	}
+}
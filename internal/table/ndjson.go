@@ -0,0 +1,225 @@
+package table
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+//What to do with a row that doesn't have a key of fields
+const (
+	//MissingNull inserts null
+	MissingNull = "null"
+	//MissingDefault inserts zero value of key's JSON type in the batch
+	//(0, "", false, [] or {}), null if the type is unknown
+	MissingDefault = "default"
+	//MissingReject rejects the whole batch
+	MissingReject = "reject"
+)
+
+var (
+	//ErrInvalidMissing means unknown missing keys behavior
+	ErrInvalidMissing = errors.New("missing should be null, default or reject")
+	//ErrMissingKey means a row doesn't have a key and missing is reject
+	ErrMissingKey = errors.New("row doesn't have a key")
+	//ErrNDJSONRowNotObject means a row of NDJSON isn't a JSON object
+	ErrNDJSONRowNotObject = errors.New("row should be a JSON object")
+	//ErrInvalidNDJSONKey means a key can't be used as a field without fields parameter
+	ErrInvalidNDJSONKey = errors.New("key can't be a field: empty or has comma, backtick or space")
+)
+
+//ndjsonConfig decodes numbers to json.Number as AppendRows does
+var ndjsonConfig = jsoniter.Config{UseNumber: true}.Froze()
+
+//ndjsonMissingValue marks a value of a key that the row doesn't have
+type ndjsonMissingValue struct{}
+
+//DecodeNDJSONRows decodes NDJSON (a JSON object per line, JSONEachRow)
+//to rows accepted by AppendValues. Values are placed in order of fields,
+//keys that aren't in fields are ignored. If fields are empty, they are
+//the sorted union of keys of all rows, keys are collected while decoding.
+//Rows are decoded one by one in a single pass. Returns fields and rows
+func DecodeNDJSONRows(ndjson []byte, fields string, missing string) (string, [][]interface{}, error) {
+	if missing == "" {
+		missing = MissingNull
+	}
+	if missing != MissingNull && missing != MissingDefault && missing != MissingReject {
+		return "", nil, ErrInvalidMissing
+	}
+
+	var keys []string
+	fromKeys := fields == ""
+	if !fromKeys {
+		fields = strings.Replace(fields, " ", "", -1)
+		keys = strings.Split(strings.Replace(fields, "`", "", -1), ",")
+	}
+	keyIndexes := makeNDJSONKeyIndexes(keys)
+	//types are types of the first not null values of keys
+	types := make([]jsoniter.ValueType, len(keys))
+	rows := [][]interface{}{}
+	iter := jsoniter.ParseBytes(ndjsonConfig, ndjson)
+	for rowNum := 1; ; rowNum++ {
+		ok, err := nextNDJSONRow(iter, rowNum)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			break
+		}
+
+		values := make([]interface{}, len(keys))
+		for i := range values {
+			values[i] = ndjsonMissingValue{}
+		}
+		var invalidKey string
+		iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+			i, ok := keyIndexes[key]
+			if !ok {
+				if !fromKeys {
+					iter.Skip()
+					return true
+				}
+				if !isValidFieldName(key) {
+					invalidKey = key
+					return false
+				}
+				//rows before don't have the new key
+				i = len(keys)
+				keys = append(keys, key)
+				keyIndexes[key] = i
+				types = append(types, jsoniter.InvalidValue)
+				values = append(values, ndjsonMissingValue{})
+			}
+			values[i] = iter.Read()
+			if types[i] == jsoniter.InvalidValue || types[i] == jsoniter.NilValue {
+				types[i] = valueType(values[i])
+			}
+			return true
+		})
+		if invalidKey != "" {
+			return "", nil, errors.Wrapf(ErrInvalidNDJSONKey, "table: ndjson: row %d: %q", rowNum, invalidKey)
+		}
+		if iter.Error != nil {
+			return "", nil, errors.Wrapf(iter.Error, "table: ndjson: row %d", rowNum)
+		}
+		rows = append(rows, values)
+	}
+
+	//order has indexes of values in order of fields
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	if fromKeys {
+		sort.Slice(order, func(i, j int) bool {
+			return keys[order[i]] < keys[order[j]]
+		})
+		sortedKeys := make([]string, len(keys))
+		for i, index := range order {
+			sortedKeys[i] = keys[index]
+		}
+		fields = strings.Join(sortedKeys, ",")
+	}
+	//missing values are known only after all rows
+	for rowIndex, values := range rows {
+		row := make([]interface{}, len(order))
+		for i, index := range order {
+			if index < len(values) {
+				if _, isMissing := values[index].(ndjsonMissingValue); !isMissing {
+					row[i] = values[index]
+					continue
+				}
+			}
+			switch missing {
+			case MissingReject:
+				return "", nil, errors.Wrapf(ErrMissingKey, "table: ndjson: row %d: %q", rowIndex+1, keys[index])
+			case MissingDefault:
+				row[i] = zeroValue(types[index])
+			}
+		}
+		rows[rowIndex] = row
+	}
+
+	return fields, rows, nil
+}
+
+func makeNDJSONKeyIndexes(keys []string) map[string]int {
+	keyIndexes := make(map[string]int, len(keys))
+	for i, key := range keys {
+		keyIndexes[key] = i
+	}
+
+	return keyIndexes
+}
+
+//nextNDJSONRow reports if there is one more row, it should be an object
+func nextNDJSONRow(iter *jsoniter.Iterator, rowNum int) (bool, error) {
+	valueType := iter.WhatIsNext()
+	if iter.Error == io.EOF {
+		return false, nil
+	}
+	if iter.Error != nil {
+		return false, errors.Wrapf(iter.Error, "table: ndjson: row %d", rowNum)
+	}
+	if valueType != jsoniter.ObjectValue {
+		return false, errors.Wrapf(ErrNDJSONRowNotObject, "table: ndjson: row %d", rowNum)
+	}
+
+	return true, nil
+}
+
+//valueType returns JSON type of a decoded value
+func valueType(value interface{}) jsoniter.ValueType {
+	switch value.(type) {
+	case nil:
+		return jsoniter.NilValue
+	case json.Number:
+		return jsoniter.NumberValue
+	case string:
+		return jsoniter.StringValue
+	case bool:
+		return jsoniter.BoolValue
+	case []interface{}:
+		return jsoniter.ArrayValue
+	case map[string]interface{}:
+		return jsoniter.ObjectValue
+	}
+
+	return jsoniter.InvalidValue
+}
+
+//zeroValue returns zero value of JSON type, nil if the type is unknown
+func zeroValue(valueType jsoniter.ValueType) interface{} {
+	switch valueType {
+	case jsoniter.NumberValue:
+		return json.Number("0")
+	case jsoniter.StringValue:
+		return ""
+	case jsoniter.BoolValue:
+		return false
+	case jsoniter.ArrayValue:
+		return []interface{}{}
+	case jsoniter.ObjectValue:
+		return map[string]interface{}{}
+	}
+
+	return nil
+}
+
+func isValidFieldName(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r == ',' || r == '`' || unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,83 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestDecodeNDJSONRows(t *testing.T) {
+	ndjson := `{"id":1,"name":"a","tags":["x"]}
+{"name":"b","id":2,"extra":null}
+
+{"id":3}
+`
+	cases := []struct {
+		fields         string
+		missing        string
+		expectedFields string
+		expectedRows   string
+	}{
+		{"", "", "extra,id,name,tags", `[[null,1,"a",["x"]],[null,2,"b",null],[null,3,null,null]]`},
+		{"", MissingDefault, "extra,id,name,tags", `[[null,1,"a",["x"]],[null,2,"b",[]],[null,3,"",[]]]`},
+		{"name, `id`", MissingNull, "name,`id`", `[["a",1],["b",2],[null,3]]`},
+		{"id", MissingReject, "id", `[[1],[2],[3]]`},
+	}
+	for _, c := range cases {
+		fields, rows, err := DecodeNDJSONRows([]byte(ndjson), c.fields, c.missing)
+		if err != nil {
+			t.Errorf("fields %q, missing %q: %s", c.fields, c.missing, err)
+			continue
+		}
+		if fields != c.expectedFields {
+			t.Errorf("fields %q: expected fields %q, got %q", c.fields, c.expectedFields, fields)
+		}
+		rowsJSON, err := jsoniter.Marshal(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rowsJSON) != c.expectedRows {
+			t.Errorf("fields %q, missing %q: expected rows %s, got %s", c.fields, c.missing, c.expectedRows, rowsJSON)
+		}
+	}
+
+	tbl := NewTable(NewSignature("table", "extra,id,name,tags"))
+	defer tbl.Free()
+	_, rows, _ := DecodeNDJSONRows([]byte(ndjson), "", "")
+	if err := tbl.AppendValues(rows); err != nil {
+		t.Fatalf("decoded rows should be appended: %s", err)
+	}
+	if tbl.GetRowsLen() != 3 {
+		t.Errorf("should be 3 rows, got %d", tbl.GetRowsLen())
+	}
+	if row := tbl.GetNextRow(); row[1] != json.Number("1") {
+		t.Errorf("numbers should be decoded as json.Number, got %T", row[1])
+	}
+}
+
+func TestDecodeNDJSONRowsNegative(t *testing.T) {
+	cases := []struct {
+		ndjson   string
+		fields   string
+		missing  string
+		expected error
+	}{
+		{`{"id":1}` + "\n" + `{"name":"b"}`, "id", MissingReject, ErrMissingKey},
+		{`{"id":1}`, "", "skip", ErrInvalidMissing},
+		{`{"id":1}` + "\n" + `[1]`, "", "", ErrNDJSONRowNotObject},
+		{`{"my id":1}`, "", "", ErrInvalidNDJSONKey},
+		//the first row doesn't have a key of the second one
+		{`{"id":1}` + "\n" + `{"id":2,"name":"b"}`, "", MissingReject, ErrMissingKey},
+	}
+	for _, c := range cases {
+		_, _, err := DecodeNDJSONRows([]byte(c.ndjson), c.fields, c.missing)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%q: should be %s, got %v", c.ndjson, c.expected, err)
+		}
+	}
+	if _, _, err := DecodeNDJSONRows([]byte(`{"id":1}`+"\n"+`{"id":`), "", ""); err == nil {
+		t.Error("broken JSON should be an error")
+	}
+}
@@ -0,0 +1,137 @@
+package table
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//ErrProtobufWireType means a field of Rows message (see clients/rows.proto)
+//has unexpected wire type
+var ErrProtobufWireType = errors.New("wrong wire type")
+
+//DecodeProtobufRows decodes Rows message of clients/rows.proto
+//to fields and rows accepted by AppendValues: numbers become json.Number,
+//values without a set field become nil. Unknown fields are skipped
+func DecodeProtobufRows(data []byte) (string, [][]interface{}, error) {
+	var fields string
+	var rows [][]interface{}
+	err := consumeProtobufFields(data, func(num protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		switch num {
+		case 1:
+			value, n, err := consumeProtobufBytes(typ, data)
+			fields = string(value)
+			return n, err
+		case 2:
+			value, n, err := consumeProtobufBytes(typ, data)
+			if err != nil || n < 0 {
+				return n, err
+			}
+			row, err := decodeProtobufRow(value)
+			if err != nil {
+				return 0, errors.Wrapf(err, "row %d", len(rows))
+			}
+			rows = append(rows, row)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, data), nil
+	})
+	if err != nil {
+		return "", nil, errors.Wrap(err, "table: protobuf")
+	}
+
+	return fields, rows, nil
+}
+
+func decodeProtobufRow(data []byte) ([]interface{}, error) {
+	row := []interface{}{}
+	err := consumeProtobufFields(data, func(num protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		if num != 1 {
+			return protowire.ConsumeFieldValue(num, typ, data), nil
+		}
+		value, n, err := consumeProtobufBytes(typ, data)
+		if err != nil || n < 0 {
+			return n, err
+		}
+		decoded, err := decodeProtobufValue(value)
+		if err != nil {
+			return 0, errors.Wrapf(err, "value %d", len(row))
+		}
+		row = append(row, decoded)
+		return n, nil
+	})
+
+	return row, err
+}
+
+//decodeProtobufValue decodes Value message, the last set field wins as in oneof
+func decodeProtobufValue(data []byte) (interface{}, error) {
+	var decoded interface{}
+	err := consumeProtobufFields(data, func(num protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		switch {
+		case num == 1:
+			value, n, err := consumeProtobufBytes(typ, data)
+			decoded = string(value)
+			return n, err
+		case num == 4 && typ == protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(data)
+			f := math.Float64frombits(value)
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return 0, ErrNotFiniteNumber
+			}
+			decoded = json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+			return n, nil
+		case (num == 2 || num == 3 || num == 5) && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			switch num {
+			case 2:
+				decoded = json.Number(strconv.FormatInt(protowire.DecodeZigZag(value), 10))
+			case 3:
+				decoded = json.Number(strconv.FormatUint(value, 10))
+			default:
+				decoded = protowire.DecodeBool(value)
+			}
+			return n, nil
+		case num >= 1 && num <= 5:
+			return 0, ErrProtobufWireType
+		}
+		return protowire.ConsumeFieldValue(num, typ, data), nil
+	})
+
+	return decoded, err
+}
+
+//consumeProtobufFields calls consume for every field of message data.
+//consume returns consumed bytes count or negative protowire error code
+func consumeProtobufFields(data []byte, consume func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := consume(num, typ, data)
+		if err != nil {
+			return errors.Wrapf(err, "field %d", num)
+		}
+		if n < 0 {
+			return errors.Wrapf(protowire.ParseError(n), "field %d", num)
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+//consumeProtobufBytes consumes length-delimited value
+func consumeProtobufBytes(typ protowire.Type, data []byte) ([]byte, int, error) {
+	if typ != protowire.BytesType {
+		return nil, 0, ErrProtobufWireType
+	}
+	value, n := protowire.ConsumeBytes(data)
+
+	return value, n, nil
+}
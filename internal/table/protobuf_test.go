@@ -0,0 +1,67 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendTestProtobufRow(b []byte, values ...[]byte) []byte {
+	var row []byte
+	for _, value := range values {
+		row = protowire.AppendTag(row, 1, protowire.BytesType)
+		row = protowire.AppendBytes(row, value)
+	}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+
+	return protowire.AppendBytes(b, row)
+}
+
+func TestDecodeProtobufRows(t *testing.T) {
+	var str, sint, unsigned, double, boolean, unknown []byte
+	str = protowire.AppendString(protowire.AppendTag(str, 1, protowire.BytesType), "a")
+	sint = protowire.AppendVarint(protowire.AppendTag(sint, 2, protowire.VarintType), protowire.EncodeZigZag(-5))
+	unsigned = protowire.AppendVarint(protowire.AppendTag(unsigned, 3, protowire.VarintType), math.MaxUint64)
+	double = protowire.AppendFixed64(protowire.AppendTag(double, 4, protowire.Fixed64Type), math.Float64bits(1.5))
+	boolean = protowire.AppendVarint(protowire.AppendTag(boolean, 5, protowire.VarintType), 1)
+	unknown = protowire.AppendVarint(protowire.AppendTag(unknown, 100, protowire.VarintType), 1)
+
+	var data []byte
+	data = protowire.AppendString(protowire.AppendTag(data, 1, protowire.BytesType), "f1,f2,f3")
+	data = appendTestProtobufRow(data, str, sint, nil)
+	data = appendTestProtobufRow(data, unsigned, double, append(unknown, boolean...))
+	data = append(data, unknown...)
+	fields, rows, err := DecodeProtobufRows(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields != "f1,f2,f3" {
+		t.Errorf("expected fields f1,f2,f3, got %s", fields)
+	}
+	expected := [][]interface{}{
+		{"a", json.Number("-5"), nil},
+		{json.Number("18446744073709551615"), json.Number("1.5"), true},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
+
+func TestDecodeProtobufRowsNegative(t *testing.T) {
+	var wrongType, nan []byte
+	wrongType = protowire.AppendVarint(protowire.AppendTag(wrongType, 1, protowire.VarintType), 1)
+	nan = protowire.AppendFixed64(protowire.AppendTag(nan, 4, protowire.Fixed64Type), math.Float64bits(math.NaN()))
+	if _, _, err := DecodeProtobufRows(appendTestProtobufRow(nil, wrongType)); !errors.Is(err, ErrProtobufWireType) {
+		t.Errorf("expected %s, got %v", ErrProtobufWireType, err)
+	}
+	if _, _, err := DecodeProtobufRows(appendTestProtobufRow(nil, nan)); !errors.Is(err, ErrNotFiniteNumber) {
+		t.Errorf("expected %s, got %v", ErrNotFiniteNumber, err)
+	}
+	if _, _, err := DecodeProtobufRows([]byte{0x12, 0x05, 0x0a}); err == nil {
+		t.Error("truncated message should be an error")
+	}
+}
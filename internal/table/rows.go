@@ -0,0 +1,48 @@
+package table
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+//ErrNotFiniteNumber means a binary format's float is NaN or an infinity,
+//they can't be represented in JSON
+var ErrNotFiniteNumber = errors.New("NaN and infinities aren't supported")
+
+//Rows are rows of a request: JSON array of arrays or rows
+//already decoded from a binary format (see DecodeMsgpackRows
+//and DecodeProtobufRows). Both are appended to a table by Table.Append
+type Rows struct {
+	json    []byte
+	values  [][]interface{}
+	decoded bool
+	size    int64
+}
+
+//NewJSONRows returns rows of JSON array of arrays
+func NewJSONRows(rowsJSON []byte) Rows {
+	return Rows{json: rowsJSON, size: int64(len(rowsJSON))}
+}
+
+//NewValueRows returns decoded rows, size is the size of their encoded data
+func NewValueRows(values [][]interface{}, size int) Rows {
+	return Rows{values: values, decoded: true, size: int64(size)}
+}
+
+//Size returns size of rows' data as received
+func (r Rows) Size() int64 {
+	return r.size
+}
+
+//JSON returns rows as JSON array of arrays, decoded rows are encoded
+func (r Rows) JSON() ([]byte, error) {
+	if !r.decoded {
+		return r.json, nil
+	}
+	rowsJSON, err := jsoniter.Marshal(r.values)
+	if err != nil {
+		return nil, errors.Wrap(err, "table: rows to json")
+	}
+
+	return rowsJSON, nil
+}
@@ -49,18 +49,35 @@ func (t *Table) AppendRows(rowsJSON []byte) error {
 	if err != nil {
 		return errors.Wrap(err, "table: append rows: json parsing:")
 	}
-	for _, el := range target {
+
+	return t.AppendValues(target)
+}
+
+//AppendValues validates already decoded rows and appends
+//them to table's inner data buffer. Values should be
+//the same as AppendRows decodes: json.Number for numbers
+func (t *Table) AppendValues(rows [][]interface{}) error {
+	for _, el := range rows {
 		if len(el) != t.rowLen {
 			return t.wrongLengthErr(el)
 		}
 	}
-	for _, el := range target {
+	for _, el := range rows {
 		t.data = append(t.data, el...)
 	}
 
 	return nil
 }
 
+//Append appends rows with AppendRows or AppendValues
+func (t *Table) Append(rows Rows) error {
+	if rows.decoded {
+		return t.AppendValues(rows.values)
+	}
+
+	return t.AppendRows(rows.json)
+}
+
 //GetRowsLen returns count of table's rows
 func (t Table) GetRowsLen() int {
 	return len(t.data) / t.rowLen
@@ -74,11 +91,6 @@ func (t Table) wrongLengthErr(el []interface{}) error {
 	)
 }
 
-//GetTableName returns table's name
-func (t Table) GetTableName() string {
-	return t.tableName
-}
-
 //GetFields returns table's fields
 func (t Table) GetFields() string {
 	return t.fields
@@ -106,3 +118,11 @@ func (t *Table) GetRawData() []interface{} {
 func (t *Table) Reset() {
 	t.dataPos = 0
 }
+
+//TruncateRows drops rows after the first rowsLen rows.
+//Used to roll back an append
+func (t *Table) TruncateRows(rowsLen int) {
+	if rowsLen < t.GetRowsLen() {
+		t.data = t.data[:rowsLen*t.rowLen]
+	}
+}
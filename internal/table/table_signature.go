@@ -78,6 +78,11 @@ func (ts Signature) validateFields() error {
 	return nil
 }
 
+//GetTableName returns table's name
+func (ts Signature) GetTableName() string {
+	return ts.tableName
+}
+
 //GetKey returns a key from table name and fields to identify table
 func (ts Signature) GetKey() string {
 	return fmt.Sprintf("%s|%s", ts.tableName, ts.fields)
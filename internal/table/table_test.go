@@ -130,3 +130,18 @@ func TestObvious(t *testing.T) {
 		t.Errorf("get fields: want %s, got %s", fields, fieldsFromTbl)
 	}
 }
+
+func TestTruncateRows(t *testing.T) {
+	table := getTestTable()
+	if err := table.AppendRows([]byte(`[[1,2,3],[4,5,6],[7,8,9]]`)); err != nil {
+		t.Fatal(err)
+	}
+	table.TruncateRows(5)
+	if rowsLen := table.GetRowsLen(); rowsLen != 3 {
+		t.Errorf("truncating to more rows shouldn't change table: got %d rows", rowsLen)
+	}
+	table.TruncateRows(1)
+	if rowsLen := table.GetRowsLen(); rowsLen != 1 {
+		t.Errorf("should be 1 row after truncate, got %d", rowsLen)
+	}
+}
@@ -0,0 +1,39 @@
+package tablemanager
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+//batchResult is a result of the insert of a manager's table,
+//it's awaited by requests whose rows joined the table.
+//id is the table's receipt ID
+type batchResult struct {
+	id   string
+	done chan struct{}
+	err  error
+	//firstAppend and links are set by appends under manager's tableMut:
+	//time of the first rows and spans of appends, the insert span is linked to them
+	firstAppend time.Time
+	links       []trace.Link
+}
+
+func newBatchResult() *batchResult {
+	return &batchResult{id: newBatchID(), done: make(chan struct{})}
+}
+
+//getID returns empty ID for nil result
+func (r *batchResult) getID() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.id
+}
+
+//finish sets insert's error and wakes waiters up, must be called once
+func (r *batchResult) finish(err error) {
+	r.err = err
+	close(r.done)
+}
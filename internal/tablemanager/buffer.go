@@ -0,0 +1,136 @@
+package tablemanager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/metrics"
+	"github.com/pkg/errors"
+)
+
+//Modes of BufferConfig.OnFull
+const (
+	OnFullBlock  = "block"
+	OnFullReject = "reject"
+)
+
+const defaultBufferBlockTimeout = time.Second
+
+var (
+	//ErrBufferFull means max buffered bytes is reached and rows are rejected
+	ErrBufferFull = errors.New("max buffered bytes is reached")
+	//ErrBufferFullTimeout means max buffered bytes is reached
+	//and buffer wasn't freed in block timeout
+	ErrBufferFullTimeout = errors.New("max buffered bytes is reached, timed out waiting for inserts")
+	//ErrInvalidBufferConfig means buffer config has negative values or unknown on_full
+	ErrInvalidBufferConfig = errors.New("buffer: values couldn't be negative, on_full should be block or reject")
+)
+
+//BufferConfig limits size of rows JSON buffered or being inserted
+//by all table managers. OnFull is what to do with rows
+//when limit is reached: block until inserts free buffer or
+//until BlockTimeoutMs passed (default), or reject right away.
+//0 MaxBufferedBytes means no limit
+type BufferConfig struct {
+	MaxBufferedBytes int64  `toml:"max_buffered_bytes"`
+	OnFull           string `toml:"on_full"`
+	BlockTimeoutMs   int64  `toml:"block_timeout_ms"`
+}
+
+//Validate checks if config is valid
+func (c BufferConfig) Validate() error {
+	if c.MaxBufferedBytes < 0 || c.BlockTimeoutMs < 0 {
+		return ErrInvalidBufferConfig
+	}
+	if c.OnFull != "" && c.OnFull != OnFullBlock && c.OnFull != OnFullReject {
+		return errors.Wrapf(ErrInvalidBufferConfig, "on_full %q", c.OnFull)
+	}
+
+	return nil
+}
+
+//bufferTracker counts bytes of rows from append until they are inserted
+type bufferTracker struct {
+	mut    sync.Mutex
+	used   int64
+	config BufferConfig
+	//freed is closed and replaced when bytes are released
+	freed chan struct{}
+}
+
+func newBufferTracker() *bufferTracker {
+	return &bufferTracker{freed: make(chan struct{})}
+}
+
+func (bt *bufferTracker) setConfig(config BufferConfig) {
+	bt.mut.Lock()
+	bt.config = config
+	bt.mut.Unlock()
+}
+
+//acquire reserves size bytes. If they don't fit the limit, calls
+//flush with bytes that should be freed and blocks or rejects
+//according to config. Rows that are bigger than the limit
+//are accepted when buffer is empty
+func (bt *bufferTracker) acquire(size int64, flush func(need int64)) error {
+	var timer *time.Timer
+	flushed := false
+	for {
+		bt.mut.Lock()
+		config := bt.config
+		if config.MaxBufferedBytes <= 0 || bt.used == 0 || bt.used+size <= config.MaxBufferedBytes {
+			bt.used += size
+			metrics.BufferedBytes.Set(float64(bt.used))
+			bt.mut.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		}
+		need := bt.used + size - config.MaxBufferedBytes
+		freed := bt.freed
+		bt.mut.Unlock()
+
+		if !flushed {
+			flush(need)
+			flushed = true
+		}
+		if config.OnFull == OnFullReject {
+			metrics.BufferFullErrors.WithLabelValues(OnFullReject).Inc()
+			return ErrBufferFull
+		}
+		if timer == nil {
+			timeout := time.Duration(config.BlockTimeoutMs) * time.Millisecond
+			if timeout <= 0 {
+				timeout = defaultBufferBlockTimeout
+			}
+			timer = time.NewTimer(timeout)
+		}
+		select {
+		case <-freed:
+		case <-timer.C:
+			metrics.BufferFullErrors.WithLabelValues(OnFullBlock).Inc()
+			return ErrBufferFullTimeout
+		}
+	}
+}
+
+//release frees size bytes and wakes up blocked acquires
+func (bt *bufferTracker) release(size int64) {
+	if size == 0 {
+		return
+	}
+	bt.mut.Lock()
+	bt.used -= size
+	metrics.BufferedBytes.Set(float64(bt.used))
+	close(bt.freed)
+	bt.freed = make(chan struct{})
+	bt.mut.Unlock()
+}
+
+func (bt *bufferTracker) getUsed() int64 {
+	bt.mut.Lock()
+	defer bt.mut.Unlock()
+
+	return bt.used
+}
@@ -0,0 +1,148 @@
+package tablemanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/pkg/errors"
+)
+
+func TestBufferConfigValidate(t *testing.T) {
+	valid := []BufferConfig{
+		{},
+		{MaxBufferedBytes: 100, OnFull: OnFullBlock, BlockTimeoutMs: 10},
+		{MaxBufferedBytes: 100, OnFull: OnFullReject},
+	}
+	for _, config := range valid {
+		if err := config.Validate(); err != nil {
+			t.Errorf("%+v should be valid, got %s", config, err)
+		}
+	}
+	invalid := []BufferConfig{
+		{MaxBufferedBytes: -1},
+		{BlockTimeoutMs: -1},
+		{OnFull: "drop"},
+	}
+	for _, config := range invalid {
+		if err := config.Validate(); !errors.Is(err, ErrInvalidBufferConfig) {
+			t.Errorf("%+v should be invalid, got %v", config, err)
+		}
+	}
+}
+
+func TestBufferTrackerReject(t *testing.T) {
+	bt := newBufferTracker()
+	bt.setConfig(BufferConfig{MaxBufferedBytes: 10, OnFull: OnFullReject})
+	var flushedNeed int64
+	flush := func(need int64) { flushedNeed = need }
+	//bigger than limit, but buffer is empty
+	if err := bt.acquire(15, flush); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.acquire(5, flush); err != ErrBufferFull {
+		t.Fatalf("should be %s, got %v", ErrBufferFull, err)
+	}
+	if flushedNeed != 10 {
+		t.Errorf("flush should need 10 bytes, got %d", flushedNeed)
+	}
+	bt.release(15)
+	if err := bt.acquire(5, flush); err != nil {
+		t.Fatal(err)
+	}
+	if got := bt.getUsed(); got != 5 {
+		t.Errorf("used should be 5, got %d", got)
+	}
+}
+
+func TestBufferTrackerBlock(t *testing.T) {
+	bt := newBufferTracker()
+	bt.setConfig(BufferConfig{MaxBufferedBytes: 10, OnFull: OnFullBlock, BlockTimeoutMs: 50})
+	noFlush := func(need int64) {}
+	if err := bt.acquire(10, noFlush); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := bt.acquire(1, noFlush); err != ErrBufferFullTimeout {
+		t.Fatalf("should be %s, got %v", ErrBufferFullTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("should block for block timeout, blocked for %s", elapsed)
+	}
+
+	bt.setConfig(BufferConfig{MaxBufferedBytes: 10, OnFull: OnFullBlock, BlockTimeoutMs: 5000})
+	releaseOnFlush := func(need int64) {
+		go bt.release(need)
+	}
+	if err := bt.acquire(5, releaseOnFlush); err != nil {
+		t.Fatal(err)
+	}
+	if got := bt.getUsed(); got != 10 {
+		t.Errorf("used should be 10, got %d", got)
+	}
+}
+
+func TestHolderBufferBackpressure(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	si := &selfSliceInserter{}
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"slice": si}, logger)
+	defer tmh.StopTableManagers()
+	rowsJSON := []byte("[[1,2,3],[4,5,6]]")
+	err := tmh.SetBufferConfig(BufferConfig{
+		MaxBufferedBytes: int64(2 * len(rowsJSON)),
+		OnFull:           OnFullBlock,
+		BlockTimeoutMs:   5000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//timeout and max rows are far, so only backpressure flushes
+	config := NewConfig(100000000, 100000, false)
+	for i := 0; i < 5; i++ {
+		if err := tmh.Append(&defaultTestTableSignature, config, false, rowsJSON); err != nil {
+			t.Fatal(err)
+		}
+		if got := tmh.GetBufferedBytes(); got > int64(2*len(rowsJSON)) {
+			t.Fatalf("buffered bytes %d are over the limit", got)
+		}
+	}
+	if got := len(si.TakeSlice()); got < 6 {
+		t.Errorf("at least 6 rows should be inserted by backpressure, got %d", got)
+	}
+
+	err = tmh.SetBufferConfig(BufferConfig{MaxBufferedBytes: 1, OnFull: OnFullReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmh.Append(&defaultTestTableSignature, config, false, rowsJSON); err != ErrBufferFull {
+		t.Errorf("should be %s, got %v", ErrBufferFull, err)
+	}
+	if err := tmh.Append(&defaultTestTableSignature, Config{}, true, rowsJSON); err != ErrBufferFull {
+		t.Errorf("sync rows should be limited too, got %v", err)
+	}
+	if err := tmh.SetBufferConfig(BufferConfig{OnFull: "drop"}); err == nil {
+		t.Error("invalid config should be rejected")
+	}
+}
+
+func TestHolderBufferEmptyRows(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
+	defer tmh.StopTableManagers()
+	if err := tmh.SetBufferConfig(BufferConfig{MaxBufferedBytes: 1000, OnFull: OnFullReject}); err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig(100000000, 100000, false)
+	for i := 0; i < 10; i++ {
+		if err := tmh.Append(&defaultTestTableSignature, config, false, []byte("[]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := tmh.GetBufferedBytes(); got != 0 {
+		t.Errorf("empty rows shouldn't hold buffer bytes, got %d", got)
+	}
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, config)
+	if got := tm.GetBufferedBytes(); got != 0 {
+		t.Errorf("manager shouldn't count bytes of empty rows, got %d", got)
+	}
+}
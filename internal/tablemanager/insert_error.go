@@ -0,0 +1,34 @@
+package tablemanager
+
+import (
+	"sort"
+	"strings"
+)
+
+//InsertError holds errors of inserters that failed to insert a table.
+//Inserters that aren't in Errors have inserted the table successfully
+type InsertError struct {
+	Errors map[string]error
+}
+
+//Error joins inserters' errors prefixed with inserters' names
+func (e InsertError) Error() string {
+	names := e.FailedInserters()
+	errMessages := make([]string, len(names))
+	for i, name := range names {
+		errMessages[i] = name + ": " + e.Errors[name].Error()
+	}
+
+	return strings.Join(errMessages, ",")
+}
+
+//FailedInserters returns sorted names of inserters that failed
+func (e InsertError) FailedInserters() []string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,249 @@
+package tablemanager
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Statuses of Receipt and InserterReceipt
+const (
+	ReceiptPending   = "pending"
+	ReceiptCommitted = "committed"
+	ReceiptFailed    = "failed"
+	//ReceiptSpooled is a status of inserter that failed, so the batch
+	//is spooled for it. It becomes committed when spool inserts the batch
+	ReceiptSpooled = "spooled"
+)
+
+const defaultReceiptsRetention = 10 * time.Minute
+
+//ErrInvalidReceiptsConfig means receipts config has negative retention
+var ErrInvalidReceiptsConfig = errors.New("receipts: retention_ms couldn't be negative")
+
+//ReceiptsConfig sets how long receipts are kept after their batch
+//is inserted, 0 or absent means 10 minutes
+type ReceiptsConfig struct {
+	RetentionMs int64 `toml:"retention_ms"`
+}
+
+//Validate checks if config is valid
+func (c ReceiptsConfig) Validate() error {
+	if c.RetentionMs < 0 {
+		return ErrInvalidReceiptsConfig
+	}
+
+	return nil
+}
+
+//Receipt is a state of a batch that accepted rows joined.
+//Status is pending until all inserters are done (including retries
+//of spooled batch), then failed if an inserter failed
+type Receipt struct {
+	ID         string                     `json:"id"`
+	Table      string                     `json:"table"`
+	Status     string                     `json:"status"`
+	Inserters  map[string]InserterReceipt `json:"inserters"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+//InserterReceipt is a state of a batch's insert by an inserter
+type InserterReceipt struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+//batchIDPrefix makes batch IDs unique across restarts
+var batchIDPrefix = strconv.FormatInt(time.Now().UnixNano(), 36) + "-"
+var lastBatchID uint64
+
+func newBatchID() string {
+	return batchIDPrefix + strconv.FormatUint(atomic.AddUint64(&lastBatchID, 1), 36)
+}
+
+//finishedReceipt is an ID of finished receipt with its finish time
+type finishedReceipt struct {
+	id string
+	at time.Time
+}
+
+//pendingBatch is a batch which receipt isn't finished yet
+type pendingBatch struct {
+	result *batchResult
+	//inserted is set after the manager's insert, spool could
+	//insert the batch even before it's set
+	inserted bool
+	errs     map[string]error
+}
+
+//receipts keeps receipts of pending batches and of finished ones
+//for retention, expired ones are removed on access
+type receipts struct {
+	mut       sync.Mutex
+	retention time.Duration
+	byID      map[string]*Receipt
+	pending   map[string]*pendingBatch
+	//finished are in order of finish
+	finished []finishedReceipt
+}
+
+func newReceipts() *receipts {
+	return &receipts{
+		retention: defaultReceiptsRetention,
+		byID:      map[string]*Receipt{},
+		pending:   map[string]*pendingBatch{},
+	}
+}
+
+func (rs *receipts) setConfig(config ReceiptsConfig) {
+	retention := time.Duration(config.RetentionMs) * time.Millisecond
+	if retention == 0 {
+		retention = defaultReceiptsRetention
+	}
+	rs.mut.Lock()
+	rs.retention = retention
+	rs.mut.Unlock()
+}
+
+//add adds pending receipt of batch with result
+func (rs *receipts) add(result *batchResult, tableName string, inserters []string) {
+	receipt := &Receipt{
+		ID:        result.id,
+		Table:     tableName,
+		Status:    ReceiptPending,
+		Inserters: make(map[string]InserterReceipt, len(inserters)),
+		CreatedAt: time.Now(),
+	}
+	for _, name := range inserters {
+		receipt.Inserters[name] = InserterReceipt{Status: ReceiptPending}
+	}
+	rs.mut.Lock()
+	rs.byID[result.id] = receipt
+	rs.pending[result.id] = &pendingBatch{result: result, errs: map[string]error{}}
+	rs.mut.Unlock()
+}
+
+//finish sets statuses of inserters that inserted batch of result:
+//failed for ones in InsertError err, spooled for spooled ones (see
+//finishSpooled), committed for others. Result is finished when receipt is.
+//Result of receipt that wasn't added is finished with err
+func (rs *receipts) finish(result *batchResult, inserters []string, err error, spooled []string) {
+	now := time.Now()
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	rs.expire(now)
+	receipt, ok := rs.byID[result.id]
+	batch, isPending := rs.pending[result.id]
+	if !ok || !isPending {
+		result.finish(err)
+		return
+	}
+	isSpooled := make(map[string]bool, len(spooled))
+	for _, name := range spooled {
+		isSpooled[name] = true
+	}
+	insertErr, isInsertErr := err.(InsertError)
+	for _, name := range inserters {
+		if isSpooled[name] {
+			if receipt.Inserters[name].Status == ReceiptPending {
+				receipt.Inserters[name] = InserterReceipt{Status: ReceiptSpooled}
+			}
+			continue
+		}
+		e := insertErr.Errors[name]
+		if e == nil && !isInsertErr {
+			e = err
+		}
+		receipt.Inserters[name] = makeInserterReceipt(e)
+		if e != nil {
+			batch.errs[name] = e
+		}
+	}
+	batch.inserted = true
+	rs.finishIfDone(receipt, batch, now)
+}
+
+//finishSpooled sets status of inserter that spool inserted batch with id for,
+//or failed if spool dropped it with err
+func (rs *receipts) finishSpooled(id, inserterName string, err error) {
+	now := time.Now()
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	receipt, ok := rs.byID[id]
+	batch, isPending := rs.pending[id]
+	if !ok || !isPending {
+		return
+	}
+	receipt.Inserters[inserterName] = makeInserterReceipt(err)
+	if err != nil {
+		batch.errs[inserterName] = err
+	}
+	rs.finishIfDone(receipt, batch, now)
+}
+
+func makeInserterReceipt(err error) InserterReceipt {
+	if err != nil {
+		return InserterReceipt{Status: ReceiptFailed, Error: err.Error()}
+	}
+
+	return InserterReceipt{Status: ReceiptCommitted}
+}
+
+//finishIfDone finishes receipt and it's batch's result if all inserters
+//are done. Must be called under mut
+func (rs *receipts) finishIfDone(receipt *Receipt, batch *pendingBatch, now time.Time) {
+	if !batch.inserted {
+		return
+	}
+	status := ReceiptCommitted
+	for _, inserterReceipt := range receipt.Inserters {
+		switch inserterReceipt.Status {
+		case ReceiptPending, ReceiptSpooled:
+			return
+		case ReceiptFailed:
+			status = ReceiptFailed
+		}
+	}
+	receipt.Status = status
+	receipt.FinishedAt = &now
+	rs.finished = append(rs.finished, finishedReceipt{id: receipt.ID, at: now})
+	delete(rs.pending, receipt.ID)
+	if len(batch.errs) != 0 {
+		batch.result.finish(InsertError{Errors: batch.errs})
+	} else {
+		batch.result.finish(nil)
+	}
+}
+
+//get returns a copy of receipt by id
+func (rs *receipts) get(id string) (Receipt, bool) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	rs.expire(time.Now())
+	receipt, ok := rs.byID[id]
+	if !ok {
+		return Receipt{}, false
+	}
+	res := *receipt
+	res.Inserters = make(map[string]InserterReceipt, len(receipt.Inserters))
+	for name, status := range receipt.Inserters {
+		res.Inserters[name] = status
+	}
+
+	return res, true
+}
+
+//expire must be called under mut
+func (rs *receipts) expire(now time.Time) {
+	i := 0
+	for ; i < len(rs.finished) && now.Sub(rs.finished[i].at) > rs.retention; i++ {
+		delete(rs.byID, rs.finished[i].id)
+	}
+	if i > 0 {
+		rs.finished = append(rs.finished[:0], rs.finished[i:]...)
+	}
+}
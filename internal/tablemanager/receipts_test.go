@@ -0,0 +1,103 @@
+package tablemanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReceiptsFinish(t *testing.T) {
+	rs := newReceipts()
+	unknown := newBatchResult()
+	rs.finish(unknown, []string{"first"}, nil, nil)
+	if _, ok := rs.get(unknown.id); ok {
+		t.Fatal("receipt that wasn't added shouldn't be finished")
+	}
+	<-unknown.done
+
+	result := newBatchResult()
+	rs.add(result, "db.table", []string{"first", "second"})
+	rs.finish(result, []string{"first", "second"}, InsertError{Errors: map[string]error{"second": errors.New("some error")}}, nil)
+	receipt, ok := rs.get(result.id)
+	if !ok {
+		t.Fatal("should be a receipt")
+	}
+	if receipt.Status != ReceiptFailed {
+		t.Errorf("receipt should be failed, got %s", receipt.Status)
+	}
+	if status := receipt.Inserters["first"]; status != (InserterReceipt{Status: ReceiptCommitted}) {
+		t.Errorf("first should be committed, got %v", status)
+	}
+	if status := receipt.Inserters["second"]; status != (InserterReceipt{Status: ReceiptFailed, Error: "some error"}) {
+		t.Errorf("second should be failed, got %v", status)
+	}
+
+	<-result.done
+	if failed := result.err.(InsertError).FailedInserters(); len(failed) != 1 || failed[0] != "second" {
+		t.Errorf("result should have error of second, got %v", result.err)
+	}
+
+	result = newBatchResult()
+	rs.add(result, "db.table", []string{"first"})
+	rs.finish(result, []string{"first"}, errors.New("not insert error"), nil)
+	if receipt, _ := rs.get(result.id); receipt.Inserters["first"].Status != ReceiptFailed {
+		t.Errorf("all inserters should be failed on not InsertError, got %v", receipt)
+	}
+}
+
+func TestReceiptsFinishSpooled(t *testing.T) {
+	rs := newReceipts()
+	result := newBatchResult()
+	rs.add(result, "db.table", []string{"first", "second", "third"})
+	//spool could insert the batch before the manager finishes it
+	rs.finishSpooled(result.id, "third", nil)
+	spooled := []string{"second", "third"}
+	rs.finish(result, []string{"first", "second", "third"}, nil, spooled)
+	receipt, _ := rs.get(result.id)
+	expected := map[string]InserterReceipt{
+		"first":  {Status: ReceiptCommitted},
+		"second": {Status: ReceiptSpooled},
+		"third":  {Status: ReceiptCommitted},
+	}
+	if receipt.Status != ReceiptPending || !reflect.DeepEqual(receipt.Inserters, expected) {
+		t.Errorf("receipt should be pending till spooled batch is inserted, got %v", receipt)
+	}
+	select {
+	case <-result.done:
+		t.Fatal("result shouldn't be done till spooled batch is inserted")
+	default:
+	}
+
+	rs.finishSpooled(result.id, "second", errors.New("dropped"))
+	receipt, _ = rs.get(result.id)
+	if receipt.Status != ReceiptFailed || receipt.Inserters["second"].Status != ReceiptFailed || receipt.FinishedAt == nil {
+		t.Errorf("receipt should be failed, got %v", receipt)
+	}
+	<-result.done
+	if failed := result.err.(InsertError).FailedInserters(); len(failed) != 1 || failed[0] != "second" {
+		t.Errorf("result should have error of second, got %v", result.err)
+	}
+}
+
+func TestReceiptsExpire(t *testing.T) {
+	rs := newReceipts()
+	rs.setConfig(ReceiptsConfig{RetentionMs: 20})
+	pending, finished := newBatchResult(), newBatchResult()
+	rs.add(pending, "db.table", []string{"first"})
+	rs.add(finished, "db.table", []string{"first"})
+	rs.finish(finished, []string{"first"}, nil, nil)
+	if _, ok := rs.get(finished.id); !ok {
+		t.Fatal("finished receipt should be kept for retention")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := rs.get(finished.id); ok {
+		t.Error("finished receipt should be expired")
+	}
+	if _, ok := rs.get(pending.id); !ok {
+		t.Error("pending receipt shouldn't be expired")
+	}
+	if len(rs.finished) != 0 {
+		t.Errorf("expired receipts should be removed, got %v", rs.finished)
+	}
+}
@@ -0,0 +1,163 @@
+package tablemanager
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/pkg/errors"
+)
+
+var (
+	//ErrNoRoute means routes are configured, but none matches the table
+	ErrNoRoute = errors.New("no route for table")
+	//ErrInserterNotRouted means requested inserter isn't in table's route
+	ErrInserterNotRouted = errors.New("inserter isn't routed for table")
+	//ErrInvalidRoute means route should have exactly one of table,
+	//database, pattern or regex and at least one inserter
+	ErrInvalidRoute = errors.New("route should have one of table, database, pattern, regex and inserters")
+)
+
+//RouteConfig maps tables to inserters. Only one of Table (exact name),
+//Database (tables of the database), Pattern (glob) or Regex should be set.
+//Backticks and double quotes of a table name are ignored while matching
+type RouteConfig struct {
+	Table     string   `toml:"table"`
+	Database  string   `toml:"database"`
+	Pattern   string   `toml:"pattern"`
+	Regex     string   `toml:"regex"`
+	Inserters []string `toml:"inserters"`
+}
+
+type route struct {
+	config RouteConfig
+	regex  *regexp.Regexp
+}
+
+//Router finds inserters for a table by the first matching route.
+//Router without routes sends every table to all inserters
+type Router struct {
+	routes []route
+}
+
+var tableNameQuotesReplacer = strings.NewReplacer("`", "", `"`, "")
+
+//normalizeTableName removes backticks and double quotes from table's name
+func normalizeTableName(tableName string) string {
+	return tableNameQuotesReplacer.Replace(tableName)
+}
+
+//NewRouter validates routes and returns router on them
+func NewRouter(configs []RouteConfig) (*Router, error) {
+	r := &Router{}
+	for i, config := range configs {
+		matchers := 0
+		for _, matcher := range []string{config.Table, config.Database, config.Pattern, config.Regex} {
+			if matcher != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 || len(config.Inserters) == 0 {
+			return nil, errors.Wrapf(ErrInvalidRoute, "route %d", i)
+		}
+		rt := route{config: config}
+		if config.Pattern != "" {
+			if _, err := path.Match(config.Pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "route %d: pattern", i)
+			}
+		}
+		if config.Regex != "" {
+			regex, err := regexp.Compile(config.Regex)
+			if err != nil {
+				return nil, errors.Wrapf(err, "route %d: regex", i)
+			}
+			rt.regex = regex
+		}
+		r.routes = append(r.routes, rt)
+	}
+
+	return r, nil
+}
+
+//CheckInserters checks that routes use only existing inserters
+func (r *Router) CheckInserters(inserters map[string]inserter.Inserter) error {
+	for i, rt := range r.routes {
+		for _, name := range rt.config.Inserters {
+			if _, ok := inserters[name]; !ok {
+				return errors.Errorf("route %d: no such inserter %s", i, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+//Route returns sorted names of inserters for the table from all inserters.
+//If requested isn't empty, returns it if all requested inserters are routed
+func (r *Router) Route(tableName string, requested []string, inserters map[string]inserter.Inserter) ([]string, error) {
+	var routed []string
+	if r == nil || len(r.routes) == 0 {
+		for name := range inserters {
+			routed = append(routed, name)
+		}
+	} else {
+		rt := r.match(tableName)
+		if rt == nil {
+			return nil, errors.Wrap(ErrNoRoute, tableName)
+		}
+		routed = rt.config.Inserters
+	}
+	if len(requested) == 0 {
+		names := append([]string{}, routed...)
+		sort.Strings(names)
+		return names, nil
+	}
+
+	names := []string{}
+	for _, name := range requested {
+		if !containsString(routed, name) {
+			return nil, errors.Wrapf(ErrInserterNotRouted, "%s for %s", name, tableName)
+		}
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (r *Router) match(tableName string) *route {
+	name := normalizeTableName(tableName)
+	for i, rt := range r.routes {
+		config := rt.config
+		var matched bool
+		switch {
+		case config.Table != "":
+			matched = name == normalizeTableName(config.Table)
+		case config.Database != "":
+			matched = strings.HasPrefix(name, normalizeTableName(config.Database)+".")
+		case config.Pattern != "":
+			matched, _ = path.Match(config.Pattern, name)
+		case config.Regex != "":
+			matched = rt.regex.MatchString(name)
+		}
+		if matched {
+			return &r.routes[i]
+		}
+	}
+
+	return nil
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package tablemanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/edwvee/dbatcher/internal/inserter"
+)
+
+var routerTestInserters = map[string]inserter.Inserter{
+	"clickhouse": &inserter.DummyInserter{},
+	"mysql":      &inserter.DummyInserter{},
+	"postgres":   &inserter.DummyInserter{},
+}
+
+func TestNewRouterValidation(t *testing.T) {
+	invalidConfigs := [][]RouteConfig{
+		{{Inserters: []string{"mysql"}}},
+		{{Table: "db.table", Database: "db", Inserters: []string{"mysql"}}},
+		{{Table: "db.table"}},
+		{{Pattern: "[", Inserters: []string{"mysql"}}},
+		{{Regex: "(", Inserters: []string{"mysql"}}},
+	}
+	for _, configs := range invalidConfigs {
+		if _, err := NewRouter(configs); err == nil {
+			t.Errorf("should return error for %+v", configs)
+		}
+	}
+
+	router, err := NewRouter([]RouteConfig{{Table: "db.table", Inserters: []string{"oracle"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.CheckInserters(routerTestInserters); err == nil {
+		t.Error("should return error for unknown inserter")
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	router, err := NewRouter([]RouteConfig{
+		{Table: "analytics.events", Inserters: []string{"clickhouse", "postgres"}},
+		{Database: "ops", Inserters: []string{"mysql"}},
+		{Pattern: "logs.*_2021", Inserters: []string{"clickhouse"}},
+		{Regex: `^metrics\.m\d+$`, Inserters: []string{"postgres"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]string{
+		"analytics.events":     {"clickhouse", "postgres"},
+		"`analytics`.`events`": {"clickhouse", "postgres"},
+		"ops.users":            {"mysql"},
+		"`ops`.users":          {"mysql"},
+		"logs.access_2021":     {"clickhouse"},
+		"metrics.m15":          {"postgres"},
+	}
+	for tableName, expected := range cases {
+		names, err := router.Route(tableName, nil, routerTestInserters)
+		if err != nil {
+			t.Errorf("%s: shouldn't return error: %s", tableName, err)
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected %v, got %v", tableName, expected, names)
+		}
+	}
+	for _, tableName := range []string{"analytics.other", "operations.users", "logs.access_2020", "metrics.mx"} {
+		if _, err := router.Route(tableName, nil, routerTestInserters); !errors.Is(err, ErrNoRoute) {
+			t.Errorf("%s: should return ErrNoRoute, got %v", tableName, err)
+		}
+	}
+
+	names, err := router.Route("analytics.events", []string{"postgres", "postgres"}, routerTestInserters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"postgres"}) {
+		t.Errorf("should return requested inserters, got %v", names)
+	}
+	if _, err := router.Route("analytics.events", []string{"mysql"}, routerTestInserters); !errors.Is(err, ErrInserterNotRouted) {
+		t.Errorf("should return ErrInserterNotRouted, got %v", err)
+	}
+}
+
+func TestRouterWithoutRoutes(t *testing.T) {
+	for _, router := range []*Router{nil, {}} {
+		names, err := router.Route("any.table", nil, routerTestInserters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, []string{"clickhouse", "mysql", "postgres"}) {
+			t.Errorf("should return all inserters, got %v", names)
+		}
+		if _, err := router.Route("any.table", []string{"oracle"}, routerTestInserters); !errors.Is(err, ErrInserterNotRouted) {
+			t.Errorf("should return ErrInserterNotRouted, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package tablemanager
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//ErrTableManagerNotFound means there is no table manager with the key
+var ErrTableManagerNotFound = errors.New("table manager not found")
+
+//TableInfo is a state of a table manager, key is the table's key
+//with names of it's inserters (see ListTables)
+type TableInfo struct {
+	Key             string     `json:"key"`
+	Table           string     `json:"table"`
+	Inserters       []string   `json:"inserters"`
+	BufferedRows    int        `json:"buffered_rows"`
+	BufferedBytes   int64      `json:"buffered_bytes"`
+	TimeoutMs       int64      `json:"timeout_ms"`
+	MaxRows         int64      `json:"max_rows"`
+	MaxBytes        int64      `json:"max_bytes"`
+	Paused          bool       `json:"paused"`
+	LastVisit       time.Time  `json:"last_visit"`
+	LastInsert      *time.Time `json:"last_insert,omitempty"`
+	LastInsertError string     `json:"last_insert_error,omitempty"`
+}
+
+//ListTables returns states of table managers sorted by key
+func (h *Holder) ListTables() []TableInfo {
+	h.managersMut.Lock()
+	managers := make(map[string]*TableManager, len(h.managers))
+	lastVisits := make(map[string]time.Time, len(h.managers))
+	for key, manager := range h.managers {
+		managers[key] = manager
+		lastVisits[key] = h.lastManagerVisit[key]
+	}
+	h.managersMut.Unlock()
+
+	infos := make([]TableInfo, 0, len(managers))
+	for key, manager := range managers {
+		manager.tableMut.Lock()
+		tableName := manager.table.GetTableName()
+		manager.tableMut.Unlock()
+		info := TableInfo{
+			Key:           key,
+			Table:         tableName,
+			Inserters:     manager.getInserterNames(),
+			BufferedRows:  manager.GetBufferedRows(),
+			BufferedBytes: manager.GetBufferedBytes(),
+			TimeoutMs:     atomic.LoadInt64(&manager.timeoutMs),
+			MaxRows:       atomic.LoadInt64(&manager.maxRows),
+			MaxBytes:      atomic.LoadInt64(&manager.maxBytes),
+			Paused:        manager.IsPaused(),
+			LastVisit:     lastVisits[key],
+		}
+		if lastInsert, err := manager.GetLastInsert(); !lastInsert.IsZero() {
+			info.LastInsert = &lastInsert
+			if err != nil {
+				info.LastInsertError = err.Error()
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos
+}
+
+//FlushTable makes table manager by key insert it's rows right away,
+//even if it's paused. Returns insert's error
+func (h *Holder) FlushTable(key string) error {
+	manager, err := h.getTableManagerByKey(key)
+	if err != nil {
+		return err
+	}
+
+	return manager.DoInsert()
+}
+
+//FlushTables does FlushTable for all table managers concurrently.
+//Returns insert errors
+func (h *Holder) FlushTables() []error {
+	h.managersMut.Lock()
+	managers := make([]*TableManager, 0, len(h.managers))
+	for _, manager := range h.managers {
+		managers = append(managers, manager)
+	}
+	h.managersMut.Unlock()
+
+	errs := []error{}
+	errChan := make(chan error)
+	for _, manager := range managers {
+		go func(manager *TableManager) {
+			errChan <- manager.DoInsert()
+		}(manager)
+	}
+	for range managers {
+		if err := <-errChan; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+//PauseTable pauses table manager by key, see TableManager.Pause.
+//Paused managers aren't stopped as unused
+func (h *Holder) PauseTable(key string) error {
+	manager, err := h.getTableManagerByKey(key)
+	if err != nil {
+		return err
+	}
+	manager.Pause()
+
+	return nil
+}
+
+//ResumeTable resumes table manager by key, see TableManager.Resume
+func (h *Holder) ResumeTable(key string) error {
+	manager, err := h.getTableManagerByKey(key)
+	if err != nil {
+		return err
+	}
+	manager.Resume()
+
+	return nil
+}
+
+//StopTable stops table manager by key with timeout, it's rows are
+//inserted. Next rows of the table start a new manager
+func (h *Holder) StopTable(key string) error {
+	h.managersMut.Lock()
+	manager, ok := h.managers[key]
+	if ok {
+		h.forgetManagerLocked(key)
+	}
+	h.managersMut.Unlock()
+	if !ok {
+		return errors.Wrap(ErrTableManagerNotFound, key)
+	}
+	if errs := stopManagers(map[string]*TableManager{key: manager}); len(errs) != 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (h *Holder) getTableManagerByKey(key string) (*TableManager, error) {
+	h.managersMut.Lock()
+	manager, ok := h.managers[key]
+	h.managersMut.Unlock()
+	if !ok {
+		return nil, errors.Wrap(ErrTableManagerNotFound, key)
+	}
+
+	return manager, nil
+}
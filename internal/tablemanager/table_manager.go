@@ -1,76 +1,265 @@
 package tablemanager
 
 import (
+	"context"
 	"errors"
-	"log"
-	"strings"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
+	"github.com/edwvee/dbatcher/internal/spool"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/edwvee/dbatcher/internal/wal"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	//ErrTableManagerStopped means rows were appended to a stopped manager
+	ErrTableManagerStopped = errors.New("table manager is stopped")
+	//ErrQueuedAfterSpooled means batch wasn't inserted, cause inserter
+	//has older spooled batches of the table
+	ErrQueuedAfterSpooled = errors.New("queued after spooled batches")
 )
 
 //TableManager is responsible for a table and calling inserters on it.
 //Serves as frontend to a table
 type TableManager struct {
+	//bufferedBytes is size of rows (as received, see table.Rows.Size) appended to the current table,
+	//first for 64-bit alignment of atomic operations
+	bufferedBytes int64
+	//paused is set to 1 by Pause, rows are inserted only by DoInsert and Stop then
+	paused int32
+
 	table     *table.Table
 	tableMut  sync.Mutex
 	rowsJsons []byte
+	//stopped is set by Stop under tableMut, after that rows aren't accepted
+	stopped bool
+	//wal is set by Holder when persist is configured,
+	//segment holds persisted rows of the current table
+	wal     *wal.WAL
+	segment *wal.Segment
+	//result is awaited by requests with rows of the current table
+	result *batchResult
+	//receipts is set by Holder, so sync managers don't have receipts
+	receipts *receipts
+	//bufferedRows is set by Holder, so sync managers don't report
+	bufferedRows prometheus.Gauge
+	//bufferTracker is set by Holder, inserted bytes are released to it
+	bufferTracker *bufferTracker
+	//spool is set by Holder when spool is configured, failed batches go to it
+	spool *spool.Spool
+	//lastInsert is time and error of the last insert
+	lastInsert    time.Time
+	lastInsertErr error
+	lastInsertMut sync.Mutex
+	//logger has table field, Holder replaces it with one derived from it's logger
+	logger *logging.Logger
 
 	maxRows           int64
+	maxBytes          int64
 	inserters         map[string]inserter.Inserter
 	insertErrorLogger *inserter.InsertErrorLogger
 	timeoutMs         int64
 	sendChannel       chan struct{}
+	flushChannel      chan struct{}
 	stopChannel       chan struct{}
+	//doneChannel is closed when Run is finished
+	doneChannel chan struct{}
 }
 
 //NewTableManager returns configured table manager
 func NewTableManager(ts *table.Signature, config Config, inserters map[string]inserter.Inserter, insertErrorLogger *inserter.InsertErrorLogger) *TableManager {
 	return &TableManager{
 		table:             table.NewTable(*ts),
+		result:            newBatchResult(),
+		logger:            logging.With("table", ts.GetTableName()),
 		rowsJsons:         []byte{},
 		inserters:         inserters,
 		insertErrorLogger: insertErrorLogger,
 		maxRows:           int64(config.MaxRows),
+		maxBytes:          config.MaxBytes,
 		timeoutMs:         config.TimeoutMs,
 		sendChannel:       make(chan struct{}, 1),
+		flushChannel:      make(chan struct{}, 1),
 		stopChannel:       make(chan struct{}),
+		doneChannel:       make(chan struct{}),
 	}
 }
 
-//UpdateConfig updates maxRows and timeoutMs from config.
+//UpdateConfig updates maxRows, maxBytes and timeoutMs from config.
 //Thread safe
 func (tm *TableManager) UpdateConfig(config Config) {
 	atomic.StoreInt64(&tm.maxRows, int64(config.MaxRows))
+	atomic.StoreInt64(&tm.maxBytes, config.MaxBytes)
 	atomic.StoreInt64(&tm.timeoutMs, config.TimeoutMs)
 }
 
 //AppendRowsToTable is a frontend for table's AppendRows.
-//If maxRows is reached sends signal to start inserting (see Run)
+//If maxRows or maxBytes is reached sends signal to start inserting (see Run)
 func (tm *TableManager) AppendRowsToTable(rowsJSON []byte) error {
+	_, err := tm.appendRows(context.Background(), table.NewJSONRows(rowsJSON), false)
+	return err
+}
+
+//AppendPersistentRowsToTable does the same as AppendRowsToTable,
+//but also writes rows to the write-ahead log before returning
+func (tm *TableManager) AppendPersistentRowsToTable(rowsJSON []byte) error {
+	_, err := tm.appendRows(context.Background(), table.NewJSONRows(rowsJSON), true)
+	return err
+}
+
+//appendRows appends JSON or decoded rows, the latter
+//are encoded to JSON only to be written to the write-ahead log.
+//Returns result of the table's insert, nil if there were no rows.
+//Append's span is a child of ctx's one, the insert span is linked to it
+func (tm *TableManager) appendRows(ctx context.Context, rows table.Rows, persist bool) (result *batchResult, err error) {
+	if persist && tm.wal == nil {
+		return nil, ErrPersistNotConfigured
+	}
+	_, span := tracing.Start(ctx, "table.append")
+	defer func() {
+		span.SetAttributes(attribute.String("batch.id", result.getID()))
+		tracing.SetError(span, err)
+		span.End()
+	}()
 	tm.tableMut.Lock()
-	err := tm.table.AppendRows(rowsJSON)
+	if tm.stopped {
+		tm.tableMut.Unlock()
+		return nil, ErrTableManagerStopped
+	}
+	rowsLen := tm.table.GetRowsLen()
+	result = tm.result
+	err = tm.table.Append(rows)
+	if err == nil && persist {
+		if err = tm.writeToSegment(rows); err != nil {
+			tm.table.TruncateRows(rowsLen)
+		}
+	}
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("table", tm.table.GetTableName()),
+			attribute.Int("rows", tm.table.GetRowsLen()-rowsLen),
+			attribute.Int64("bytes", rows.Size()),
+		)
+		if tm.table.GetRowsLen() == rowsLen {
+			//bytes of no rows aren't buffered, so they aren't released by insert
+			result = nil
+		} else {
+			atomic.AddInt64(&tm.bufferedBytes, rows.Size())
+			tm.setBufferedRowsMetric(tm.table.GetRowsLen())
+			tm.addToResult(result, rowsLen == 0, span)
+		}
+	}
 	tm.tableMut.Unlock()
-	if tm.isTooManyRows() {
-		log.Printf("reached max rows for table %s", tm.table.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	if tm.isTooManyRows() || tm.isTooManyBytes() {
+		tm.logger.Debug("reached max rows or max bytes")
 		select {
 		case tm.sendChannel <- struct{}{}:
 		default:
 		}
 	}
 
-	return err
+	return result, nil
 }
 
-func (tm *TableManager) isTooManyRows() bool {
+//addToResult records append in the current result, must be called under tableMut
+func (tm *TableManager) addToResult(result *batchResult, first bool, span trace.Span) {
+	if first {
+		result.firstAppend = time.Now()
+		if tm.receipts != nil {
+			tm.receipts.add(result, tm.table.GetTableName(), tm.getInserterNames())
+		}
+	}
+	if span.IsRecording() && len(result.links) < tracing.MaxLinks {
+		result.links = append(result.links, trace.Link{SpanContext: span.SpanContext()})
+	}
+}
+
+//writeToSegment must be called under tableMut
+func (tm *TableManager) writeToSegment(rows table.Rows) error {
+	rowsJSON, err := rows.JSON()
+	if err != nil {
+		return err
+	}
+	if tm.segment == nil {
+		segment, err := tm.wal.NewSegment(wal.Header{
+			Table:     tm.table.GetTableName(),
+			Fields:    tm.table.GetFields(),
+			TimeoutMs: atomic.LoadInt64(&tm.timeoutMs),
+			MaxRows:   atomic.LoadInt64(&tm.maxRows),
+			MaxBytes:  atomic.LoadInt64(&tm.maxBytes),
+			Inserters: tm.getInserterNames(),
+		})
+		if err != nil {
+			return err
+		}
+		tm.segment = segment
+	}
+
+	return tm.segment.Write(rowsJSON)
+}
+
+//getInserterNames returns sorted names of manager's inserters
+func (tm *TableManager) getInserterNames() []string {
+	names := make([]string, 0, len(tm.inserters))
+	for name := range tm.inserters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (tm *TableManager) setBufferedRowsMetric(rowsLen int) {
+	if tm.bufferedRows != nil {
+		tm.bufferedRows.Set(float64(rowsLen))
+	}
+}
+
+//GetBufferedBytes returns size of rows JSON waiting for insert.
+//Thread safe
+func (tm *TableManager) GetBufferedBytes() int64 {
+	return atomic.LoadInt64(&tm.bufferedBytes)
+}
+
+//GetBufferedRows returns count of rows waiting for insert.
+//Thread safe
+func (tm *TableManager) GetBufferedRows() int {
 	tm.tableMut.Lock()
-	rowsLen := tm.table.GetRowsLen()
-	tm.tableMut.Unlock()
+	defer tm.tableMut.Unlock()
 
-	return int64(rowsLen) >= atomic.LoadInt64(&tm.maxRows)
+	return tm.table.GetRowsLen()
+}
+
+func (tm *TableManager) isTooManyRows() bool {
+	return int64(tm.GetBufferedRows()) >= atomic.LoadInt64(&tm.maxRows)
+}
+
+//Flush sends a signal to insert rows without waiting for timeout
+//or max rows. Doesn't block. Thread safe
+func (tm *TableManager) Flush() {
+	select {
+	case tm.flushChannel <- struct{}{}:
+	default:
+	}
+}
+
+//isTooManyBytes reports if rows JSON size reached maxBytes (if it's set)
+func (tm *TableManager) isTooManyBytes() bool {
+	maxBytes := atomic.LoadInt64(&tm.maxBytes)
+
+	return maxBytes > 0 && tm.GetBufferedBytes() >= maxBytes
 }
 
 //Run is manager's main loop where is waiting for time limit
@@ -79,27 +268,57 @@ func (tm *TableManager) Run() {
 	timer := tm.newTimer()
 	for {
 		stop := false
+		var trigger string
 		select {
 		case <-timer.C:
+			trigger = metrics.FlushTriggerTimeout
 		case <-tm.sendChannel:
-			if !tm.isTooManyRows() {
+			if tm.isTooManyRows() {
+				trigger = metrics.FlushTriggerMaxRows
+			} else if tm.isTooManyBytes() {
+				trigger = metrics.FlushTriggerMaxBytes
+			} else {
 				continue
 			}
 			timer.Stop()
+		case <-tm.flushChannel:
+			timer.Stop()
+			trigger = metrics.FlushTriggerBackpressure
 		case <-tm.stopChannel:
 			stop = true
+			trigger = metrics.FlushTriggerStop
 		}
 
 		timer = tm.newTimer()
-		err := tm.DoInsert()
+		if !stop && tm.IsPaused() {
+			continue
+		}
+		err := tm.doInsert(trigger)
 		if err != nil {
-			log.Println(err)
+			tm.logger.Error("batch not inserted", "trigger", trigger, "error", err)
 		}
 		if stop {
 			break
 		}
 	}
-	tm.stopChannel <- struct{}{}
+	close(tm.doneChannel)
+}
+
+//Done returns a channel that is closed when Run is finished,
+//so manager doesn't use it's inserters anymore
+func (tm *TableManager) Done() <-chan struct{} {
+	return tm.doneChannel
+}
+
+//UsesInserter reports if ins is one of manager's inserters
+func (tm *TableManager) UsesInserter(ins inserter.Inserter) bool {
+	for _, managerIns := range tm.inserters {
+		if managerIns == ins {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (tm *TableManager) newTimer() *time.Timer {
@@ -108,30 +327,183 @@ func (tm *TableManager) newTimer() *time.Timer {
 }
 
 //DoInsert creates a new table and calls inserters on the old
-func (tm *TableManager) DoInsert() (err error) {
+func (tm *TableManager) DoInsert() error {
+	return tm.doInsert(metrics.FlushTriggerManual)
+}
+
+//Pause makes manager keep rows till Resume, they are inserted only
+//by DoInsert and Stop. Thread safe
+func (tm *TableManager) Pause() {
+	atomic.StoreInt32(&tm.paused, 1)
+}
+
+//Resume makes paused manager insert rows on timeout, max rows
+//or max bytes again. Thread safe
+func (tm *TableManager) Resume() {
+	if atomic.SwapInt32(&tm.paused, 0) == 0 {
+		return
+	}
+	select {
+	case tm.sendChannel <- struct{}{}:
+	default:
+	}
+}
+
+//IsPaused reports if manager is paused. Thread safe
+func (tm *TableManager) IsPaused() bool {
+	return atomic.LoadInt32(&tm.paused) == 1
+}
+
+//GetLastInsert returns time and error of the last insert,
+//zero time if there were no inserts. Thread safe
+func (tm *TableManager) GetLastInsert() (time.Time, error) {
+	tm.lastInsertMut.Lock()
+	defer tm.lastInsertMut.Unlock()
+
+	return tm.lastInsert, tm.lastInsertErr
+}
+
+//doInsert does DoInsert, trigger is the reason of insert for metrics
+func (tm *TableManager) doInsert(trigger string) (err error) {
 	if tm.isTableEmpty() {
 		return nil
 	}
 
-	tbl := tm.getTableAndMakeNew()
-	if len(tm.inserters) == 1 {
-		for _, inserter := range tm.inserters {
-			err = inserter.Insert(tbl)
+	metrics.Flushes.WithLabelValues(trigger).Inc()
+	tbl, segment, bytes, result := tm.getTableAndSegmentAndMakeNew()
+	ctx, span := tracing.Start(context.Background(), "table.insert", trace.WithNewRoot(), trace.WithLinks(result.links...))
+	span.SetAttributes(
+		attribute.String("table", tbl.GetTableName()),
+		attribute.Int("rows", tbl.GetRowsLen()),
+		attribute.Int64("bytes", bytes),
+		attribute.String("trigger", trigger),
+		attribute.String("batch.id", result.id),
+		attribute.Float64("buffer.wait_ms", logging.DurationMs(time.Since(result.firstAppend))),
+	)
+	inserters, queued := tm.splitSpooledInserters(tbl.Signature)
+	if len(inserters) == 1 {
+		for name, inserter := range inserters {
+			if insertErr := tm.insert(ctx, name, inserter, tbl); insertErr != nil {
+				err = InsertError{Errors: map[string]error{name: insertErr}}
+			}
 		}
-	} else {
-		err = tm.insertConcurrently(tbl)
+	} else if len(inserters) > 1 {
+		err = tm.insertConcurrently(ctx, inserters, tbl)
 	}
+	tracing.SetError(span, err)
+	span.End()
+	spooled, err := tm.spoolFailed(err, queued, tbl, result.id)
+	canRemoveSegment := true
+	var failedInserters []string
 	if err != nil {
 		tbl.Reset()
-		if logErr := tm.insertErrorLogger.Log(err, tbl); logErr != nil {
-			log.Printf("failed to write error log: %s", logErr)
+		if insertErr, ok := err.(InsertError); ok {
+			failedInserters = insertErr.FailedInserters()
+		}
+		logErr := tm.insertErrorLogger.Log(err, failedInserters, tbl)
+		if logErr != nil {
+			tm.logger.Error("failed to write error log", "error", logErr)
 		}
+		canRemoveSegment = logErr == nil && tm.insertErrorLogger.IsEnabled()
 	}
 	tbl.Free()
+	if tm.bufferTracker != nil {
+		tm.bufferTracker.release(bytes)
+	}
+	tm.releaseSegment(segment, canRemoveSegment, failedInserters)
+	if tm.receipts != nil {
+		tm.receipts.finish(result, tm.getInserterNames(), err, spooled)
+	} else {
+		result.finish(err)
+	}
+	tm.lastInsertMut.Lock()
+	tm.lastInsert, tm.lastInsertErr = time.Now(), err
+	tm.lastInsertMut.Unlock()
 
 	return
 }
 
+//splitSpooledInserters returns inserters to insert into and names
+//of inserters that have spooled batches of the table, so the new batch
+//should be queued after them
+func (tm *TableManager) splitSpooledInserters(ts table.Signature) (map[string]inserter.Inserter, []string) {
+	if tm.spool == nil {
+		return tm.inserters, nil
+	}
+	var queued []string
+	inserters := make(map[string]inserter.Inserter, len(tm.inserters))
+	for name, ins := range tm.inserters {
+		if tm.spool.HasPending(name, ts) {
+			queued = append(queued, name)
+			continue
+		}
+		inserters[name] = ins
+	}
+
+	return inserters, queued
+}
+
+//spoolFailed pushes table with batchID to spool for queued and failed inserters.
+//Returns names of inserters the table is spooled for and InsertError
+//of inserters it couldn't be spooled for
+func (tm *TableManager) spoolFailed(err error, queued []string, tbl *table.Table, batchID string) ([]string, error) {
+	if tm.spool == nil {
+		return nil, err
+	}
+	failed, ok := err.(InsertError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	errs := map[string]error{}
+	for name, insertErr := range failed.Errors {
+		errs[name] = insertErr
+	}
+	for _, name := range queued {
+		errs[name] = ErrQueuedAfterSpooled
+	}
+	var spooled []string
+	for name, insertErr := range errs {
+		tbl.Reset()
+		if spoolErr := tm.spool.Push(name, insertErr, tbl, batchID); spoolErr != nil {
+			tm.logger.Error("can't spool batch", "inserter", name, "rows", tbl.GetRowsLen(), "error", spoolErr)
+			continue
+		}
+		tm.logger.Warn("spooled batch", "inserter", name, "rows", tbl.GetRowsLen(), "error", insertErr)
+		delete(errs, name)
+		spooled = append(spooled, name)
+	}
+	if len(errs) != 0 {
+		return spooled, InsertError{Errors: errs}
+	}
+
+	return spooled, nil
+}
+
+//releaseSegment removes segment if it's rows are inserted or logged,
+//otherwise leaves it on disk to be replayed on the next start
+//by failedInserters (by all inserters if it's empty)
+func (tm *TableManager) releaseSegment(segment *wal.Segment, remove bool, failedInserters []string) {
+	if segment == nil {
+		return
+	}
+	if !remove {
+		tm.logger.Warn("keeping wal segment for replay", "segment", segment.Path())
+		var err error
+		if len(failedInserters) != 0 {
+			err = segment.CloseForInserters(failedInserters)
+		} else {
+			err = segment.Close()
+		}
+		if err != nil {
+			tm.logger.Error("failed to close wal segment", "segment", segment.Path(), "error", err)
+		}
+		return
+	}
+	if err := segment.Remove(); err != nil {
+		tm.logger.Error("failed to remove wal segment", "segment", segment.Path(), "error", err)
+	}
+}
+
 func (tm *TableManager) isTableEmpty() bool {
 	tm.tableMut.Lock()
 	res := tm.table.GetRowsLen() == 0
@@ -141,44 +513,90 @@ func (tm *TableManager) isTableEmpty() bool {
 }
 
 func (tm *TableManager) getTableAndMakeNew() *table.Table {
+	oldTable, _, _, _ := tm.getTableAndSegmentAndMakeNew()
+	return oldTable
+}
+
+//getTableAndSegmentAndMakeNew also returns size of rows of the old table
+//and result of it's insert
+func (tm *TableManager) getTableAndSegmentAndMakeNew() (*table.Table, *wal.Segment, int64, *batchResult) {
 	ts := tm.table.Signature
 	newTable := table.NewTable(ts)
 
 	var oldTable *table.Table
+	var oldSegment *wal.Segment
+	var oldResult *batchResult
 	tm.tableMut.Lock()
 	oldTable, tm.table = tm.table, newTable
+	oldSegment, tm.segment = tm.segment, nil
+	oldResult, tm.result = tm.result, newBatchResult()
+	oldBytes := atomic.SwapInt64(&tm.bufferedBytes, 0)
+	tm.setBufferedRowsMetric(0)
 	defer tm.tableMut.Unlock()
 
-	return oldTable
+	return oldTable, oldSegment, oldBytes, oldResult
 }
 
-func (tm *TableManager) insertConcurrently(t *table.Table) error {
-	errChan := make(chan error)
-	defer close(errChan)
-	for _, ins := range tm.inserters {
-		go func(inserter inserter.Inserter, t table.Table) {
-			errChan <- inserter.Insert(&t)
-		}(ins, *t)
+//insertConcurrently calls inserters at once. If some of them failed
+//returns InsertError
+func (tm *TableManager) insertConcurrently(ctx context.Context, inserters map[string]inserter.Inserter, t *table.Table) error {
+	type insertResult struct {
+		name string
+		err  error
 	}
-	errMessages := make([]string, 0, len(tm.inserters))
-	for range tm.inserters {
-		if err := <-errChan; err != nil {
-			errMessages = append(errMessages, err.Error())
+	resultChan := make(chan insertResult)
+	defer close(resultChan)
+	for name, ins := range inserters {
+		go func(name string, inserter inserter.Inserter, t table.Table) {
+			resultChan <- insertResult{name, tm.insert(ctx, name, inserter, &t)}
+		}(name, ins, *t)
+	}
+	errs := map[string]error{}
+	for range inserters {
+		if result := <-resultChan; result.err != nil {
+			errs[result.name] = result.err
 		}
 	}
-	if len(errMessages) != 0 {
-		return errors.New(strings.Join(errMessages, ","))
+	if len(errs) != 0 {
+		return InsertError{Errors: errs}
 	}
 
 	return nil
 }
 
-//Stop sends a signal in main loop to insert,
-//waits for response (which means the main loop is finished)
+//insert calls inserter, reports to metrics, log and a child span of ctx's one
+func (tm *TableManager) insert(ctx context.Context, name string, inserter inserter.Inserter, t *table.Table) error {
+	rowsLen := t.GetRowsLen()
+	ctx, span := tracing.Start(ctx, "inserter.insert")
+	span.SetAttributes(attribute.String("inserter", name), attribute.String("table", t.GetTableName()), attribute.Int("rows", rowsLen))
+	start := time.Now()
+	err := inserter.Insert(ctx, t)
+	duration := time.Since(start)
+	tracing.SetError(span, err)
+	span.End()
+	metrics.InsertDuration.WithLabelValues(name).Observe(duration.Seconds())
+	if err != nil {
+		metrics.InsertErrors.WithLabelValues(name).Inc()
+		tm.logger.Warn(
+			"insert failed",
+			"inserter", name, "rows", rowsLen, "duration_ms", logging.DurationMs(duration), "error", err,
+		)
+		return err
+	}
+	tm.logger.Info("inserted", "inserter", name, "rows", rowsLen, "duration_ms", logging.DurationMs(duration))
+
+	return nil
+}
+
+//Stop makes manager reject new rows, sends a signal in main loop to insert,
+//waits for the main loop to finish
 func (tm *TableManager) Stop() {
-	key := tm.table.GetKey()
-	log.Printf("stopping table manager for %s", key)
+	tm.tableMut.Lock()
+	tm.stopped = true
+	fields := tm.table.GetFields()
+	tm.tableMut.Unlock()
+	tm.logger.Info("stopping table manager", "fields", fields)
 	tm.stopChannel <- struct{}{}
-	<-tm.stopChannel
-	log.Printf("stopped table manager for %s", key)
+	<-tm.doneChannel
+	tm.logger.Info("stopped table manager", "fields", fields)
 }
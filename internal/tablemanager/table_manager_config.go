@@ -9,15 +9,19 @@ var (
 	ErrZeroTimeoutMs = errors.New("timeout_ms couldn't be zero")
 	//ErrZeroMaxRows means that maxRows is 0
 	ErrZeroMaxRows = errors.New("max_rows couldn't be zero")
-	//ErrPersistNotFalse means that persist is not false (persist is yet not supported)
-	ErrPersistNotFalse = errors.New("persist is not yet supported")
+	//ErrPersistNotConfigured means that persist is requested, but write-ahead log isn't configured
+	ErrPersistNotConfigured = errors.New("persist is not configured")
 )
 
 //Config has viable for TableManager fields like timeout and max rows
 type Config struct {
 	TimeoutMs int64
 	MaxRows   int64
-	Persist   bool
+	//MaxBytes limits size of rows JSON before insert, 0 means no limit
+	MaxBytes int64
+	Persist  bool
+	//Inserters are requested inserters, all routed ones if empty
+	Inserters []string
 }
 
 //NewConfig returns a ready to use config
@@ -37,9 +41,6 @@ func (c Config) Validate() error {
 	if c.MaxRows == 0 {
 		return ErrZeroMaxRows
 	}
-	if c.Persist {
-		return ErrPersistNotFalse
-	}
 
 	return nil
 }
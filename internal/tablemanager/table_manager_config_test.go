@@ -37,7 +37,7 @@ func TestTableManagerConfig(t *testing.T) {
 	config.MaxRows = 1000
 	config.TimeoutMs = 1000
 	config.Persist = true
-	if err := config.Validate(); !errors.Is(err, ErrPersistNotFalse) {
+	if err := config.Validate(); err != nil {
 		t.Error(err)
 	}
 }
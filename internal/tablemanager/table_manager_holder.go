@@ -1,13 +1,22 @@
 package tablemanager
 
 import (
-	"log"
+	"context"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
+	"github.com/edwvee/dbatcher/internal/spool"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/edwvee/dbatcher/internal/wal"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const maxTableManagerStopTime = 5 * time.Second
@@ -18,6 +27,10 @@ var stopUnusedManagersInterval = 10 * time.Second
 //ErrTableManagerDidntStopInTime means table manager didn't stop in time
 var ErrTableManagerDidntStopInTime = errors.New("didn't stop in time")
 
+//ErrWaitTimeout means rows weren't inserted before wait's deadline,
+//they are still in the table and could be inserted later
+var ErrWaitTimeout = errors.New("rows weren't inserted before wait timeout, they could be inserted later")
+
 //Holder creates table managers, holds pointers to them,
 //stops them when they are not used for a long time.
 //Serves as frontend to table managers.
@@ -27,6 +40,15 @@ type Holder struct {
 	lastManagerVisit  map[string]time.Time
 	managersMut       sync.Mutex
 	insertErrorLogger *inserter.InsertErrorLogger
+	wal               *wal.WAL
+	router            *Router
+	//tablePolicies are keyed by normalized table name
+	tablePolicies map[string]TablePolicy
+	bufferTracker *bufferTracker
+	spool         *spool.Spool
+	receipts      *receipts
+	//logger is parent of table managers' loggers
+	logger *logging.Logger
 }
 
 //NewHolder creates new holder
@@ -36,36 +58,411 @@ func NewHolder(errChan chan error, inserters map[string]inserter.Inserter, inser
 		managers:          map[string]*TableManager{},
 		lastManagerVisit:  map[string]time.Time{},
 		insertErrorLogger: insertErrorLogger,
+		bufferTracker:     newBufferTracker(),
+		receipts:          newReceipts(),
+		logger:            logging.Default(),
+	}
+}
+
+//SetReceiptsConfig sets retention of receipts. Thread safe
+func (h *Holder) SetReceiptsConfig(config ReceiptsConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	h.receipts.setConfig(config)
+
+	return nil
+}
+
+//GetReceipt returns receipt of the batch by ID (see AppendRowsWithReceipt),
+//false if there is no such receipt or it's expired
+func (h *Holder) GetReceipt(id string) (Receipt, bool) {
+	return h.receipts.get(id)
+}
+
+//SetBufferConfig limits size of rows JSON buffered by all table managers.
+//Thread safe
+func (h *Holder) SetBufferConfig(config BufferConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	h.bufferTracker.setConfig(config)
+
+	return nil
+}
+
+//SetRouter makes holder send tables only to inserters of their routes.
+//Should be called before receiving rows
+func (h *Holder) SetRouter(router *Router) {
+	h.managersMut.Lock()
+	h.router = router
+	h.managersMut.Unlock()
+}
+
+//SetLogger sets logger, table managers log with it's fields
+//and their table. Should be called before receiving rows
+func (h *Holder) SetLogger(logger *logging.Logger) {
+	h.managersMut.Lock()
+	h.logger = logger
+	h.managersMut.Unlock()
+}
+
+//SetTablePolicies sets server side batching configs of tables
+//by their names. Thread safe
+func (h *Holder) SetTablePolicies(policies map[string]TablePolicy) {
+	normalized := make(map[string]TablePolicy, len(policies))
+	for tableName, policy := range policies {
+		normalized[normalizeTableName(tableName)] = policy
+	}
+	h.managersMut.Lock()
+	h.tablePolicies = normalized
+	h.managersMut.Unlock()
+}
+
+//applyTablePolicy returns config with table's policy values if there is a policy
+func (h *Holder) applyTablePolicy(ts *table.Signature, config Config) Config {
+	h.managersMut.Lock()
+	policy, ok := h.tablePolicies[normalizeTableName(ts.GetTableName())]
+	h.managersMut.Unlock()
+	if !ok {
+		return config
+	}
+
+	return policy.Apply(config)
+}
+
+//EnableSpool makes table managers push batches that inserters failed
+//to insert to s instead of insert error log. Spool's inserters are
+//replaced together with holder's, receipts of spooled batches are finished
+//by spool. Should be called before receiving rows and spool's Run
+func (h *Holder) EnableSpool(s *spool.Spool) {
+	s.SetOnDone(h.receipts.finishSpooled)
+	h.managersMut.Lock()
+	h.spool = s
+	h.managersMut.Unlock()
+}
+
+//EnablePersist makes holder write rows of persist requests to w.
+//Segments left from the previous run are replayed into table managers
+//and removed after that. If some records of a segment can't be replayed,
+//the segment is kept and error is returned. Should be called before receiving rows.
+func (h *Holder) EnablePersist(w *wal.WAL) error {
+	paths, err := w.SegmentPaths()
+	if err != nil {
+		return err
+	}
+	h.managersMut.Lock()
+	h.wal = w
+	h.managersMut.Unlock()
+
+	for _, path := range paths {
+		if err := h.replaySegment(path); err != nil {
+			return errors.Wrapf(err, "replay %s", path)
+		}
+	}
+
+	return nil
+}
+
+func (h *Holder) replaySegment(path string) error {
+	header, records, err := wal.ReadSegment(path)
+	if err != nil && !errors.Is(err, wal.ErrEmptySegment) {
+		return err
+	}
+	if err == nil {
+		h.logger.Info("replaying wal segment", "table", header.Table, "records", len(records), "segment", path)
+		ts := table.NewSignature(header.Table, header.Fields)
+		config := NewConfig(header.TimeoutMs, header.MaxRows, true)
+		config.MaxBytes = header.MaxBytes
+		//only failed inserters replay the segment, others have inserted it
+		config.Inserters = header.FailedInserters
+		if len(config.Inserters) == 0 {
+			config.Inserters = header.Inserters
+		}
+		failed := 0
+		for _, rowsJSON := range records {
+			err := h.Append(&ts, config, false, rowsJSON)
+			if errors.Is(err, ErrInserterNotRouted) {
+				h.logger.Warn(
+					"inserters of wal segment aren't routed anymore, using current routes",
+					"table", header.Table, "inserters", config.Inserters, "segment", path,
+				)
+				config.Inserters = nil
+				err = h.Append(&ts, config, false, rowsJSON)
+			}
+			if err != nil {
+				h.logger.Error("failed to replay wal record", "table", header.Table, "segment", path, "error", err)
+				failed++
+			}
+		}
+		if failed != 0 {
+			//segment is kept, so rows aren't lost
+			return errors.Errorf("%d of %d records weren't replayed", failed, len(records))
+		}
 	}
+
+	return os.Remove(path)
 }
 
 //Append searches for an existing table manager or creates it,
-//then calls it's AppendRowsToTable. If sync is true, always creates a new manager
-//and instantly calls DoInsert.
+//then appends rows to it (and to the write-ahead log
+//if config.Persist is true). Table's policy is applied to config
+//and the result is validated. Manager's inserters are found by router
+//and config.Inserters. If the manager was stopped meanwhile,
+//appends to a new one. If buffer is full (see SetBufferConfig),
+//returns ErrBufferFull or blocks and returns ErrBufferFullTimeout.
+//If sync is true, always creates a new manager and instantly calls DoInsert.
 func (h *Holder) Append(ts *table.Signature, config Config, sync bool, rowsJSON []byte) error {
+	return h.AppendRows(ts, config, sync, table.NewJSONRows(rowsJSON))
+}
+
+//AppendRows does the same as Append, but takes JSON or already decoded rows
+func (h *Holder) AppendRows(ts *table.Signature, config Config, sync bool, rows table.Rows) error {
+	_, err := h.AppendRowsWithReceipt(context.Background(), ts, config, sync, rows)
+	return err
+}
+
+//AppendRowsWithReceipt does the same as AppendRows, also returns ID
+//of receipt of the batch the rows joined (see GetReceipt).
+//ID is empty for sync and if there were no rows.
+//Spans of append are children of ctx's span (see tracing.Start)
+func (h *Holder) AppendRowsWithReceipt(ctx context.Context, ts *table.Signature, config Config, sync bool, rows table.Rows) (string, error) {
 	if !sync {
-		manager := h.getTableManager(ts, config)
-		return manager.AppendRowsToTable(rowsJSON)
+		result, err := h.appendRows(ctx, ts, config, rows)
+		return result.getID(), err
 	}
 
 	//not optimized due sync is debug feature
-	manager := NewTableManager(ts, config, h.inserters, h.insertErrorLogger)
-	if err := manager.AppendRowsToTable(rowsJSON); err != nil {
-		return err
+	config, err := h.prepareConfig(ts, config, true)
+	if err != nil {
+		return "", err
+	}
+	_, inserters, err := h.routeInserters(ts, config.Inserters)
+	if err != nil {
+		return "", err
+	}
+	size := rows.Size()
+	if err := h.bufferTracker.acquire(size, h.flushLargestManagers); err != nil {
+		return "", err
+	}
+	manager := NewTableManager(ts, config, inserters, h.insertErrorLogger)
+	manager.bufferTracker = h.bufferTracker
+	h.managersMut.Lock()
+	manager.logger = h.logger.With("table", ts.GetTableName())
+	h.managersMut.Unlock()
+	result, err := manager.appendRows(ctx, rows, false)
+	if err != nil || result == nil {
+		//there were no rows, so insert doesn't release the size
+		h.bufferTracker.release(size)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", manager.doInsert(metrics.FlushTriggerSync)
+}
+
+//prepareConfig applies table's policy to config and validates it.
+//Sync rows are inserted right away, so they don't need timeout and max rows
+func (h *Holder) prepareConfig(ts *table.Signature, config Config, sync bool) (Config, error) {
+	config = h.applyTablePolicy(ts, config)
+	if !sync {
+		if err := config.Validate(); err != nil {
+			return config, err
+		}
+	}
+	if config.Persist && !h.isPersistEnabled() {
+		return config, ErrPersistNotConfigured
+	}
+
+	return config, nil
+}
+
+//AppendRowsAndWait does the same as AppendRowsWithReceipt without sync,
+//then waits till the manager's table with the rows is inserted by all it's
+//inserters, including spool's retries for inserters that failed. Returns
+//InsertError of inserters that failed and weren't spooled (or spool dropped
+//the batch) or ErrWaitTimeout if ctx is done before, rows could be inserted
+//later then. Spans of append are children of ctx's span
+func (h *Holder) AppendRowsAndWait(ctx context.Context, ts *table.Signature, config Config, rows table.Rows) (string, error) {
+	result, err := h.appendRows(ctx, ts, config, rows)
+	if err != nil || result == nil {
+		return "", err
+	}
+	select {
+	case <-result.done:
+		return result.id, result.err
+	case <-ctx.Done():
+		return result.id, errors.Wrap(ErrWaitTimeout, ctx.Err().Error())
+	}
+}
+
+//appendRows appends rows without sync, returns result of the table's insert.
+//It's span has children of waiting for buffer space and of table's append
+func (h *Holder) appendRows(ctx context.Context, ts *table.Signature, config Config, rows table.Rows) (result *batchResult, err error) {
+	ctx, span := tracing.Start(ctx, "append")
+	span.SetAttributes(attribute.String("table", ts.GetTableName()), attribute.Int64("bytes", rows.Size()))
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
+	if config, err = h.prepareConfig(ts, config, false); err != nil {
+		return nil, err
+	}
+	size := rows.Size()
+	_, acquireSpan := tracing.Start(ctx, "buffer.acquire")
+	err = h.bufferTracker.acquire(size, h.flushLargestManagers)
+	tracing.SetError(acquireSpan, err)
+	acquireSpan.End()
+	if err != nil {
+		return nil, err
+	}
+	result, err = h.appendToManager(ctx, ts, config, rows)
+	if err != nil || result == nil {
+		//there were no rows, so insert doesn't release the size
+		h.bufferTracker.release(size)
+	}
+
+	return result, err
+}
+
+//appendToManager appends rows to the table manager, retrying
+//if the manager was stopped meanwhile
+func (h *Holder) appendToManager(ctx context.Context, ts *table.Signature, config Config, rows table.Rows) (*batchResult, error) {
+	for {
+		manager, err := h.getTableManager(ts, config)
+		if err != nil {
+			return nil, err
+		}
+		result, err := manager.appendRows(ctx, rows, config.Persist)
+		if err != ErrTableManagerStopped {
+			return result, err
+		}
+	}
+}
+
+//flushLargestManagers makes not paused managers with the most buffered
+//bytes insert, until they hold at least need bytes
+func (h *Holder) flushLargestManagers(need int64) {
+	h.managersMut.Lock()
+	managers := make([]*TableManager, 0, len(h.managers))
+	for _, manager := range h.managers {
+		if !manager.IsPaused() {
+			managers = append(managers, manager)
+		}
+	}
+	h.managersMut.Unlock()
+
+	sizes := make(map[*TableManager]int64, len(managers))
+	for _, manager := range managers {
+		sizes[manager] = manager.GetBufferedBytes()
+	}
+	sort.Slice(managers, func(i, j int) bool {
+		return sizes[managers[i]] > sizes[managers[j]]
+	})
+	var flushed int64
+	for _, manager := range managers {
+		if flushed >= need || sizes[manager] == 0 {
+			break
+		}
+		manager.Flush()
+		flushed += sizes[manager]
+	}
+}
+
+//routeInserters returns sorted names and inserters for the table
+func (h *Holder) routeInserters(ts *table.Signature, requested []string) ([]string, map[string]inserter.Inserter, error) {
+	h.managersMut.Lock()
+	defer h.managersMut.Unlock()
+
+	return h.routeInsertersLocked(ts, requested)
+}
+
+//routeInsertersLocked must be called under managersMut
+func (h *Holder) routeInsertersLocked(ts *table.Signature, requested []string) ([]string, map[string]inserter.Inserter, error) {
+	names, err := h.router.Route(ts.GetTableName(), requested, h.inserters)
+	if err != nil {
+		return nil, nil, err
+	}
+	inserters := make(map[string]inserter.Inserter, len(names))
+	for _, name := range names {
+		ins, ok := h.inserters[name]
+		if !ok {
+			return nil, nil, errors.Wrapf(ErrInserterNotRouted, "%s for %s", name, ts.GetTableName())
+		}
+		inserters[name] = ins
+	}
+
+	return names, inserters, nil
+}
+
+func (h *Holder) getInserters() map[string]inserter.Inserter {
+	h.managersMut.Lock()
+	defer h.managersMut.Unlock()
+
+	return h.inserters
+}
+
+//ReplaceInserters makes new table managers use inserters and router.
+//Existing managers are stopped, so their rows are inserted by inserters
+//they were accepted for. Spool (if enabled) retries with the new inserters.
+//Returns managers that didn't stop in time (they still may use the old
+//inserters till their Done) and errors of stopping
+func (h *Holder) ReplaceInserters(inserters map[string]inserter.Inserter, router *Router) ([]*TableManager, []error) {
+	h.managersMut.Lock()
+	h.inserters = inserters
+	h.router = router
+	if h.spool != nil {
+		h.spool.SetInserters(inserters)
+	}
+	managers := h.managers
+	h.managers = map[string]*TableManager{}
+	h.lastManagerVisit = map[string]time.Time{}
+	for key := range managers {
+		deleteManagerMetrics(key)
 	}
-	return manager.DoInsert()
+	h.managersMut.Unlock()
+
+	errs := stopManagers(managers)
+	stopping := []*TableManager{}
+	for _, manager := range managers {
+		select {
+		case <-manager.Done():
+		default:
+			stopping = append(stopping, manager)
+		}
+	}
+
+	return stopping, errs
 }
 
-func (h *Holder) getTableManager(ts *table.Signature, config Config) *TableManager {
-	key := ts.GetKey()
+func (h *Holder) isPersistEnabled() bool {
+	h.managersMut.Lock()
+	defer h.managersMut.Unlock()
+
+	return h.wal != nil
+}
 
+func (h *Holder) getTableManager(ts *table.Signature, config Config) (*TableManager, error) {
 	h.managersMut.Lock()
+	names, inserters, err := h.routeInsertersLocked(ts, config.Inserters)
+	if err != nil {
+		h.managersMut.Unlock()
+		return nil, err
+	}
+	key := managerKey(ts, names)
 	manager, ok := h.managers[key]
 	if !ok {
-		log.Printf("new table: %s", key)
-		manager = NewTableManager(ts, config, h.inserters, h.insertErrorLogger)
+		manager = NewTableManager(ts, config, inserters, h.insertErrorLogger)
+		manager.logger = h.logger.With("table", ts.GetTableName())
+		manager.logger.Info("new table manager", "key", key)
+		manager.wal = h.wal
+		manager.bufferedRows = metrics.BufferedRows.WithLabelValues(key)
+		manager.bufferTracker = h.bufferTracker
+		manager.spool = h.spool
+		manager.receipts = h.receipts
 		go manager.Run()
 		h.managers[key] = manager
+		metrics.ActiveTableManagers.Inc()
 	}
 	h.lastManagerVisit[key] = time.Now()
 	h.managersMut.Unlock()
@@ -74,7 +471,49 @@ func (h *Holder) getTableManager(ts *table.Signature, config Config) *TableManag
 		manager.UpdateConfig(config)
 	}
 
-	return manager
+	return manager, nil
+}
+
+//forgetManagerLocked removes manager by key and it's metrics.
+//Must be called under managersMut
+func (h *Holder) forgetManagerLocked(key string) {
+	delete(h.managers, key)
+	delete(h.lastManagerVisit, key)
+	deleteManagerMetrics(key)
+}
+
+//deleteManagerMetrics is called for a removed manager,
+//so label values of stopped managers don't pile up
+func deleteManagerMetrics(key string) {
+	metrics.ActiveTableManagers.Dec()
+	metrics.BufferedRows.DeleteLabelValues(key)
+}
+
+//managerKey identifies manager by table's key and it's inserters
+func managerKey(ts *table.Signature, inserterNames []string) string {
+	return ts.GetKey() + "|" + strings.Join(inserterNames, ",")
+}
+
+//GetBufferedBytes returns size of rows JSON waiting for insert
+//or being inserted by all table managers
+func (h *Holder) GetBufferedBytes() int64 {
+	return h.bufferTracker.getUsed()
+}
+
+//Ping pings inserters that implement inserter.Pinger.
+//Returns the first error
+func (h *Holder) Ping(ctx context.Context) error {
+	for name, ins := range h.getInserters() {
+		pinger, ok := ins.(inserter.Pinger)
+		if !ok {
+			continue
+		}
+		if err := pinger.Ping(ctx); err != nil {
+			return errors.Wrapf(err, "inserter %s", name)
+		}
+	}
+
+	return nil
 }
 
 //StopUnusedManagers starts a goroutine which stops unused
@@ -90,10 +529,9 @@ func (h *Holder) stopUnusedManagers() {
 		now := time.Now()
 		h.managersMut.Lock()
 		for key, lastVisited := range h.lastManagerVisit {
-			if now.Sub(lastVisited) > stopUnusedManagersInterval {
+			if now.Sub(lastVisited) > stopUnusedManagersInterval && !h.managers[key].IsPaused() {
 				unusedManagers = append(unusedManagers, h.managers[key])
-				delete(h.managers, key)
-				delete(h.lastManagerVisit, key)
+				h.forgetManagerLocked(key)
 			}
 		}
 		h.managersMut.Unlock()
@@ -108,12 +546,26 @@ func (h *Holder) stopUnusedManagers() {
 //If one of them didn't stop in time or has insert errors returns them.
 func (h *Holder) StopTableManagers() []error {
 	h.managersMut.Lock()
+	errs := stopManagers(h.managers)
+	for key := range h.managers {
+		deleteManagerMetrics(key)
+	}
+	h.managers = map[string]*TableManager{}
+	h.lastManagerVisit = map[string]time.Time{}
+	h.managersMut.Unlock()
+
+	return errs
+}
+
+//stopManagers stops managers concurrently with timeout
+func stopManagers(managers map[string]*TableManager) []error {
 	errs := []error{}
 	errChan := make(chan error)
-	for name, manager := range h.managers {
+	for name, manager := range managers {
 		go func(name string, manager *TableManager) {
 			timer := time.NewTimer(maxTableManagerStopTime)
-			stopChan := make(chan struct{})
+			//buffered, so the goroutine finishes even after timeout
+			stopChan := make(chan struct{}, 1)
 			go func(manager *TableManager, stopChan chan struct{}) {
 				manager.Stop()
 				stopChan <- struct{}{}
@@ -128,13 +580,12 @@ func (h *Holder) StopTableManagers() []error {
 			errChan <- err
 		}(name, manager)
 	}
-	for range h.managers {
+	for range managers {
 		err := <-errChan
 		if err != nil {
 			errs = append(errs, err)
 		}
 	}
-	h.managersMut.Unlock()
 
 	return errs
 }
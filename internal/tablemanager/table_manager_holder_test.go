@@ -1,14 +1,26 @@
 package tablemanager
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/metrics"
+	"github.com/edwvee/dbatcher/internal/spool"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/edwvee/dbatcher/internal/tracing"
+	"github.com/edwvee/dbatcher/internal/tracing/tracingtest"
+	"github.com/edwvee/dbatcher/internal/wal"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestNewTableManagerHolder(t *testing.T) {
@@ -35,11 +47,11 @@ func TestGetTableManager(t *testing.T) {
 	tmc := defaultTestTableManagerConfig
 	logger := inserter.NewInsertErrorLogger(nil, false)
 	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
-	tm := tmh.getTableManager(&defaultTestTableSignature, tmc)
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, tmc)
 	if tm == nil {
 		t.Error("got nil table manager")
 	}
-	key := defaultTestTableSignature.GetKey()
+	key := managerKey(&defaultTestTableSignature, []string{"dummy"})
 	if _, ok := tmh.managers[key]; !ok {
 		t.Errorf("table manager not present in map after return")
 	}
@@ -57,7 +69,7 @@ func TestGetTableManager(t *testing.T) {
 
 	tmc.TimeoutMs = 100
 	tmc.MaxRows = 1000
-	tmNew := tmh.getTableManager(&defaultTestTableSignature, tmc)
+	tmNew := mustGetTableManager(t, tmh, &defaultTestTableSignature, tmc)
 	if tm != tmNew {
 		t.Error("should be same table managers with same table signature")
 	}
@@ -70,7 +82,7 @@ func TestStopUnusedTableManagers(t *testing.T) {
 	tmc := defaultTestTableManagerConfig
 	logger := inserter.NewInsertErrorLogger(nil, false)
 	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
-	tmh.getTableManager(&defaultTestTableSignature, tmc)
+	mustGetTableManager(t, tmh, &defaultTestTableSignature, tmc)
 	if len(tmh.managers) == 0 {
 		t.Errorf("should present table manager in map")
 	}
@@ -97,7 +109,7 @@ func TestStopTableManagersPositive(t *testing.T) {
 	inserters := map[string]inserter.Inserter{"self slice inserter": si}
 	logger := inserter.NewInsertErrorLogger(nil, false)
 	tmh := NewHolder(defaultTestErrChan, inserters, logger)
-	tmh.getTableManager(&defaultTestTableSignature, tmc)
+	mustGetTableManager(t, tmh, &defaultTestTableSignature, tmc)
 
 	const managersSize = 10
 	for i := 0; i < managersSize; i++ {
@@ -106,7 +118,7 @@ func TestStopTableManagersPositive(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		tmh.getTableManager(&ts, tmc)
+		mustGetTableManager(t, tmh, &ts, tmc)
 	}
 	if errs := tmh.StopTableManagers(); len(errs) != 0 {
 		for _, err := range errs {
@@ -124,7 +136,7 @@ func TestStopTableManagersNegative(t *testing.T) {
 	inserters := map[string]inserter.Inserter{"first": &longSleepInserter{}, "second": &longSleepInserter{}}
 	logger := inserter.NewInsertErrorLogger(nil, false)
 	tmh := NewHolder(defaultTestErrChan, inserters, logger)
-	tmh.getTableManager(&defaultTestTableSignature, tmc)
+	mustGetTableManager(t, tmh, &defaultTestTableSignature, tmc)
 
 	const managersSize = 10
 	for i := 0; i < managersSize; i++ {
@@ -133,7 +145,7 @@ func TestStopTableManagersNegative(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		tmh.getTableManager(&ts, tmc)
+		mustGetTableManager(t, tmh, &ts, tmc)
 	}
 	errs := tmh.StopTableManagers()
 	if len(errs) != managersSize {
@@ -177,3 +189,680 @@ func TestTableManagerAppendSyncNegative(t *testing.T) {
 		t.Fatal("this is for coverage, i don't know what use could be here")
 	}
 }
+
+func TestHolderPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := wal.Open(wal.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmc := NewConfig(100000, 100, true)
+	ts := table.NewSignature("database.`table`", "field1")
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"error": &errorInserter{}}, logger)
+	if err := tmh.Append(&ts, tmc, false, []byte("[[1]]")); !errors.Is(err, ErrPersistNotConfigured) {
+		t.Fatalf("should be ErrPersistNotConfigured, got %v", err)
+	}
+	if err := tmh.EnablePersist(w); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tmh.Append(&ts, tmc, false, []byte("[[1],[2]]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//decoded rows are written to segment as JSON
+	decoded := table.NewValueRows([][]interface{}{{json.Number("3")}}, 4)
+	if err := tmh.AppendRows(&ts, tmc, false, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmh.Append(&ts, tmc, false, []byte("[[1,2]]")); err == nil {
+		t.Fatal("invalid rows shouldn't be appended")
+	}
+	//insert fails and error log is disabled, so segment should stay for replay
+	tmh.StopTableManagers()
+	paths, err := w.SegmentPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("should be 1 segment after failed insert, got %d", len(paths))
+	}
+
+	si := &selfSliceInserter{}
+	si.Init(inserter.Config{})
+	tmh = NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"self slice inserter": si}, logger)
+	if err := tmh.EnablePersist(w); err != nil {
+		t.Fatal(err)
+	}
+	if errs := tmh.StopTableManagers(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if data := si.TakeSlice(); len(data) != 7 {
+		t.Errorf("should replay 7 rows, got %d", len(data))
+	}
+	if paths, _ := w.SegmentPaths(); len(paths) != 0 {
+		t.Errorf("segments should be removed after insert, got %v", paths)
+	}
+}
+
+func TestHolderPersistReplayError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := wal.Open(wal.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	segment, err := w.NewSegment(wal.Header{Table: "database.`table`", Fields: "field1", TimeoutMs: 100000, MaxRows: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{"[[1]]", "[[1,2]]"} {
+		if err := segment.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segment.Close()
+
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"self slice inserter": &selfSliceInserter{}}, logger)
+	defer tmh.StopTableManagers()
+	if err := tmh.EnablePersist(w); err == nil {
+		t.Fatal("should fail on the record that can't be replayed")
+	}
+	if _, err := os.Stat(segment.Path()); err != nil {
+		t.Errorf("segment with not replayed record should be kept: %s", err)
+	}
+}
+
+func TestHolderPersistFailedInserters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := wal.Open(wal.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmc := NewConfig(100000, 100, true)
+	ts := table.NewSignature("database.`table`", "field1")
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{
+		"ok":    &selfSliceInserter{},
+		"error": &errorInserter{},
+	}, logger)
+	if err := tmh.EnablePersist(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmh.Append(&ts, tmc, false, []byte("[[1],[2]]")); err != nil {
+		t.Fatal(err)
+	}
+	tmh.StopTableManagers()
+	paths, err := w.SegmentPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("should be 1 segment after failed insert, got %d", len(paths))
+	}
+	header, _, err := wal.ReadSegment(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(header.FailedInserters, []string{"error"}) {
+		t.Errorf("segment should be kept only for the failed inserter, got %v", header.FailedInserters)
+	}
+
+	ok, failed := &selfSliceInserter{}, &selfSliceInserter{}
+	tmh = NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"ok": ok, "error": failed}, logger)
+	if err := tmh.EnablePersist(w); err != nil {
+		t.Fatal(err)
+	}
+	if errs := tmh.StopTableManagers(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if data := ok.TakeSlice(); len(data) != 0 {
+		t.Errorf("inserter that inserted the rows shouldn't get them again, got %d", len(data))
+	}
+	if data := failed.TakeSlice(); len(data) != 2 {
+		t.Errorf("failed inserter should get 2 rows, got %d", len(data))
+	}
+	if paths, _ := w.SegmentPaths(); len(paths) != 0 {
+		t.Errorf("segments should be removed after insert, got %v", paths)
+	}
+}
+
+func TestHolderGetBufferedBytes(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
+	defer tmh.StopTableManagers()
+	rowsJSON := []byte("[[1,2,3],[4,5,6]]")
+	for i := 0; i < 3; i++ {
+		if err := tmh.Append(&defaultTestTableSignature, defaultTestTableManagerConfig, false, rowsJSON); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := table.NewSignature("another", "field1")
+	if err := tmh.Append(&ts, defaultTestTableManagerConfig, false, []byte("[[1]]")); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := tmh.GetBufferedBytes(), int64(3*len(rowsJSON)+5); got != expected {
+		t.Errorf("buffered bytes should be %d, got %d", expected, got)
+	}
+
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, defaultTestTableManagerConfig)
+	if err := tm.DoInsert(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tmh.GetBufferedBytes(); got != 5 {
+		t.Errorf("buffered bytes should be 5 after insert, got %d", got)
+	}
+
+	//sync rows are released right after their insert
+	if err := tmh.Append(&defaultTestTableSignature, Config{}, true, rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	if got := tmh.GetBufferedBytes(); got != 5 {
+		t.Errorf("buffered bytes should be 5 after sync insert, got %d", got)
+	}
+}
+
+func TestHolderPing(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	pinger := &pingInserter{}
+	inserters := map[string]inserter.Inserter{
+		"dummy":  &inserter.DummyInserter{},
+		"pinger": pinger,
+	}
+	tmh := NewHolder(defaultTestErrChan, inserters, logger)
+	if err := tmh.Ping(context.Background()); err != nil {
+		t.Errorf("shouldn't return error: %s", err)
+	}
+	pinger.pingErr = errors.New("connection refused")
+	err := tmh.Ping(context.Background())
+	if err == nil {
+		t.Fatal("should return error")
+	}
+	if !errors.Is(err, pinger.pingErr) {
+		t.Errorf("should wrap ping error, got %s", err)
+	}
+}
+
+func TestHolderReplaceInserters(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	oldIns := &selfSliceInserter{}
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"old": oldIns}, logger)
+	defer tmh.StopTableManagers()
+	rowsJSON := []byte("[[1,2,3]]")
+	if err := tmh.Append(&defaultTestTableSignature, defaultTestTableManagerConfig, false, rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	oldManager := mustGetTableManager(t, tmh, &defaultTestTableSignature, defaultTestTableManagerConfig)
+
+	newIns := &selfSliceInserter{}
+	if stopping, errs := tmh.ReplaceInserters(map[string]inserter.Inserter{"new": newIns}, nil); len(stopping) != 0 || len(errs) != 0 {
+		t.Fatalf("shouldn't return stopping managers or errors: %v, %v", stopping, errs)
+	}
+	if data := oldIns.TakeSlice(); len(data) != 1 {
+		t.Errorf("rows accepted before replace should be inserted by old inserter, got %d rows", len(data))
+	}
+	if len(tmh.managers) != 0 {
+		t.Errorf("old managers should be removed, got %d", len(tmh.managers))
+	}
+
+	//append to a manager taken before replace should go to a new manager
+	if err := oldManager.AppendRowsToTable(rowsJSON); err != ErrTableManagerStopped {
+		t.Errorf("stopped manager should return ErrTableManagerStopped, got %v", err)
+	}
+	if err := tmh.Append(&defaultTestTableSignature, defaultTestTableManagerConfig, false, rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	newManager := mustGetTableManager(t, tmh, &defaultTestTableSignature, defaultTestTableManagerConfig)
+	if newManager == oldManager {
+		t.Fatal("should be a new manager after replace")
+	}
+	if err := newManager.DoInsert(); err != nil {
+		t.Fatal(err)
+	}
+	if data := newIns.TakeSlice(); len(data) != 1 {
+		t.Errorf("rows accepted after replace should be inserted by new inserter, got %d rows", len(data))
+	}
+	if data := oldIns.TakeSlice(); len(data) != 0 {
+		t.Errorf("old inserter shouldn't get rows after replace, got %d rows", len(data))
+	}
+}
+
+func TestHolderReplaceInsertersStopping(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	oldIns := &blockingInserter{release: make(chan struct{})}
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"old": oldIns}, logger)
+	defer tmh.StopTableManagers()
+	if err := tmh.Append(&defaultTestTableSignature, defaultTestTableManagerConfig, false, []byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+
+	newIns := &selfSliceInserter{}
+	stopping, errs := tmh.ReplaceInserters(map[string]inserter.Inserter{"new": newIns}, nil)
+	if len(stopping) != 1 || len(errs) != 1 || !errors.Is(errs[0], ErrTableManagerDidntStopInTime) {
+		t.Fatalf("should be 1 stopping manager and ErrTableManagerDidntStopInTime, got %v, %v", stopping, errs)
+	}
+	manager := stopping[0]
+	if !manager.UsesInserter(oldIns) || manager.UsesInserter(newIns) {
+		t.Error("stopping manager should use only the old inserter")
+	}
+	select {
+	case <-manager.Done():
+		t.Fatal("manager shouldn't be done while inserting")
+	default:
+	}
+	close(oldIns.release)
+	select {
+	case <-manager.Done():
+	case <-time.After(time.Second):
+		t.Fatal("manager should be done after insert")
+	}
+}
+
+func TestHolderRouting(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	clickhouse, mysql := &selfSliceInserter{}, &selfSliceInserter{}
+	inserters := map[string]inserter.Inserter{"clickhouse": clickhouse, "mysql": mysql}
+	tmh := NewHolder(defaultTestErrChan, inserters, logger)
+	router, err := NewRouter([]RouteConfig{
+		{Database: "analytics", Inserters: []string{"clickhouse", "mysql"}},
+		{Table: "ops.users", Inserters: []string{"mysql"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmh.SetRouter(router)
+
+	events := table.NewSignature("analytics.events", "field1")
+	users := table.NewSignature("ops.users", "field1")
+	other := table.NewSignature("ops.other", "field1")
+	config := defaultTestTableManagerConfig
+	for _, ts := range []*table.Signature{&events, &users} {
+		if err := tmh.Append(ts, config, false, []byte("[[1]]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tmh.Append(&other, config, false, []byte("[[1]]")); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("unrouted table should be rejected with ErrNoRoute, got %v", err)
+	}
+	config.Inserters = []string{"clickhouse"}
+	if err := tmh.Append(&events, config, false, []byte("[[1]]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmh.Append(&users, config, false, []byte("[[1]]")); !errors.Is(err, ErrInserterNotRouted) {
+		t.Errorf("not routed inserter should be rejected with ErrInserterNotRouted, got %v", err)
+	}
+	if len(tmh.managers) != 3 {
+		t.Errorf("should be 3 managers (events to both, events to clickhouse, users), got %d", len(tmh.managers))
+	}
+
+	if errs := tmh.StopTableManagers(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if data := clickhouse.TakeSlice(); len(data) != 2 {
+		t.Errorf("clickhouse should get 2 rows of analytics.events, got %d", len(data))
+	}
+	if data := mysql.TakeSlice(); len(data) != 2 {
+		t.Errorf("mysql should get rows of analytics.events and ops.users, got %d", len(data))
+	}
+}
+
+func TestHolderTablePolicies(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
+	defer tmh.StopTableManagers()
+	tmh.SetTablePolicies(map[string]TablePolicy{
+		"db.table": {TimeoutMs: 60000, MaxRows: 500, LockTimeoutMs: true, LockMaxRows: true},
+	})
+	rowsJSON := []byte("[[1,2,3]]")
+	//defaults for absent values
+	if err := tmh.Append(&defaultTestTableSignature, Config{}, false, rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	//locked values aren't changed by a request
+	if err := tmh.Append(&defaultTestTableSignature, NewConfig(10, 1000, false), false, rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	tm := tmh.managers[managerKey(&defaultTestTableSignature, []string{"dummy"})]
+	if tm.timeoutMs != 60000 || tm.maxRows != 500 {
+		t.Errorf("manager should have policy values, got timeout %d, max rows %d", tm.timeoutMs, tm.maxRows)
+	}
+
+	//tables without policy should have values in request
+	ts := table.NewSignature("db.other", "field1")
+	if err := tmh.Append(&ts, Config{}, false, []byte("[[1]]")); err != ErrZeroTimeoutMs {
+		t.Errorf("should return ErrZeroTimeoutMs, got %v", err)
+	}
+
+	//policy is applied to sync requests too
+	tmh.SetTablePolicies(map[string]TablePolicy{"db.table": {Persist: true}})
+	if err := tmh.Append(&defaultTestTableSignature, Config{}, true, rowsJSON); err != ErrPersistNotConfigured {
+		t.Errorf("should return ErrPersistNotConfigured, got %v", err)
+	}
+}
+
+func TestHolderSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	okInserter := &selfSliceInserter{}
+	inserters := map[string]inserter.Inserter{
+		"ok":  okInserter,
+		"bad": &errorInserter{},
+	}
+	s, err := spool.Open(spool.Config{Dir: dir}, inserters, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmh := NewHolder(defaultTestErrChan, inserters, logger)
+	tmh.EnableSpool(s)
+	defer tmh.StopTableManagers()
+	config := NewConfig(100000000, 100000, false)
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, config)
+
+	//failed batch is spooled
+	id, err := tmh.AppendRowsWithReceipt(context.Background(), &defaultTestTableSignature, config, false, table.NewJSONRows([]byte("[[1,2,3]]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.DoInsert(); err != nil {
+		t.Fatalf("spooled batch shouldn't be an error, got %s", err)
+	}
+	if rows := okInserter.TakeSlice(); len(rows) != 1 {
+		t.Errorf("working inserter should insert the row, got %v", rows)
+	}
+	if !s.HasPending("bad", defaultTestTableSignature) {
+		t.Fatal("batch should be spooled for failed inserter")
+	}
+	receipt, _ := tmh.GetReceipt(id)
+	expected := map[string]InserterReceipt{"ok": {Status: ReceiptCommitted}, "bad": {Status: ReceiptSpooled}}
+	if receipt.Status != ReceiptPending || !reflect.DeepEqual(receipt.Inserters, expected) || receipt.FinishedAt != nil {
+		t.Errorf("receipt should be pending with spooled inserter, got %v", receipt)
+	}
+
+	//the next batch is queued after spooled one without insert
+	recovered := &selfSliceInserter{}
+	s.SetInserters(map[string]inserter.Inserter{"ok": okInserter, "bad": recovered})
+	result, err := tmh.appendRows(context.Background(), &defaultTestTableSignature, config, table.NewJSONRows([]byte("[[4,5,6]]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.DoInsert(); err != nil {
+		t.Fatalf("queued batch shouldn't be an error, got %s", err)
+	}
+	if status := s.Status(); status.Batches != 2 {
+		t.Fatalf("2 batches should be spooled, got %+v", status)
+	}
+	select {
+	case <-result.done:
+		t.Fatalf("result of spooled batch shouldn't be done, got %v", result.err)
+	default:
+	}
+
+	s.Retry()
+	rows := recovered.TakeSlice()
+	if len(rows) != 2 {
+		t.Fatalf("2 spooled rows should be inserted, got %v", rows)
+	}
+	if fmt.Sprint(rows[0]) != "[1 2 3]" || fmt.Sprint(rows[1]) != "[4 5 6]" {
+		t.Errorf("spooled rows should be inserted in order, got %v", rows)
+	}
+	receipt, _ = tmh.GetReceipt(id)
+	expected = map[string]InserterReceipt{"ok": {Status: ReceiptCommitted}, "bad": {Status: ReceiptCommitted}}
+	if receipt.Status != ReceiptCommitted || !reflect.DeepEqual(receipt.Inserters, expected) || receipt.FinishedAt == nil {
+		t.Errorf("receipt should be committed after retry, got %v", receipt)
+	}
+	select {
+	case <-result.done:
+		if result.err != nil {
+			t.Errorf("result of inserted spooled batch should be without error, got %s", result.err)
+		}
+	default:
+		t.Error("result should be done after retry")
+	}
+}
+
+func TestHolderAppendRowsAndWait(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	good := &selfSliceInserter{}
+	inserters := map[string]inserter.Inserter{"good": good, "bad": &errorInserter{}}
+	tmh := NewHolder(defaultTestErrChan, inserters, logger)
+	defer tmh.StopTableManagers()
+	ts := table.NewSignature("database.`table`", "field1")
+	rows := func() table.Rows { return table.NewJSONRows([]byte("[[1],[2]]")) }
+
+	config := NewConfig(10, 100, false)
+	config.Inserters = []string{"good"}
+	if _, err := tmh.AppendRowsAndWait(context.Background(), &ts, config, rows()); err != nil {
+		t.Fatal(err)
+	}
+	if data := good.TakeSlice(); len(data) != 2 {
+		t.Errorf("rows should be inserted before return, got %d rows", len(data))
+	}
+
+	config.Inserters = nil
+	id, err := tmh.AppendRowsAndWait(context.Background(), &ts, config, rows())
+	var insertErr InsertError
+	if !errors.As(err, &insertErr) {
+		t.Fatalf("should return InsertError, got %v", err)
+	}
+	if failed := insertErr.FailedInserters(); !reflect.DeepEqual(failed, []string{"bad"}) {
+		t.Errorf("only bad inserter should fail, got %v", failed)
+	}
+	if receipt, ok := tmh.GetReceipt(id); !ok || receipt.Status != ReceiptFailed {
+		t.Errorf("receipt %q should be failed, got %v", id, receipt)
+	}
+
+	//a new table, so only ctx could finish first: manager of the table
+	//above still has a timer of it's 10ms batch timeout
+	otherTs := table.NewSignature("database.other_table", "field1")
+	config = NewConfig(100000, 100, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tmh.AppendRowsAndWait(ctx, &otherTs, config, rows()); !errors.Is(err, ErrWaitTimeout) {
+		t.Errorf("should return ErrWaitTimeout, got %v", err)
+	}
+}
+
+func TestHolderReceipts(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tmh := NewHolder(defaultTestErrChan, defaultTestInserters, logger)
+	defer tmh.StopTableManagers()
+	if err := tmh.SetReceiptsConfig(ReceiptsConfig{RetentionMs: -1}); err != ErrInvalidReceiptsConfig {
+		t.Errorf("should return ErrInvalidReceiptsConfig, got %v", err)
+	}
+	rowsJSON := []byte("[[1,2,3]]")
+	ids := make([]string, 2)
+	for i := range ids {
+		id, err := tmh.AppendRowsWithReceipt(context.Background(), &defaultTestTableSignature, defaultTestTableManagerConfig, false, table.NewJSONRows(rowsJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("rows of the same batch should have the same receipt, got %v", ids)
+	}
+	receipt, ok := tmh.GetReceipt(ids[0])
+	if !ok {
+		t.Fatal("should be a receipt")
+	}
+	expected := map[string]InserterReceipt{"dummy": {Status: ReceiptPending}}
+	if receipt.Status != ReceiptPending || !reflect.DeepEqual(receipt.Inserters, expected) {
+		t.Errorf("receipt should be pending, got %v", receipt)
+	}
+
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, defaultTestTableManagerConfig)
+	if err := tm.DoInsert(); err != nil {
+		t.Fatal(err)
+	}
+	receipt, _ = tmh.GetReceipt(ids[0])
+	expected = map[string]InserterReceipt{"dummy": {Status: ReceiptCommitted}}
+	if receipt.Status != ReceiptCommitted || !reflect.DeepEqual(receipt.Inserters, expected) || receipt.FinishedAt == nil {
+		t.Errorf("receipt should be committed, got %v", receipt)
+	}
+	id, err := tmh.AppendRowsWithReceipt(context.Background(), &defaultTestTableSignature, defaultTestTableManagerConfig, false, table.NewJSONRows(rowsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == ids[0] {
+		t.Error("rows of the next batch should have another receipt")
+	}
+	if id, _ := tmh.AppendRowsWithReceipt(context.Background(), &defaultTestTableSignature, defaultTestTableManagerConfig, true, table.NewJSONRows(rowsJSON)); id != "" {
+		t.Errorf("sync rows shouldn't have receipt, got %q", id)
+	}
+}
+
+func TestHolderTableControl(t *testing.T) {
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	si := &selfSliceInserter{}
+	tmh := NewHolder(defaultTestErrChan, map[string]inserter.Inserter{"self": si}, logger)
+	defer tmh.StopTableManagers()
+	ts := table.NewSignature("db.table", "field1")
+	config := NewConfig(60000, 100, false)
+	if err := tmh.Append(&ts, config, false, []byte("[[1],[2]]")); err != nil {
+		t.Fatal(err)
+	}
+	infos := tmh.ListTables()
+	if len(infos) != 1 {
+		t.Fatalf("should be 1 table, got %v", infos)
+	}
+	info := infos[0]
+	if info.Key != "db.table|field1|self" || info.Table != "db.table" || info.BufferedRows != 2 || info.MaxRows != 100 || info.LastVisit.IsZero() {
+		t.Errorf("unexpected table info %+v", info)
+	}
+	for _, action := range []func(string) error{tmh.FlushTable, tmh.PauseTable, tmh.ResumeTable, tmh.StopTable} {
+		if err := action("unknown"); !errors.Is(err, ErrTableManagerNotFound) {
+			t.Errorf("should be ErrTableManagerNotFound, got %v", err)
+		}
+	}
+
+	if err := tmh.PauseTable(info.Key); err != nil {
+		t.Fatal(err)
+	}
+	//paused manager isn't flushed because of full buffer
+	tmh.flushLargestManagers(1)
+	time.Sleep(10 * time.Millisecond)
+	if data := si.TakeSlice(); len(data) != 0 {
+		t.Errorf("paused table shouldn't be inserted, got %v", data)
+	}
+	if err := tmh.FlushTable(info.Key); err != nil {
+		t.Fatal(err)
+	}
+	if data := si.TakeSlice(); len(data) != 2 {
+		t.Errorf("paused table should be inserted by FlushTable, got %v", data)
+	}
+	if err := tmh.Append(&ts, config, false, []byte("[[3]]")); err != nil {
+		t.Fatal(err)
+	}
+	if errs := tmh.FlushTables(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if data := si.TakeSlice(); len(data) != 1 {
+		t.Errorf("table should be inserted by FlushTables, got %v", data)
+	}
+	if err := tmh.Append(&ts, config, false, []byte("[[4]]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmh.StopTable(info.Key); err != nil {
+		t.Fatal(err)
+	}
+	if data := si.TakeSlice(); len(data) != 1 {
+		t.Errorf("stopped table should be inserted, got %v", data)
+	}
+	if infos := tmh.ListTables(); len(infos) != 0 {
+		t.Errorf("stopped table shouldn't be listed, got %v", infos)
+	}
+	if metrics.BufferedRows.DeleteLabelValues(info.Key) {
+		t.Error("buffered rows metric of stopped table should be deleted")
+	}
+}
+
+func TestHolderTracing(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+
+	inserters := map[string]inserter.Inserter{"trace failing": &errorInserter{}, "trace dummy": &inserter.DummyInserter{}}
+	tmh := NewHolder(defaultTestErrChan, inserters, inserter.NewInsertErrorLogger(nil, false))
+	requestTraces := map[trace.TraceID]bool{}
+	for i := 0; i < 2; i++ {
+		ctx, span := tracing.Start(context.Background(), "request")
+		requestTraces[span.SpanContext().TraceID()] = true
+		_, err := tmh.AppendRowsWithReceipt(ctx, &defaultTestTableSignature, defaultTestTableManagerConfig, false, table.NewJSONRows([]byte("[[1,2,3]]")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		span.End()
+	}
+	tm := mustGetTableManager(t, tmh, &defaultTestTableSignature, defaultTestTableManagerConfig)
+	if _, ok := tm.DoInsert().(InsertError); !ok {
+		t.Fatal("should return InsertError")
+	}
+	tmh.StopTableManagers()
+
+	byName := map[string][]sdktrace.ReadOnlySpan{}
+	byID := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = append(byName[span.Name()], span)
+		byID[span.SpanContext().SpanID()] = span
+	}
+	for name, count := range map[string]int{"request": 2, "append": 2, "buffer.acquire": 2, "table.append": 2, "table.insert": 1, "inserter.insert": 2} {
+		if len(byName[name]) != count {
+			t.Fatalf("expected %d %s spans, got %d: %v", count, name, len(byName[name]), byName)
+		}
+	}
+	for _, span := range byName["table.append"] {
+		parent := byID[span.Parent().SpanID()]
+		if parent == nil || parent.Name() != "append" || byID[parent.Parent().SpanID()] == nil ||
+			byID[parent.Parent().SpanID()].Name() != "request" || !requestTraces[span.SpanContext().TraceID()] {
+			t.Errorf("table.append should be a child of append of request: %+v", span)
+		}
+		attributes := tracingtest.Attributes(span)
+		if attributes["rows"] != int64(1) || attributes["batch.id"] == "" {
+			t.Errorf("wrong table.append attributes: %v", attributes)
+		}
+	}
+	insert := byName["table.insert"][0]
+	if insert.Parent().IsValid() || requestTraces[insert.SpanContext().TraceID()] || len(insert.Links()) != 2 {
+		t.Fatalf("table.insert should be a root linked to 2 appends: %+v", insert)
+	}
+	for _, link := range insert.Links() {
+		linked := byID[link.SpanContext.SpanID()]
+		if linked == nil || linked.Name() != "table.append" || linked.SpanContext().TraceID() != link.SpanContext.TraceID() {
+			t.Errorf("link should point to table.append: %+v", link)
+		}
+	}
+	attributes := tracingtest.Attributes(insert)
+	if attributes["rows"] != int64(2) || attributes["trigger"] != "manual" || insert.Status().Code != codes.Error {
+		t.Errorf("wrong table.insert: %v, %v", attributes, insert.Status())
+	}
+	if _, ok := attributes["buffer.wait_ms"].(float64); !ok {
+		t.Errorf("table.insert should have buffer.wait_ms: %v", attributes)
+	}
+	for _, span := range byName["inserter.insert"] {
+		if span.Parent().SpanID() != insert.SpanContext().SpanID() || span.SpanContext().TraceID() != insert.SpanContext().TraceID() {
+			t.Errorf("inserter.insert should be a child of table.insert: %+v", span)
+		}
+		attributes := tracingtest.Attributes(span)
+		failed := attributes["inserter"] == "trace failing"
+		if failed != (span.Status().Code == codes.Error) || attributes["rows"] != int64(2) {
+			t.Errorf("wrong inserter.insert: %v, %v", attributes, span.Status())
+		}
+	}
+}
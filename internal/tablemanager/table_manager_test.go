@@ -1,6 +1,11 @@
 package tablemanager
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -8,7 +13,10 @@ import (
 	"time"
 
 	"github.com/edwvee/dbatcher/internal/inserter"
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/edwvee/dbatcher/internal/metrics"
 	"github.com/edwvee/dbatcher/internal/table"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestNewTableManager(t *testing.T) {
@@ -25,6 +33,7 @@ func TestNewTableManager(t *testing.T) {
 		maxRows:           int64(defaultTestTableManagerConfig.MaxRows),
 		timeoutMs:         defaultTestTableManagerConfig.TimeoutMs,
 		sendChannel:       make(chan struct{}, 1),
+		flushChannel:      make(chan struct{}, 1),
 		stopChannel:       make(chan struct{}),
 	}
 	if !reflect.DeepEqual(*tm.table, *tmExpected.table) {
@@ -37,11 +46,28 @@ func TestNewTableManager(t *testing.T) {
 	}
 	tm.sendChannel = nil
 	tmExpected.sendChannel = nil
+	if tm.flushChannel == nil {
+		t.Fatal("table manager got nil flushChannel")
+	}
+	tm.flushChannel = nil
+	tmExpected.flushChannel = nil
 	if tm.stopChannel == nil {
 		t.Fatal("table manager got nil stopChannel")
 	}
 	tm.stopChannel = nil
 	tmExpected.stopChannel = nil
+	if tm.doneChannel == nil {
+		t.Fatal("table manager got nil doneChannel")
+	}
+	tm.doneChannel = nil
+	if tm.result == nil {
+		t.Fatal("table manager got nil result")
+	}
+	tm.result = nil
+	if tm.logger == nil {
+		t.Fatal("table manager got nil logger")
+	}
+	tm.logger = nil
 	if !reflect.DeepEqual(tm, tmExpected) {
 		t.Fatalf("want %v, got %v", tm, tmExpected)
 	}
@@ -70,26 +96,90 @@ func TestShouldAppendWhenTooMuchRows(t *testing.T) {
 	}
 }
 
+func TestShouldInsertWhenTooMuchBytes(t *testing.T) {
+	rowsJSON := []byte("[[1,2,3]]")
+	tmc := NewConfig(100000000, 1000000, false)
+	tmc.MaxBytes = int64(10 * len(rowsJSON))
+	si := &selfSliceInserter{}
+	si.Init(inserter.Config{})
+	inserters := map[string]inserter.Inserter{"self slice inserter": si}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
+	go tm.Run()
+	defer tm.Stop()
+	flushes := testutil.ToFloat64(metrics.Flushes.WithLabelValues(metrics.FlushTriggerMaxBytes))
+	for i := 0; i < 9; i++ {
+		if err := tm.AppendRowsToTable(rowsJSON); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if data := si.TakeSlice(); len(data) != 0 {
+		t.Fatalf("shouldn't insert before max bytes, got %d rows", len(data))
+	}
+	if err := tm.AppendRowsToTable(rowsJSON); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if data := si.TakeSlice(); len(data) != 10 {
+		t.Fatalf("should insert 10 rows after max bytes, got %d", len(data))
+	}
+	if got := testutil.ToFloat64(metrics.Flushes.WithLabelValues(metrics.FlushTriggerMaxBytes)); got != flushes+1 {
+		t.Errorf("should count max_bytes flush, got %v", got-flushes)
+	}
+}
+
 func TestShouldReturnMultiError(t *testing.T) {
 	tmc := NewConfig(1000, 100, false)
-	inserters := map[string]inserter.Inserter{"1": &errorInserter{}, "2": &errorInserter{}}
+	inserters := map[string]inserter.Inserter{"1": &errorInserter{}, "2": &errorInserter{}, "3": &inserter.DummyInserter{}}
 	logger := inserter.NewInsertErrorLogger(nil, false)
 	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
 	err := tm.AppendRowsToTable([]byte("[[1,2,3]]"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = tm.insertConcurrently(tm.getTableAndMakeNew())
+	err = tm.insertConcurrently(context.Background(), tm.inserters, tm.getTableAndMakeNew())
 	if err == nil {
 		t.Fatal("err should be not nil")
 	}
 	errMessage := err.Error()
-	if !strings.Contains(errMessage, "some error") {
-		t.Fatal("should contain \"some error\"")
+	if want := "1: some error,2: some error"; errMessage != want {
+		t.Fatalf("want error %q, got %q", want, errMessage)
+	}
+	insertErr, ok := err.(InsertError)
+	if !ok {
+		t.Fatalf("should be InsertError, got %T", err)
+	}
+	if failed := insertErr.FailedInserters(); !reflect.DeepEqual(failed, []string{"1", "2"}) {
+		t.Errorf("only 1 and 2 should fail, got %v", failed)
+	}
+}
+
+func TestShouldLogOnlyFailedInserters(t *testing.T) {
+	tmc := NewConfig(1000, 100, false)
+	si := &selfSliceInserter{}
+	si.Init(inserter.Config{})
+	inserters := map[string]inserter.Inserter{"failing": &errorInserter{}, "succeeding": si}
+	buf := &bytes.Buffer{}
+	logger := inserter.NewInsertErrorLogger(buf, false)
+	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
+	if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.DoInsert(); err == nil {
+		t.Fatal("err should be not nil")
 	}
-	errMessage = strings.Replace(errMessage, "some error", "", 1)
-	if !strings.Contains(errMessage, "some error") {
-		t.Fatal("should contain two \"some error\"")
+	if data := si.TakeSlice(); len(data) != 1 {
+		t.Errorf("succeeding inserter should insert 1 row, got %d", len(data))
+	}
+	var record struct {
+		Inserters []string `json:"inserters"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record.Inserters, []string{"failing"}) {
+		t.Errorf("error log should name only failing inserter, got %v", record.Inserters)
 	}
 }
 
@@ -124,3 +214,143 @@ func TestMultiInserters(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendWhileRetrying(t *testing.T) {
+	tmc := NewConfig(1000, 100, false)
+	ri := inserter.NewRetryInserter(&errorInserter{})
+	ri.Init(inserter.Config{MaxRetries: 3, RetryInitialBackoffMs: 200})
+	inserters := map[string]inserter.Inserter{"retry": ri}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
+	if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+	insertDone := make(chan error)
+	go func() {
+		insertDone <- tm.DoInsert()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+	if passed := time.Since(start); passed > 50*time.Millisecond {
+		t.Errorf("append shouldn't wait for retries, took %s", passed)
+	}
+	if err := <-insertDone; err == nil {
+		t.Error("insert should fail after retries")
+	}
+	if rowsLen := tm.table.GetRowsLen(); rowsLen != 1 {
+		t.Errorf("new table should have 1 row, got %d", rowsLen)
+	}
+}
+
+func TestInsertMetrics(t *testing.T) {
+	const maxRows = 2
+	tmc := NewConfig(100000000, maxRows, false)
+	inserters := map[string]inserter.Inserter{"metrics failing": &errorInserter{}, "metrics dummy": &inserter.DummyInserter{}}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
+	tm.bufferedRows = metrics.BufferedRows.WithLabelValues("metrics test")
+	flushesBefore := testutil.ToFloat64(metrics.Flushes.WithLabelValues(metrics.FlushTriggerMaxRows))
+	go tm.Run()
+	if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+	if rows := testutil.ToFloat64(tm.bufferedRows); rows != 1 {
+		t.Errorf("should be 1 buffered row, got %f", rows)
+	}
+	if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if rows := testutil.ToFloat64(tm.bufferedRows); rows != 0 {
+		t.Errorf("should be 0 buffered rows after insert, got %f", rows)
+	}
+	flushes := testutil.ToFloat64(metrics.Flushes.WithLabelValues(metrics.FlushTriggerMaxRows))
+	if flushes-flushesBefore != 1 {
+		t.Errorf("should be 1 flush by max rows, got %f", flushes-flushesBefore)
+	}
+	if errs := testutil.ToFloat64(metrics.InsertErrors.WithLabelValues("metrics failing")); errs != 1 {
+		t.Errorf("should be 1 insert error, got %f", errs)
+	}
+	if errs := testutil.ToFloat64(metrics.InsertErrors.WithLabelValues("metrics dummy")); errs != 0 {
+		t.Errorf("shouldn't be insert errors, got %f", errs)
+	}
+	tm.Stop()
+}
+
+func TestInsertLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	logging.SetFormat(logging.FormatJSON)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		logging.SetFormat(logging.FormatText)
+	}()
+
+	inserters := map[string]inserter.Inserter{"log failing": &errorInserter{}, "log slice": &selfSliceInserter{}}
+	h := NewHolder(nil, inserters, inserter.NewInsertErrorLogger(nil, false))
+	h.SetLogger(logging.With("service", "test"))
+	err := h.Append(&defaultTestTableSignature, defaultTestTableManagerConfig, true, []byte("[[1,2,3],[4,5,6]]"))
+	if _, ok := err.(InsertError); !ok {
+		t.Fatalf("should return InsertError, got %v", err)
+	}
+
+	entries := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line should be JSON: %s, %s", err, line)
+		}
+		if inserterName, ok := entry["inserter"].(string); ok {
+			entries[inserterName] = entry
+		}
+	}
+	for name, msg := range map[string]string{"log slice": "inserted", "log failing": "insert failed"} {
+		entry := entries[name]
+		if entry["msg"] != msg || entry["table"] != defaultTestTableSignature.GetTableName() ||
+			entry["service"] != "test" || entry["rows"] != float64(2) {
+			t.Errorf("%s: wrong entry %v", name, entry)
+		}
+		if _, ok := entry["duration_ms"].(float64); !ok {
+			t.Errorf("%s: entry should have duration_ms, got %v", name, entry)
+		}
+	}
+	if entries["log failing"]["error"] == nil {
+		t.Errorf("failed insert entry should have error: %v", entries["log failing"])
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	tmc := NewConfig(10, 2, false)
+	si := &selfSliceInserter{}
+	si.Init(inserter.Config{})
+	inserters := map[string]inserter.Inserter{"self slice inserter": si}
+	logger := inserter.NewInsertErrorLogger(nil, false)
+	tm := NewTableManager(&defaultTestTableSignature, tmc, inserters, logger)
+	go tm.Run()
+	defer tm.Stop()
+	tm.Pause()
+	for i := 0; i < 3; i++ {
+		if err := tm.AppendRowsToTable([]byte("[[1,2,3]]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if data := si.TakeSlice(); len(data) != 0 {
+		t.Fatalf("paused manager shouldn't insert on timeout or max rows, got %d rows", len(data))
+	}
+	if lastInsert, _ := tm.GetLastInsert(); !lastInsert.IsZero() {
+		t.Errorf("there shouldn't be last insert, got %s", lastInsert)
+	}
+
+	tm.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if data := si.TakeSlice(); len(data) != 3 {
+		t.Fatalf("resumed manager should insert rows, got %d rows", len(data))
+	}
+	if lastInsert, err := tm.GetLastInsert(); lastInsert.IsZero() || err != nil {
+		t.Errorf("last insert should be successful, got %s, %v", lastInsert, err)
+	}
+}
@@ -0,0 +1,60 @@
+package tablemanager
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	//ErrNegativePolicyValue means table policy has a negative value
+	ErrNegativePolicyValue = errors.New("table policy values couldn't be negative")
+	//ErrLockedPolicyValueNotSet means table policy locks a value it doesn't have
+	ErrLockedPolicyValueNotSet = errors.New("locked table policy value isn't set")
+)
+
+//TablePolicy is server side batching config of a table. Set values
+//are defaults for requests which don't have them. A value is used
+//for all requests if it's lock is true. Persist is never disabled: it's
+//enabled by policy or by request, so durability requested by a client is kept
+type TablePolicy struct {
+	TimeoutMs     int64 `toml:"timeout_ms"`
+	MaxRows       int64 `toml:"max_rows"`
+	MaxBytes      int64 `toml:"max_bytes"`
+	Persist       bool  `toml:"persist"`
+	LockTimeoutMs bool  `toml:"lock_timeout_ms"`
+	LockMaxRows   bool  `toml:"lock_max_rows"`
+	LockMaxBytes  bool  `toml:"lock_max_bytes"`
+}
+
+//Validate checks if policy is valid
+func (p TablePolicy) Validate() error {
+	if p.TimeoutMs < 0 || p.MaxRows < 0 || p.MaxBytes < 0 {
+		return ErrNegativePolicyValue
+	}
+	if p.LockTimeoutMs && p.TimeoutMs == 0 {
+		return errors.Wrap(ErrLockedPolicyValueNotSet, "timeout_ms")
+	}
+	if p.LockMaxRows && p.MaxRows == 0 {
+		return errors.Wrap(ErrLockedPolicyValueNotSet, "max_rows")
+	}
+	if p.LockMaxBytes && p.MaxBytes == 0 {
+		return errors.Wrap(ErrLockedPolicyValueNotSet, "max_bytes")
+	}
+
+	return nil
+}
+
+//Apply returns request's config with policy values
+func (p TablePolicy) Apply(config Config) Config {
+	if p.TimeoutMs != 0 && (p.LockTimeoutMs || config.TimeoutMs == 0) {
+		config.TimeoutMs = p.TimeoutMs
+	}
+	if p.MaxRows != 0 && (p.LockMaxRows || config.MaxRows == 0) {
+		config.MaxRows = p.MaxRows
+	}
+	if p.MaxBytes != 0 && (p.LockMaxBytes || config.MaxBytes == 0) {
+		config.MaxBytes = p.MaxBytes
+	}
+	config.Persist = config.Persist || p.Persist
+
+	return config
+}
@@ -0,0 +1,50 @@
+package tablemanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTablePolicyApply(t *testing.T) {
+	cases := []struct {
+		policy   TablePolicy
+		request  Config
+		expected Config
+	}{
+		//request without values gets defaults
+		{TablePolicy{TimeoutMs: 1000, MaxRows: 100}, Config{}, Config{TimeoutMs: 1000, MaxRows: 100}},
+		//locked values
+		{TablePolicy{TimeoutMs: 1000, MaxRows: 100, LockTimeoutMs: true, LockMaxRows: true}, Config{TimeoutMs: 1, MaxRows: 1}, Config{TimeoutMs: 1000, MaxRows: 100}},
+		//overridable values
+		{TablePolicy{TimeoutMs: 1000, MaxRows: 100}, Config{TimeoutMs: 1, Persist: true}, Config{TimeoutMs: 1, MaxRows: 100, Persist: true}},
+		//only locked value is kept
+		{TablePolicy{TimeoutMs: 1000, MaxRows: 100, LockMaxRows: true}, Config{TimeoutMs: 1, MaxRows: 5}, Config{TimeoutMs: 1, MaxRows: 100}},
+		//not set values are taken from request
+		{TablePolicy{TimeoutMs: 1000, LockTimeoutMs: true}, Config{TimeoutMs: 1, MaxRows: 5}, Config{TimeoutMs: 1000, MaxRows: 5}},
+		//max bytes
+		{TablePolicy{MaxBytes: 1 << 20, LockMaxBytes: true}, Config{TimeoutMs: 1, MaxRows: 5, MaxBytes: 1 << 30}, Config{TimeoutMs: 1, MaxRows: 5, MaxBytes: 1 << 20}},
+		{TablePolicy{MaxBytes: 1 << 20}, Config{TimeoutMs: 1, MaxRows: 5, MaxBytes: 1 << 30}, Config{TimeoutMs: 1, MaxRows: 5, MaxBytes: 1 << 30}},
+		//persist can't be disabled
+		{TablePolicy{Persist: true}, Config{TimeoutMs: 1, MaxRows: 5}, Config{TimeoutMs: 1, MaxRows: 5, Persist: true}},
+		//request's persist is kept even if values are locked
+		{TablePolicy{TimeoutMs: 1000, LockTimeoutMs: true}, Config{TimeoutMs: 1, Persist: true}, Config{TimeoutMs: 1000, Persist: true}},
+	}
+	for _, c := range cases {
+		if got := c.policy.Apply(c.request); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("policy %+v, request %+v: expected %+v, got %+v", c.policy, c.request, c.expected, got)
+		}
+	}
+}
+
+func TestTablePolicyValidate(t *testing.T) {
+	if err := (TablePolicy{TimeoutMs: 1000, MaxRows: 10}).Validate(); err != nil {
+		t.Errorf("shouldn't return error: %s", err)
+	}
+	if err := (TablePolicy{MaxRows: -1}).Validate(); err != ErrNegativePolicyValue {
+		t.Errorf("should return ErrNegativePolicyValue, got %v", err)
+	}
+	if err := (TablePolicy{TimeoutMs: 1000, LockMaxRows: true}).Validate(); !errors.Is(err, ErrLockedPolicyValueNotSet) {
+		t.Errorf("should return ErrLockedPolicyValueNotSet, got %v", err)
+	}
+}
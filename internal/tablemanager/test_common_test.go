@@ -1,8 +1,10 @@
 package tablemanager
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/edwvee/dbatcher/internal/inserter"
@@ -26,10 +28,11 @@ func (si *selfSliceInserter) Init(c inserter.Config) error {
 	return nil
 }
 
-func (si *selfSliceInserter) Insert(t *table.Table) error {
+func (si *selfSliceInserter) Insert(ctx context.Context, t *table.Table) error {
 	si.dataMut.Lock()
 	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
-		si.data = append(si.data, row)
+		//table's memory is reused after Free
+		si.data = append(si.data, append([]interface{}{}, row...))
 	}
 	si.dataMut.Unlock()
 
@@ -51,18 +54,58 @@ func (si *longSleepInserter) Init(c inserter.Config) error {
 	return nil
 }
 
-func (si *longSleepInserter) Insert(t *table.Table) error {
+func (si *longSleepInserter) Insert(ctx context.Context, t *table.Table) error {
 	time.Sleep(time.Minute)
 
 	return nil
 }
 
+//blockingInserter inserts only after release is closed
+type blockingInserter struct {
+	release chan struct{}
+}
+
+func (bi *blockingInserter) Init(c inserter.Config) error {
+	return nil
+}
+
+func (bi *blockingInserter) Insert(ctx context.Context, t *table.Table) error {
+	<-bi.release
+
+	return nil
+}
+
 type errorInserter struct{}
 
 func (si *errorInserter) Init(c inserter.Config) error {
 	return nil
 }
 
-func (si *errorInserter) Insert(t *table.Table) error {
+func (si *errorInserter) Insert(ctx context.Context, t *table.Table) error {
 	return errors.New("some error")
 }
+
+type pingInserter struct {
+	pingErr error
+}
+
+func (pi *pingInserter) Init(c inserter.Config) error {
+	return nil
+}
+
+func (pi *pingInserter) Insert(ctx context.Context, t *table.Table) error {
+	return nil
+}
+
+func (pi *pingInserter) Ping(ctx context.Context) error {
+	return pi.pingErr
+}
+
+func mustGetTableManager(t *testing.T, tmh *Holder, ts *table.Signature, config Config) *TableManager {
+	tm, err := tmh.getTableManager(ts, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tm
+}
@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+//ErrInvalidConfig means tracing config has wrong endpoint or negative interval
+var ErrInvalidConfig = errors.New("invalid tracing config")
+
+//Config is a config of OTLP/HTTP export of spans. Empty Endpoint means
+//tracing is disabled, empty ServiceName means "dbatcher",
+//0 ExportIntervalMs means 5000
+type Config struct {
+	//Endpoint is URL of OTLP/HTTP traces receiver, e.g. http://localhost:4318/v1/traces
+	Endpoint         string `toml:"endpoint"`
+	ServiceName      string `toml:"service_name"`
+	ExportIntervalMs int64  `toml:"export_interval_ms"`
+}
+
+//Validate checks if endpoint is http(s) URL and interval isn't negative
+func (c Config) Validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return errors.Wrapf(ErrInvalidConfig, "endpoint: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Wrapf(ErrInvalidConfig, "endpoint %q should be http or https URL", c.Endpoint)
+	}
+	if c.ExportIntervalMs < 0 {
+		return errors.Wrap(ErrInvalidConfig, "export_interval_ms couldn't be negative")
+	}
+
+	return nil
+}
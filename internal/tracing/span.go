@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentationName is the name of dbatcher's tracer
+const instrumentationName = "github.com/edwvee/dbatcher"
+
+//MaxLinks limits links collected for a span, it's the SDK's default limit
+const MaxLinks = 128
+
+//Start starts span as a child of ctx's span or remote parent (see
+//ContextWithTraceparent), or as a root of a new trace. The span
+//isn't recorded if tracing is disabled or parent isn't sampled
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+//ContextWithTraceparent returns ctx with remote parent of W3C traceparent
+//header's value, ctx is returned unchanged if the value is invalid
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+//SetError marks span as failed with err, nil err is ignored
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/logging"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	defaultServiceName    = "dbatcher"
+	defaultExportInterval = 5 * time.Second
+	exportTimeout         = 10 * time.Second
+)
+
+//SetTracer makes Start use t's provider, nil disables tracing. Thread safe
+func SetTracer(t *Tracer) {
+	if t == nil {
+		SetTracerProvider(nil)
+		return
+	}
+	SetTracerProvider(t.provider)
+}
+
+//SetTracerProvider makes Start use provider, e.g. one with
+//an in-memory exporter in tests. Nil disables tracing
+func SetTracerProvider(provider trace.TracerProvider) {
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+	otel.SetTracerProvider(provider)
+}
+
+//Tracer exports spans in batches to OTLP/HTTP receiver
+//every export interval or when there are enough of them
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	endpoint string
+	logger   *logging.Logger
+}
+
+//NewTracer validates config and returns tracer, SetTracer should be called to use it
+func NewTracer(config Config) (*Tracer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(
+		context.Background(),
+		otlptracehttp.WithEndpointURL(config.Endpoint),
+		otlptracehttp.WithTimeout(exportTimeout),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "tracing")
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	exportInterval := time.Duration(config.ExportIntervalMs) * time.Millisecond
+	if exportInterval == 0 {
+		exportInterval = defaultExportInterval
+	}
+	t := &Tracer{
+		endpoint: config.Endpoint,
+		logger:   logging.With("component", "tracing"),
+	}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(exportInterval), sdktrace.WithExportTimeout(exportTimeout)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		t.logger.Warn("tracing failed", "endpoint", t.endpoint, "error", err)
+	}))
+
+	return t, nil
+}
+
+//Stop exports queued spans and stops exporting, spans ended after
+//that are dropped. Safe to call more than once
+func (t *Tracer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		t.logger.Warn("can't export spans", "endpoint", t.endpoint, "error", err)
+	}
+}
@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+//collector receives OTLP/HTTP protobuf export requests
+type collector struct {
+	server       *httptest.Server
+	mut          sync.Mutex
+	serviceNames []string
+	spans        []*tracepb.Span
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		request := &coltracepb.ExportTraceServiceRequest{}
+		if err == nil {
+			err = proto.Unmarshal(body, request)
+		}
+		if r.URL.Path != "/v1/traces" || err != nil {
+			http.Error(w, "expected OTLP request to /v1/traces", http.StatusBadRequest)
+			return
+		}
+		c.mut.Lock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, kv := range resourceSpans.Resource.Attributes {
+				if kv.Key == "service.name" {
+					c.serviceNames = append(c.serviceNames, kv.Value.GetStringValue())
+				}
+			}
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				c.spans = append(c.spans, scopeSpans.Spans...)
+			}
+		}
+		c.mut.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	t.Cleanup(c.server.Close)
+
+	return c
+}
+
+func (c *collector) url() string {
+	return c.server.URL + "/v1/traces"
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (Config{Endpoint: "http://localhost:4318/v1/traces"}).Validate(); err != nil {
+		t.Errorf("should be valid: %s", err)
+	}
+	for _, config := range []Config{
+		{},
+		{Endpoint: "localhost:4318"},
+		{Endpoint: "grpc://localhost:4317"},
+		{Endpoint: "http://localhost:4318/v1/traces", ExportIntervalMs: -1},
+	} {
+		if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%+v: should return ErrInvalidConfig, got %v", config, err)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	SetTracer(nil)
+	_, span := Start(context.Background(), "disabled")
+	SetError(span, errors.New("error"))
+	span.End()
+	if span.IsRecording() || span.SpanContext().IsValid() {
+		t.Error("span shouldn't be recorded without tracer")
+	}
+}
+
+func TestContextWithTraceparent(t *testing.T) {
+	sc := trace.SpanContextFromContext(ContextWithTraceparent(context.Background(), traceparent))
+	if !sc.IsRemote() || !sc.IsSampled() ||
+		sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("wrong span context: %+v", sc)
+	}
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	} {
+		if trace.SpanContextFromContext(ContextWithTraceparent(context.Background(), value)).IsValid() {
+			t.Errorf("%q: should be ignored", value)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	tracer, err := NewTracer(Config{Endpoint: c.url(), ServiceName: "test", ExportIntervalMs: int64(time.Hour / time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	ctx, parent := Start(ContextWithTraceparent(context.Background(), traceparent), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := Start(ctx, "child")
+	SetError(child, errors.New("insert failed"))
+	child.End()
+	parent.End()
+	_, root := Start(context.Background(), "root", trace.WithNewRoot(), trace.WithLinks(trace.Link{SpanContext: parent.SpanContext()}))
+	root.End()
+	unsampled := ContextWithTraceparent(context.Background(), traceparent[:len(traceparent)-2]+"00")
+	if _, span := Start(unsampled, "unsampled"); span.IsRecording() {
+		t.Error("span of not sampled parent shouldn't be recorded")
+	}
+	//spans are exported by Stop, the interval is too long
+	tracer.Stop()
+	tracer.Stop()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if len(c.serviceNames) == 0 || c.serviceNames[0] != "test" {
+		t.Errorf("expected service name test, got %v", c.serviceNames)
+	}
+	spans := map[string]*tracepb.Span{}
+	for _, span := range c.spans {
+		spans[span.Name] = span
+	}
+	if len(c.spans) != 3 || len(spans) != 3 {
+		t.Fatalf("expected parent, child and root spans, got %v", c.spans)
+	}
+	p, ch, r := spans["parent"], spans["child"], spans["root"]
+	if hex.EncodeToString(p.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		p.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("parent should continue remote trace as server span: %v", p)
+	}
+	if string(ch.ParentSpanId) != string(p.SpanId) || ch.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR ||
+		ch.Status.GetMessage() != "insert failed" {
+		t.Errorf("child should be failed span of parent: %v", ch)
+	}
+	if string(r.TraceId) == string(p.TraceId) || len(r.Links) != 1 || string(r.Links[0].SpanId) != string(p.SpanId) {
+		t.Errorf("root should start a new trace linked to parent: %v", r)
+	}
+}
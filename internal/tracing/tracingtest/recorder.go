@@ -0,0 +1,50 @@
+//Package tracingtest records spans in memory for tests
+package tracingtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edwvee/dbatcher/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+//Recorder has spans ended since it was started
+type Recorder struct {
+	*tracetest.SpanRecorder
+}
+
+//NewRecorder makes tracing.Start record spans until test's end
+func NewRecorder(t *testing.T) *Recorder {
+	r := &Recorder{tracetest.NewSpanRecorder()}
+	tracing.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(r.SpanRecorder)))
+	t.Cleanup(func() {
+		tracing.SetTracerProvider(nil)
+	})
+
+	return r
+}
+
+//WaitForSpans waits until at least n spans are ended or timeout
+//passes, returns ended spans
+func (r *Recorder) WaitForSpans(n int, timeout time.Duration) []sdktrace.ReadOnlySpan {
+	deadline := time.Now().Add(timeout)
+	for {
+		spans := r.Ended()
+		if len(spans) >= n || time.Now().After(deadline) {
+			return spans
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//Attributes returns span's attributes by keys
+func Attributes(span sdktrace.ReadOnlySpan) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for _, kv := range span.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsInterface()
+	}
+
+	return attributes
+}
@@ -0,0 +1,6 @@
+package wal
+
+//Config is a config for write-ahead log
+type Config struct {
+	Dir string `toml:"dir"`
+}
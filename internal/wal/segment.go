@@ -0,0 +1,139 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+const recordHeaderSize = 8
+
+//ErrEmptySegment means segment has no header (e.g. crashed right after creation)
+var ErrEmptySegment = errors.New("wal: segment has no header")
+
+//Segment is an append only file of records.
+//Every record is: 4 bytes of length, 4 bytes of crc32, data.
+//Not thread safe
+type Segment struct {
+	f    *os.File
+	path string
+}
+
+//Write writes record and syncs file to disk
+func (s *Segment) Write(record []byte) error {
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(record))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(record))
+	buf = append(buf, record...)
+	if _, err := s.f.Write(buf); err != nil {
+		return errors.Wrap(err, "wal: write")
+	}
+
+	return errors.Wrap(s.f.Sync(), "wal: sync")
+}
+
+//Close closes segment's file leaving it on disk
+func (s *Segment) Close() error {
+	return s.f.Close()
+}
+
+//CloseForInserters closes segment leaving it on disk with header's
+//FailedInserters set to inserters, so only they replay it's records.
+//Segment is written to a temporary file which replaces segment's one
+func (s *Segment) CloseForInserters(inserters []string) error {
+	if err := s.f.Close(); err != nil {
+		return errors.Wrap(err, "wal: close")
+	}
+	header, records, err := ReadSegment(s.path)
+	if err != nil {
+		return err
+	}
+	header.FailedInserters = inserters
+	headerJSON, err := jsoniter.Marshal(header)
+	if err != nil {
+		return errors.Wrap(err, "wal: rewrite segment")
+	}
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "wal: rewrite segment")
+	}
+	tmp := &Segment{f: f, path: tmpPath}
+	for _, record := range append([][]byte{headerJSON}, records...) {
+		if err := tmp.Write(record); err != nil {
+			tmp.Remove()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "wal: rewrite segment")
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "wal: rewrite segment")
+	}
+
+	return syncDir(filepath.Dir(s.path))
+}
+
+//Remove closes and deletes segment's file
+func (s *Segment) Remove() error {
+	s.f.Close()
+	return os.Remove(s.path)
+}
+
+//ReadSegment reads header and records from segment's file.
+//A torn record at the end (process crashed while writing) is ignored.
+func ReadSegment(path string) (header Header, records [][]byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return header, nil, errors.Wrap(err, "wal: read segment")
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	headerJSON, err := readRecord(r)
+	if err != nil {
+		return header, nil, ErrEmptySegment
+	}
+	if err = jsoniter.Unmarshal(headerJSON, &header); err != nil {
+		return header, nil, errors.Wrap(err, "wal: read segment header")
+	}
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+
+	return header, records, nil
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	recordHeader := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, recordHeader); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.LittleEndian.Uint32(recordHeader[0:4]))
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(record) != binary.LittleEndian.Uint32(recordHeader[4:8]) {
+		return nil, errors.New("wal: record checksum mismatch")
+	}
+
+	return record, nil
+}
+
+//Path returns segment's file path
+func (s *Segment) Path() string {
+	return s.path
+}
@@ -0,0 +1,111 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+const segmentExt = ".wal"
+
+//Header describes what table segment's records belong to
+type Header struct {
+	Table     string `json:"table"`
+	Fields    string `json:"fields"`
+	TimeoutMs int64  `json:"timeout_ms"`
+	MaxRows   int64  `json:"max_rows"`
+	MaxBytes  int64  `json:"max_bytes,omitempty"`
+	//Inserters are names of inserters rows are accepted for
+	Inserters []string `json:"inserters,omitempty"`
+	//FailedInserters are inserters that didn't insert segment's records,
+	//only they replay the segment. Empty means all inserters
+	FailedInserters []string `json:"failed_inserters,omitempty"`
+}
+
+//WAL is a directory with segments. Every segment holds rows
+//of one table's batch until the batch is inserted.
+type WAL struct {
+	dir     string
+	lastSeq uint64
+}
+
+//Open creates directory if needed and returns WAL on it
+func Open(config Config) (*WAL, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "wal: open")
+	}
+	w := &WAL{dir: config.Dir}
+	paths, err := w.SegmentPaths()
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) != 0 {
+		last := strings.TrimSuffix(filepath.Base(paths[len(paths)-1]), segmentExt)
+		w.lastSeq, _ = strconv.ParseUint(last, 10, 64)
+	}
+
+	return w, nil
+}
+
+//SegmentPaths returns paths of existing segments in creation order
+func (w *WAL) SegmentPaths() ([]string, error) {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "wal: list segments")
+	}
+	paths := []string{}
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != segmentExt {
+			continue
+		}
+		paths = append(paths, filepath.Join(w.dir, info.Name()))
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+//NewSegment creates a segment file, writes header into it
+//and syncs it to disk
+func (w *WAL) NewSegment(header Header) (*Segment, error) {
+	seq := atomic.AddUint64(&w.lastSeq, 1)
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "wal: new segment")
+	}
+	s := &Segment{f: f, path: path}
+	headerJSON, err := jsoniter.Marshal(header)
+	if err != nil {
+		s.Remove()
+		return nil, errors.Wrap(err, "wal: new segment")
+	}
+	if err := s.Write(headerJSON); err != nil {
+		s.Remove()
+		return nil, err
+	}
+	if err := syncDir(w.dir); err != nil {
+		s.Remove()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "wal: sync dir")
+	}
+	defer d.Close()
+
+	return errors.Wrap(d.Sync(), "wal: sync dir")
+}
@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSegmentWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbatcher_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := Header{Table: "db.table", Fields: "field1,field2", TimeoutMs: 1000, MaxRows: 10, Inserters: []string{"first"}}
+	segment, err := w.NewSegment(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := [][]byte{[]byte("[[1,2]]"), []byte("[[3,4],[5,6]]")}
+	for _, record := range records {
+		if err := segment.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//emulate crash while writing a record
+	segment.f.Write([]byte{100, 0, 0, 0, 1, 2})
+	if err := segment.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := w.SegmentPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != segment.Path() {
+		t.Fatalf("want one segment %s, got %v", segment.Path(), paths)
+	}
+	gotHeader, gotRecords, err := ReadSegment(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotHeader, header) {
+		t.Errorf("want header %v, got %v", header, gotHeader)
+	}
+	if !reflect.DeepEqual(gotRecords, records) {
+		t.Errorf("want records %s, got %s", records, gotRecords)
+	}
+
+	newSegment, err := w.NewSegment(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSegment.Path() <= segment.Path() {
+		t.Errorf("new segment %s should go after %s", newSegment.Path(), segment.Path())
+	}
+	if err := newSegment.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := w.SegmentPaths(); len(paths) != 1 {
+		t.Errorf("removed segment shouldn't be listed, got %v", paths)
+	}
+}
+
+func TestReadEmptySegment(t *testing.T) {
+	f, err := ioutil.TempFile("", "dbatcher_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, _, err := ReadSegment(f.Name()); err != ErrEmptySegment {
+		t.Errorf("should be ErrEmptySegment, got %v", err)
+	}
+}
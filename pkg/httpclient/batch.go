@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+//BatchEntry is a table's rows of SendBatch. Zero TimeoutMs,
+//MaxRows and MaxBytes aren't sent, so table's server side values are used
+type BatchEntry struct {
+	Table  string `json:"table"`
+	Fields string `json:"fields"`
+	//Rows must be slice of slices of primitives like int, float or string
+	Rows      interface{} `json:"rows"`
+	TimeoutMs uint        `json:"timeout_ms,omitempty"`
+	MaxRows   uint        `json:"max_rows,omitempty"`
+	MaxBytes  uint        `json:"max_bytes,omitempty"`
+	Sync      bool        `json:"sync,omitempty"`
+	Persist   bool        `json:"persist,omitempty"`
+	//Inserters limits inserters of rows, all routed ones are used if empty
+	Inserters []string `json:"inserters,omitempty"`
+}
+
+//BatchEntryStatus is a result of a batch entry: Status is the code
+//a single table request would get, Error is its message,
+//Receipt is ID of receipt of the table's batch the rows joined (see GetReceipt)
+type BatchEntryStatus struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Receipt string `json:"receipt,omitempty"`
+}
+
+//BatchError is returned by SendBatch if some entries weren't accepted
+type BatchError struct {
+	//Statuses are results of all entries in order of entries
+	Statuses []BatchEntryStatus
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	first := -1
+	for i, status := range e.Statuses {
+		if status.Status != fasthttp.StatusOK {
+			failed++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	return fmt.Sprintf(
+		"dbatcher http client: %d of %d batch entries failed, entry %d: code %d, response: %s",
+		failed, len(e.Statuses), first, e.Statuses[first].Status, e.Statuses[first].Error,
+	)
+}
+
+//SendBatch sends rows of several tables in one request to dbatcher.
+//Returns *BatchError if some entries weren't accepted, other entries
+//are accepted anyway
+func (c Client) SendBatch(entries []BatchEntry) error {
+	_, err := c.SendBatchWithStatuses(entries)
+	return err
+}
+
+//SendBatchWithStatuses does the same as SendBatch, also returns statuses
+//of entries with their receipts if response is received
+func (c Client) SendBatchWithStatuses(entries []BatchEntry) ([]BatchEntryStatus, error) {
+	data, err := jsoniter.Marshal(entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "dbatcher http client")
+	}
+
+	var statuses []BatchEntryStatus
+	err = c.post(c.serverAddress+"/batch", "application/json", data, func(response *fasthttp.Response) error {
+		if err := jsoniter.Unmarshal(response.Body(), &statuses); err != nil {
+			return errors.Wrap(err, "dbatcher http client: batch response")
+		}
+		for _, status := range statuses {
+			if status.Status != fasthttp.StatusOK {
+				return &BatchError{Statuses: statuses}
+			}
+		}
+		return nil
+	})
+
+	return statuses, err
+}
@@ -1,8 +1,11 @@
 package httpclient
 
 import (
+	"bytes"
 	"fmt"
 	"net/url"
+	"reflect"
+	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -10,17 +13,62 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+//Formats of rows (values of SendParams.Format)
+const (
+	//FormatJSON sends rows as JSON array of arrays, default
+	FormatJSON = "json"
+	//FormatNDJSON sends every row as a JSON line,
+	//rows should be a slice of maps or structs with keys as fields
+	FormatNDJSON = "ndjson"
+	//FormatMsgpack sends rows as MessagePack array of arrays
+	FormatMsgpack = "msgpack"
+	//FormatProtobuf sends rows as Rows message of clients/rows.proto,
+	//rows should be a slice of slices of primitives
+	FormatProtobuf = "protobuf"
+)
+
+//contentTypes of binary formats, they are sent without format parameter
+var contentTypes = map[string]string{
+	FormatMsgpack:  "application/msgpack",
+	FormatProtobuf: "application/x-protobuf",
+}
+
+var (
+	//ErrUnknownFormat means SendParams.Format has unknown value
+	ErrUnknownFormat = errors.New("dbatcher http client: unknown format")
+	//ErrRowsNotSlice means rows of FormatNDJSON, FormatMsgpack
+	//or FormatProtobuf (or its rows) aren't a slice
+	ErrRowsNotSlice = errors.New("dbatcher http client: rows should be a slice")
+)
+
+//StatusError is returned if dbatcher responded with not 200 code
+type StatusError struct {
+	Code     int
+	Response string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(
+		"dbatcher http client: got not 200 response: code %d, response: %s",
+		e.Code, e.Response,
+	)
+}
+
 //ClientConfig is a config for cliet
 type ClientConfig struct {
 	ServerAddress string
 	ReadTimeout   time.Duration
 	WriteTimeout  time.Duration
+	//Compression compresses request bodies: CompressionGzip,
+	//CompressionZstd or CompressionBrotli. Empty means no compression
+	Compression string
 }
 
 //Client is a dbatcher's HTTP client
 type Client struct {
 	client        *fasthttp.Client
 	serverAddress string
+	compression   string
 }
 
 //NewClient returns configured client
@@ -32,59 +80,198 @@ func NewClient(config ClientConfig) *Client {
 			NoDefaultUserAgentHeader: true,
 		},
 		serverAddress: config.ServerAddress,
+		compression:   config.Compression,
 	}
 }
 
+//SendParams are parameters of a request to dbatcher. Zero TimeoutMs,
+//MaxRows and MaxBytes aren't sent, so table's server side values are used
+type SendParams struct {
+	Table     string
+	Fields    string
+	TimeoutMs uint
+	MaxRows   uint
+	//MaxBytes limits size of table's buffered rows JSON before insert
+	MaxBytes uint
+	Sync     bool
+	Persist  bool
+	//Inserters limits inserters of rows, all routed ones are used if empty
+	Inserters []string
+	//Format is format of rows, FormatJSON if empty.
+	//Fields could be empty for FormatNDJSON, they are rows' keys then
+	Format string
+	//Missing is what server does with a FormatNDJSON row without a field's key:
+	//"null" (if empty), "default" or "reject"
+	Missing string
+	//Wait holds response till rows are inserted by all table's inserters.
+	//Client's ReadTimeout should be greater than WaitTimeoutMs then
+	Wait bool
+	//WaitTimeoutMs limits Wait, server's default (30s) is used if 0
+	WaitTimeoutMs uint
+}
+
 //Send sends table parameters and rows to dbatcher.
 //Rows must be slice of slices of primitives like int, float or string
 func (c Client) Send(table, fields string, timeoutMs, maxRows uint, sync, persist bool, rows interface{}) error {
-	url := c.makeURL(table, fields, timeoutMs, maxRows, sync, persist)
-	data, err := jsoniter.Marshal(rows)
+	return c.SendWithParams(SendParams{
+		Table:     table,
+		Fields:    fields,
+		TimeoutMs: timeoutMs,
+		MaxRows:   maxRows,
+		Sync:      sync,
+		Persist:   persist,
+	}, rows)
+}
+
+//SendWithParams does the same as Send, but takes all request's parameters
+func (c Client) SendWithParams(params SendParams, rows interface{}) error {
+	_, err := c.SendWithReceipt(params, rows)
+	return err
+}
+
+//SendWithReceipt does the same as SendWithParams, also returns ID of
+//receipt of the batch the rows joined (see GetReceipt). ID is empty for Sync
+func (c Client) SendWithReceipt(params SendParams, rows interface{}) (string, error) {
+	data, err := marshalRows(params.Format, rows)
 	if err != nil {
-		return errors.Wrap(err, "dbatcher http client")
+		return "", errors.Wrap(err, "dbatcher http client")
 	}
+	var receiptID string
+	err = c.post(c.makeParamsURL(params), contentTypes[params.Format], data, func(response *fasthttp.Response) error {
+		receiptID = string(response.Header.Peek(receiptHeader))
+		return nil
+	})
 
-	request := fasthttp.AcquireRequest()
-	response := fasthttp.AcquireResponse()
-	defer func() {
-		fasthttp.ReleaseRequest(request)
-		fasthttp.ReleaseResponse(response)
-	}()
+	return receiptID, err
+}
 
+//post sends data compressed with client's compression. Returns an error
+//if response code isn't 200, otherwise handleResponse (if not nil) result
+func (c Client) post(url, contentType string, data []byte, handleResponse func(response *fasthttp.Response) error) error {
+	if c.compression != "" {
+		var err error
+		if data, err = compress(c.compression, data); err != nil {
+			return errors.Wrap(err, "dbatcher http client")
+		}
+	}
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
 	request.Header.SetMethod(fasthttp.MethodPost)
 	request.SetRequestURI(url)
+	if contentType != "" {
+		request.Header.SetContentType(contentType)
+	}
+	if c.compression != "" {
+		request.Header.Set(fasthttp.HeaderContentEncoding, c.compression)
+	}
 	request.SetBodyRaw(data)
-	err = c.client.Do(request, response)
+
+	return c.do(request, handleResponse)
+}
+
+//do sends request. Returns *StatusError if response code isn't 200,
+//otherwise handleResponse (if not nil) result
+func (c Client) do(request *fasthttp.Request, handleResponse func(response *fasthttp.Response) error) error {
+	response := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(response)
+	err := c.client.Do(request, response)
 	if err != nil {
 		return errors.Wrap(err, "dbatcher http client")
 	}
 	if code := response.StatusCode(); code != fasthttp.StatusOK {
-		errorString := fmt.Sprintf(
-			"dbatcher http client: got not 200 response: code %d, response: %s",
-			code, string(response.Body()),
-		)
-		return errors.New(errorString)
+		return &StatusError{Code: code, Response: string(response.Body())}
+	}
+	if handleResponse != nil {
+		return handleResponse(response)
 	}
 
 	return nil
 }
 
-func (c Client) makeURL(table, fields string, timeoutMs, maxRows uint, sync, persist bool) string {
-	if sync {
-		return fmt.Sprintf(
-			"%s/?table=%s&fields=%s&sync=1",
-			c.serverAddress, url.QueryEscape(table), url.QueryEscape(fields),
-		)
+func marshalRows(format string, rows interface{}) ([]byte, error) {
+	switch format {
+	case "", FormatJSON:
+		return jsoniter.Marshal(rows)
+	case FormatNDJSON:
+		return marshalNDJSON(rows)
+	case FormatMsgpack:
+		return marshalMsgpack(rows)
+	case FormatProtobuf:
+		return marshalProtobuf(rows)
+	default:
+		return nil, ErrUnknownFormat
 	}
-	persistStr := ""
-	if persist {
-		persistStr = "&persist=1"
+}
+
+func marshalNDJSON(rows interface{}) ([]byte, error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return nil, ErrRowsNotSlice
 	}
-	return fmt.Sprintf(
-		"%s/?table=%s&fields=%s&timeout_ms=%d&max_rows=%d%s",
-		c.serverAddress, url.QueryEscape(table), url.QueryEscape(fields),
-		timeoutMs, maxRows, persistStr,
+	var b bytes.Buffer
+	stream := jsoniter.NewStream(jsoniter.ConfigDefault, &b, 4096)
+	for i := 0; i < value.Len(); i++ {
+		stream.WriteVal(value.Index(i).Interface())
+		stream.WriteRaw("\n")
+	}
+	if err := stream.Flush(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), stream.Error
+}
+
+func (c Client) makeURL(table, fields string, timeoutMs, maxRows uint, sync, persist bool) string {
+	return c.makeParamsURL(SendParams{
+		Table:     table,
+		Fields:    fields,
+		TimeoutMs: timeoutMs,
+		MaxRows:   maxRows,
+		Sync:      sync,
+		Persist:   persist,
+	})
+}
+
+func (c Client) makeParamsURL(params SendParams) string {
+	var b strings.Builder
+	fmt.Fprintf(
+		&b, "%s/?table=%s&fields=%s",
+		c.serverAddress, url.QueryEscape(params.Table), url.QueryEscape(params.Fields),
 	)
+	if params.Sync {
+		b.WriteString("&sync=1")
+	} else {
+		writeUintParam(&b, "timeout_ms", params.TimeoutMs)
+		writeUintParam(&b, "max_rows", params.MaxRows)
+		writeUintParam(&b, "max_bytes", params.MaxBytes)
+		if params.Persist {
+			b.WriteString("&persist=1")
+		}
+		if params.Wait {
+			b.WriteString("&wait=1")
+			writeUintParam(&b, "wait_timeout_ms", params.WaitTimeoutMs)
+		}
+	}
+	if len(params.Inserters) != 0 {
+		b.WriteString("&inserters=")
+		b.WriteString(url.QueryEscape(strings.Join(params.Inserters, ",")))
+	}
+	if _, ok := contentTypes[params.Format]; params.Format != "" && !ok {
+		b.WriteString("&format=")
+		b.WriteString(url.QueryEscape(params.Format))
+	}
+	if params.Missing != "" {
+		b.WriteString("&missing=")
+		b.WriteString(url.QueryEscape(params.Missing))
+	}
+
+	return b.String()
+}
+
+func writeUintParam(b *strings.Builder, name string, value uint) {
+	if value != 0 {
+		fmt.Fprintf(b, "&%s=%d", name, value)
+	}
 }
 
 //Close closes connection to dbatcher
@@ -1,6 +1,12 @@
 package httpclient
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -47,6 +53,109 @@ func TestMakeUrl(t *testing.T) {
 	if url != wantURL {
 		t.Errorf("want url: %s ; got %s", wantURL, url)
 	}
+	url = client.makeParamsURL(SendParams{
+		Table:     "database.table",
+		Fields:    "field1,field2",
+		MaxRows:   2,
+		MaxBytes:  1048576,
+		Inserters: []string{"first", "second"},
+	})
+	wantURL = "http://127.0.0.1:8124/?table=database.table&fields=field1%2Cfield2&max_rows=2&max_bytes=1048576&inserters=first%2Csecond"
+	if url != wantURL {
+		t.Errorf("want url: %s ; got %s", wantURL, url)
+	}
+
+	url = client.makeParamsURL(SendParams{
+		Table:   "database.table",
+		Sync:    true,
+		Format:  FormatNDJSON,
+		Missing: "reject",
+	})
+	wantURL = "http://127.0.0.1:8124/?table=database.table&fields=&sync=1&format=ndjson&missing=reject"
+	if url != wantURL {
+		t.Errorf("want url: %s ; got %s", wantURL, url)
+	}
+
+	url = client.makeParamsURL(SendParams{Table: "database.table", Sync: true, Format: FormatMsgpack})
+	wantURL = "http://127.0.0.1:8124/?table=database.table&fields=&sync=1"
+	if url != wantURL {
+		t.Errorf("want url: %s ; got %s", wantURL, url)
+	}
+
+	url = client.makeParamsURL(SendParams{Table: "database.table", TimeoutMs: 1, Wait: true, WaitTimeoutMs: 500})
+	wantURL = "http://127.0.0.1:8124/?table=database.table&fields=&timeout_ms=1&wait=1&wait_timeout_ms=500"
+	if url != wantURL {
+		t.Errorf("want url: %s ; got %s", wantURL, url)
+	}
+}
+
+func TestMarshalRows(t *testing.T) {
+	type row struct {
+		ID   int    `json:"id"`
+		Name string `json:"name,omitempty"`
+	}
+	rows := []row{{ID: 1, Name: "a"}, {ID: 2}}
+	data, err := marshalRows(FormatNDJSON, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2}\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+	if _, err := marshalRows(FormatNDJSON, rows[0]); err != ErrRowsNotSlice {
+		t.Errorf("should be %s, got %v", ErrRowsNotSlice, err)
+	}
+	if _, err := marshalRows("xml", rows); err != ErrUnknownFormat {
+		t.Errorf("should be %s, got %v", ErrUnknownFormat, err)
+	}
+
+	var nilString *string
+	name := "b"
+	values := [][]interface{}{
+		{1, -40000, uint64(math.MaxUint64), 1.5, float32(0.25), "a", nil},
+		{int8(-1), uint16(300), strings.Repeat("s", 40), true, false, []byte("c"), nilString},
+		{0, 0, "", &name, 0.0, []interface{}{1, "d"}, map[string]int{"k": 1}},
+	}
+	expected := [][]interface{}{
+		{json.Number("1"), json.Number("-40000"), json.Number("18446744073709551615"), json.Number("1.5"), json.Number("0.25"), "a", nil},
+		{json.Number("-1"), json.Number("300"), strings.Repeat("s", 40), true, false, "c", nil},
+		{json.Number("0"), json.Number("0"), "", "b", json.Number("0"), []interface{}{json.Number("1"), "d"}, map[string]interface{}{"k": json.Number("1")}},
+	}
+	data, err = marshalRows(FormatMsgpack, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := table.DecodeMsgpackRows(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("msgpack: expected %v, got %v", expected, decoded)
+	}
+
+	values = values[:2]
+	expected = expected[:2]
+	values[1][5] = "c"
+	data, err = marshalRows(FormatProtobuf, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, err = table.DecodeProtobufRows(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("protobuf: expected %v, got %v", expected, decoded)
+	}
+	if _, err := marshalRows(FormatProtobuf, [][]interface{}{{[]int{1}}}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("should be %s, got %v", ErrUnsupportedType, err)
+	}
+	if _, err := marshalRows(FormatProtobuf, []int{1}); err != ErrRowsNotSlice {
+		t.Errorf("should be %s, got %v", ErrRowsNotSlice, err)
+	}
+	if _, err := marshalRows(FormatMsgpack, [][]interface{}{{make(chan int)}}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("should be %s, got %v", ErrUnsupportedType, err)
+	}
 }
 
 type selfSliceInserter struct {
@@ -59,7 +168,7 @@ func (si *selfSliceInserter) Init(c inserter.Config) error {
 	return nil
 }
 
-func (si *selfSliceInserter) Insert(t *table.Table) error {
+func (si *selfSliceInserter) Insert(ctx context.Context, t *table.Table) error {
 	si.dataMut.Lock()
 	for row := t.GetNextRow(); row != nil; row = t.GetNextRow() {
 		si.data = append(si.data, row)
@@ -110,8 +219,95 @@ func TestSend(t *testing.T) {
 	err = Send(config, "table", "field1, field2", 10, 10, false, true, [][]interface{}{{"1", "2"}})
 	t.Log(err)
 	if err == nil {
-		t.Fatal("should get error if tried to use persist")
+		t.Fatal("should get error if tried to use persist without configured wal")
 	}
+	err = SendWithParams(config, SendParams{Table: "table", Sync: true, Format: FormatNDJSON}, []map[string]string{
+		{"field1": "1", "field2": "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ins.TakeSlice(); len(data) != 1 {
+		t.Fatal("didn't insert ndjson")
+	}
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionBrotli} {
+		compressedConfig := config
+		compressedConfig.Compression = compression
+		err = Send(compressedConfig, "table", "field1, field2", 10, 10, true, false, [][]interface{}{{"1", "2"}})
+		if err != nil {
+			t.Fatalf("%s: %s", compression, err)
+		}
+		if data := ins.TakeSlice(); len(data) != 1 {
+			t.Fatalf("didn't insert %s compressed rows", compression)
+		}
+	}
+	config.Compression = "lz4"
+	if err = Send(config, "table", "field1, field2", 10, 10, true, false, [][]interface{}{{"1", "2"}}); !errors.Is(err, ErrUnknownCompression) {
+		t.Fatalf("should be %s, got %v", ErrUnknownCompression, err)
+	}
+	config.Compression = ""
+	err = SendBatch(config, []BatchEntry{
+		{Table: "table", Fields: "field1, field2", Rows: [][]interface{}{{"1", "2"}}, Sync: true},
+		{Table: "table", Fields: "field1", Rows: [][]interface{}{{"1"}}, TimeoutMs: 10, MaxRows: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data := ins.TakeSlice(); len(data) != 2 {
+		t.Fatalf("didn't insert batch, got %v", data)
+	}
+	err = SendBatch(config, []BatchEntry{
+		{Table: "table", Fields: "field1, field2", Rows: [][]interface{}{{"1"}}, Sync: true},
+		{Table: "table", Fields: "field1", Rows: [][]interface{}{{"1"}}, Sync: true},
+	})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("should be BatchError, got %v", err)
+	}
+	if batchErr.Statuses[0].Status != 400 || batchErr.Statuses[1].Status != 200 {
+		t.Errorf("wrong statuses %v", batchErr.Statuses)
+	}
+	t.Log(err)
+	ins.TakeSlice()
+	for _, format := range []string{FormatMsgpack, FormatProtobuf} {
+		err = SendWithParams(config, SendParams{Table: "table", Fields: "field1, field2", Sync: true, Format: format}, [][]interface{}{
+			{1, "2"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := ins.TakeSlice(); len(data) != 1 {
+			t.Fatalf("didn't insert %s", format)
+		}
+	}
+	receiptID, err := SendWithReceipt(config, SendParams{Table: "table", Fields: "field1", TimeoutMs: 10, MaxRows: 1}, [][]interface{}{{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := NewClient(config).SendBatchWithStatuses([]BatchEntry{
+		{Table: "table", Fields: "field1", Rows: [][]interface{}{{"1"}}, TimeoutMs: 10, MaxRows: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receiptID == "" || statuses[0].Receipt == "" {
+		t.Fatalf("should get receipts, got %q and %v", receiptID, statuses)
+	}
+	time.Sleep(time.Millisecond * 100)
+	for _, id := range []string{receiptID, statuses[0].Receipt} {
+		receipt, err := GetReceipt(config, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.Status != ReceiptCommitted || receipt.Inserters["first"].Status != ReceiptCommitted {
+			t.Errorf("receipt should be committed, got %v", receipt)
+		}
+	}
+	if _, err := GetReceipt(config, "unknown"); err != ErrReceiptNotFound {
+		t.Errorf("should be ErrReceiptNotFound, got %v", err)
+	}
+	ins.TakeSlice()
 
 	config = ClientConfig{
 		ServerAddress: "ftp://" + bind,
@@ -127,6 +323,18 @@ func TestSend(t *testing.T) {
 
 //bencmarks in below are for running dbatcher instance
 func BenchmarkSingleRequestsKeepAlive(b *testing.B) {
+	benchmarkSingleRequestsKeepAlive(b, FormatJSON)
+}
+
+func BenchmarkSingleRequestsKeepAliveMsgpack(b *testing.B) {
+	benchmarkSingleRequestsKeepAlive(b, FormatMsgpack)
+}
+
+func BenchmarkSingleRequestsKeepAliveProtobuf(b *testing.B) {
+	benchmarkSingleRequestsKeepAlive(b, FormatProtobuf)
+}
+
+func benchmarkSingleRequestsKeepAlive(b *testing.B, format string) {
 	rows := [][]interface{}{
 		{
 			0,
@@ -138,15 +346,19 @@ func BenchmarkSingleRequestsKeepAlive(b *testing.B) {
 	}
 
 	config := ClientConfig{
-		"http://127.0.0.1:8124", 2 * time.Second, 2 * time.Second,
+		"http://127.0.0.1:8124", 2 * time.Second, 2 * time.Second, "",
 	}
 	client := NewClient(config)
+	params := SendParams{
+		Table:     "`visited_url`",
+		Fields:    "dt,url, sourse_url, response_time_ms, found_urls",
+		TimeoutMs: 100000,
+		MaxRows:   10000,
+		Format:    format,
+	}
 	for i := 0; i < b.N; i++ {
 		rows[0][0] = time.Now().Format("2006-01-02 15:04:05.999")
-		err := client.Send(
-			"`visited_url`", "dt,url, sourse_url, response_time_ms, found_urls",
-			100000, 10000, false, false, rows,
-		)
+		err := client.SendWithParams(params, rows)
 		if err != nil {
 			b.Error(err)
 			b.FailNow()
@@ -168,7 +380,7 @@ func BenchmarkSingleRequestsNoKeepAlive(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		config := ClientConfig{
-			"http://127.0.0.1:8124", 2 * time.Second, 2 * time.Second,
+			"http://127.0.0.1:8124", 2 * time.Second, 2 * time.Second, "",
 		}
 		rows[0][0] = time.Now().Format("2006-01-02 15:04:05.999")
 		err := Send(
@@ -183,6 +395,39 @@ func BenchmarkSingleRequestsNoKeepAlive(b *testing.B) {
 	}
 }
 
+//benchmarks of rows encoding, they don't need dbatcher instance
+
+func BenchmarkMarshalRowsJSON(b *testing.B) {
+	benchmarkMarshalRows(b, FormatJSON)
+}
+
+func BenchmarkMarshalRowsMsgpack(b *testing.B) {
+	benchmarkMarshalRows(b, FormatMsgpack)
+}
+
+func BenchmarkMarshalRowsProtobuf(b *testing.B) {
+	benchmarkMarshalRows(b, FormatProtobuf)
+}
+
+func benchmarkMarshalRows(b *testing.B, format string) {
+	rows := make([][]interface{}, 100)
+	for i := range rows {
+		rows[i] = []interface{}{
+			"2021-11-13 10:53:10.123",
+			"htp://site.example/path0/path1/path2?param0=value0&param1=value1&param3=value3",
+			"htp://site.example/path0/path1?param0=value0&param1=value1&param3=value3",
+			666666 + i,
+			0.5,
+		}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := marshalRows(format, rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkTimeNow(b *testing.B) {
 	t := time.Now()
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,75 @@
+package httpclient
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+//Compressions of request body (values of ClientConfig.Compression),
+//they are sent as Content-Encoding
+const (
+	//CompressionGzip compresses body with gzip
+	CompressionGzip = "gzip"
+	//CompressionZstd compresses body with zstd
+	CompressionZstd = "zstd"
+	//CompressionBrotli compresses body with brotli
+	CompressionBrotli = "br"
+)
+
+//ErrUnknownCompression means ClientConfig.Compression has unknown value
+var ErrUnknownCompression = errors.New("dbatcher http client: compression should be gzip, zstd or br")
+
+var (
+	gzipWriterPool sync.Pool
+
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderOnce sync.Once
+	zstdEncoderErr  error
+)
+
+//compress returns data compressed with compression
+func compress(compression string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	switch compression {
+	case CompressionGzip:
+		writer, ok := gzipWriterPool.Get().(*gzip.Writer)
+		if ok {
+			writer.Reset(&b)
+		} else {
+			writer = gzip.NewWriter(&b)
+		}
+		defer gzipWriterPool.Put(writer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		//EncodeAll of zstd encoder is safe for concurrent use
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+		})
+		if zstdEncoderErr != nil {
+			return nil, zstdEncoderErr
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionBrotli:
+		writer := brotli.NewWriterLevel(&b, brotli.DefaultCompression)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownCompression
+	}
+
+	return b.Bytes(), nil
+}
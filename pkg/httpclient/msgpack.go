@@ -0,0 +1,193 @@
+package httpclient
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//ErrUnsupportedType means a value of rows can't be encoded to a binary format
+var ErrUnsupportedType = errors.New("dbatcher http client: unsupported value type")
+
+//marshalMsgpack encodes rows as MessagePack. Primitives, slices and
+//maps with string keys are supported
+func marshalMsgpack(rows interface{}) ([]byte, error) {
+	if reflect.ValueOf(rows).Kind() != reflect.Slice {
+		return nil, ErrRowsNotSlice
+	}
+
+	return appendMsgpack(make([]byte, 0, 1024), rows)
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	//common types without reflection
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case string:
+		return appendMsgpackString(b, v), nil
+	case int:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(b, v), nil
+	case float64:
+		return appendMsgpackFloat64(b, v), nil
+	case []interface{}:
+		b = appendMsgpackCollectionHeader(b, 0x90, 0xdc, len(v))
+		var err error
+		for _, el := range v {
+			if b, err = appendMsgpack(b, el); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return appendMsgpackValue(b, reflect.ValueOf(v))
+}
+
+func appendMsgpackValue(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	switch v.Kind() {
+	case reflect.Invalid:
+		return append(b, 0xc0), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendMsgpackValue(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(b, v.Uint()), nil
+	case reflect.Float32:
+		b = append(b, 0xca)
+		return appendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendMsgpackFloat64(b, v.Float()), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			b = appendMsgpackLengthHeader(b, 0xc4, v.Len())
+			return append(b, v.Bytes()...), nil
+		}
+		b = appendMsgpackCollectionHeader(b, 0x90, 0xdc, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendMsgpackValue(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, errors.Wrap(ErrUnsupportedType, v.Type().String())
+		}
+		b = appendMsgpackCollectionHeader(b, 0x80, 0xde, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			b = appendMsgpackString(b, iter.Key().String())
+			if b, err = appendMsgpackValue(b, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return nil, errors.Wrap(ErrUnsupportedType, v.Type().String())
+}
+
+//appendMsgpackCollectionHeader appends a header of array or map of n elements:
+//fixed is the type of up to 15 elements, next types are 16 and 32 bits lengths
+func appendMsgpackCollectionHeader(b []byte, fixed, first byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fixed|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, first), uint16(n))
+	default:
+		return appendUint32(append(b, first+1), uint32(n))
+	}
+}
+
+//appendMsgpackLengthHeader appends a header of str or bin of n bytes:
+//first is the type of 8 bits length, next types are 16 and 32 bits lengths
+func appendMsgpackLengthHeader(b []byte, first byte, n int) []byte {
+	switch {
+	case n <= math.MaxUint8:
+		return append(b, first, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, first+1), uint16(n))
+	default:
+		return appendUint32(append(b, first+2), uint32(n))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	if len(s) < 32 {
+		b = append(b, 0xa0|byte(len(s)))
+	} else {
+		b = appendMsgpackLengthHeader(b, 0xd9, len(s))
+	}
+
+	return append(b, s...)
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendMsgpackUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(int8(n)))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(int8(n)))
+	case n >= math.MinInt16:
+		return appendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(n))
+	default:
+		return appendUint64(append(b, 0xd3), uint64(n))
+	}
+}
+
+func appendMsgpackUint(b []byte, n uint64) []byte {
+	switch {
+	case n <= math.MaxInt8:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(n))
+	default:
+		return appendUint64(append(b, 0xcf), n)
+	}
+}
+
+func appendMsgpackFloat64(b []byte, f float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//marshalProtobuf encodes rows as Rows message of clients/rows.proto.
+//Rows should be a slice of slices of primitives or nils
+func marshalProtobuf(rows interface{}) ([]byte, error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return nil, ErrRowsNotSlice
+	}
+	b := make([]byte, 0, 1024)
+	var rowBuf, valueBuf []byte
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		for row.Kind() == reflect.Interface || row.Kind() == reflect.Ptr {
+			row = row.Elem()
+		}
+		if row.Kind() != reflect.Slice && row.Kind() != reflect.Array {
+			return nil, ErrRowsNotSlice
+		}
+		rowBuf = rowBuf[:0]
+		for j := 0; j < row.Len(); j++ {
+			var err error
+			if valueBuf, err = appendProtobufValue(valueBuf[:0], row.Index(j)); err != nil {
+				return nil, err
+			}
+			rowBuf = protowire.AppendTag(rowBuf, 1, protowire.BytesType)
+			rowBuf = protowire.AppendBytes(rowBuf, valueBuf)
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, rowBuf)
+	}
+
+	return b, nil
+}
+
+//appendProtobufValue appends fields of Value message, nothing for nil
+func appendProtobufValue(b []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return b, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return b, nil
+		}
+		return appendProtobufValue(b, v.Elem())
+	case reflect.String:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		return protowire.AppendString(b, v.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeZigZag(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		return protowire.AppendVarint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float())), nil
+	case reflect.Bool:
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool())), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = protowire.AppendTag(b, 1, protowire.BytesType)
+			return protowire.AppendBytes(b, v.Bytes()), nil
+		}
+	}
+
+	return nil, errors.Wrap(ErrUnsupportedType, v.Type().String())
+}
@@ -0,0 +1,62 @@
+package httpclient
+
+import (
+	"net/url"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+//receiptHeader has ID of receipt of the batch sent rows joined
+const receiptHeader = "X-Dbatcher-Receipt"
+
+//Statuses of Receipt and InserterReceipt
+const (
+	ReceiptPending   = "pending"
+	ReceiptCommitted = "committed"
+	ReceiptFailed    = "failed"
+	ReceiptSpooled   = "spooled"
+)
+
+//ErrReceiptNotFound means dbatcher doesn't have the receipt:
+//it's expired or dbatcher was restarted
+var ErrReceiptNotFound = errors.New("dbatcher http client: receipt not found")
+
+//Receipt is a state of a batch that sent rows joined.
+//Status is pending until all inserters are done (including retries
+//of spooled batch), then failed if an inserter failed
+type Receipt struct {
+	ID         string                     `json:"id"`
+	Table      string                     `json:"table"`
+	Status     string                     `json:"status"`
+	Inserters  map[string]InserterReceipt `json:"inserters"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+//InserterReceipt is a state of a batch's insert by an inserter
+type InserterReceipt struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+//GetReceipt returns receipt by ID got from SendWithReceipt
+//or SendBatchWithStatuses
+func (c Client) GetReceipt(id string) (Receipt, error) {
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	request.Header.SetMethod(fasthttp.MethodGet)
+	request.SetRequestURI(c.serverAddress + "/receipts/" + url.PathEscape(id))
+	var receipt Receipt
+	err := c.do(request, func(response *fasthttp.Response) error {
+		return errors.Wrap(jsoniter.Unmarshal(response.Body(), &receipt), "dbatcher http client: receipt")
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == fasthttp.StatusNotFound {
+		return Receipt{}, ErrReceiptNotFound
+	}
+
+	return receipt, err
+}
@@ -8,3 +8,40 @@ func Send(config ClientConfig, table, fields string, timeoutMs, maxRows uint, sy
 
 	return client.Send(table, fields, timeoutMs, maxRows, sync, persist, rows)
 }
+
+//SendWithParams creates Client inside and sends request with params to dbatcher.
+//Use if you need to send single request or if performance is not a bottleneck.
+func SendWithParams(config ClientConfig, params SendParams, rows interface{}) error {
+	client := NewClient(config)
+	defer client.Close()
+
+	return client.SendWithParams(params, rows)
+}
+
+//SendBatch creates Client inside and sends rows of several tables to dbatcher.
+//Use if you need to send single request or if performance is not a bottleneck.
+func SendBatch(config ClientConfig, entries []BatchEntry) error {
+	client := NewClient(config)
+	defer client.Close()
+
+	return client.SendBatch(entries)
+}
+
+//SendWithReceipt creates Client inside and sends request with params to dbatcher,
+//returns ID of the receipt. Use if you need to send single request
+//or if performance is not a bottleneck.
+func SendWithReceipt(config ClientConfig, params SendParams, rows interface{}) (string, error) {
+	client := NewClient(config)
+	defer client.Close()
+
+	return client.SendWithReceipt(params, rows)
+}
+
+//GetReceipt creates Client inside and gets receipt by ID from dbatcher.
+//Use if you need to send single request or if performance is not a bottleneck.
+func GetReceipt(config ClientConfig, id string) (Receipt, error) {
+	client := NewClient(config)
+	defer client.Close()
+
+	return client.GetReceipt(id)
+}
@@ -33,4 +33,49 @@ CREATE TABLE default.dbatcher_test_table
 )
 ENGINE = MergeTree
 ORDER BY dateNumber
-SETTINGS index_granularity = 8192;
\ No newline at end of file
+SETTINGS index_granularity = 8192;
+
+DROP TABLE IF EXISTS default.dbatcher_test_narrow_table;
+CREATE TABLE default.dbatcher_test_narrow_table
+(
+    `stringString` String
+)
+ENGINE = MergeTree
+ORDER BY stringString;
+
+DROP TABLE IF EXISTS default.dbatcher_test_wide_table;
+CREATE TABLE default.dbatcher_test_wide_table
+(
+    `column1` UInt32,
+    `column2` String,
+    `column3` UInt32,
+    `column4` String,
+    `column5` UInt32,
+    `column6` String,
+    `column7` UInt32,
+    `column8` String,
+    `column9` UInt32,
+    `column10` String,
+    `column11` UInt32,
+    `column12` String,
+    `column13` UInt32,
+    `column14` String,
+    `column15` UInt32,
+    `column16` String,
+    `column17` UInt32,
+    `column18` String,
+    `column19` UInt32,
+    `column20` String,
+    `column21` UInt32,
+    `column22` String,
+    `column23` UInt32,
+    `column24` String,
+    `column25` UInt32,
+    `column26` String,
+    `column27` UInt32,
+    `column28` String,
+    `column29` UInt32,
+    `column30` String
+)
+ENGINE = MergeTree
+ORDER BY column1;
\ No newline at end of file
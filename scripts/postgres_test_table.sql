@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS public.dbatcher_test_table;
+CREATE TABLE public.dbatcher_test_table(
+	id INTEGER PRIMARY KEY,
+	"smallIntNumber" SMALLINT,
+	"bigIntString" BIGINT,
+	"doubleNumber" DOUBLE PRECISION,
+	"numericString" NUMERIC(10, 2),
+	"boolBool" BOOLEAN,
+	"textString" TEXT,
+	"varcharString" VARCHAR(255),
+	"dateString" DATE,
+	"timestampString" TIMESTAMP,
+	"timestampNumber" TIMESTAMP WITH TIME ZONE,
+	"jsonbObject" JSONB,
+	"nullableText" TEXT
+)